@@ -0,0 +1,258 @@
+// Package cron provides schedules based on cron expressions.
+package cron
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearchYears limits how far to the future the next time is searched for.
+// Schedules such as `0 0 30 2 *` never match any time.
+const maxSearchYears = 5
+
+var ErrUnexpectedType = errors.New("unexpected type for cron schedule")
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var weekdayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// field describes the allowed values of a single field in the cron expression.
+type field struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var (
+	fieldMinute     = field{name: "minute", min: 0, max: 59}
+	fieldHour       = field{name: "hour", min: 0, max: 23}
+	fieldDayOfMonth = field{name: "day of month", min: 1, max: 31}
+	fieldMonth      = field{name: "month", min: 1, max: 12, names: monthNames}
+	fieldDayOfWeek  = field{name: "day of week", min: 0, max: 7, names: weekdayNames}
+)
+
+// Schedule is a cron schedule with the standard five fields:
+// minute, hour, day of month, month, and day of week.
+// The zero value is an empty schedule.
+type Schedule struct {
+	spec       string
+	minutes    uint64
+	hours      uint64
+	daysOfMon  uint64
+	months     uint64
+	daysOfWeek uint64
+
+	// anyDay is true when either of the day fields is `*`.
+	// Otherwise, the time matches when either of the day fields matches.
+	anyDay bool
+}
+
+func Parse(spec string) (s Schedule, err error) {
+	err = s.FromString(spec)
+	return
+}
+
+func MustParse(spec string) (s Schedule) {
+	if err := s.FromString(spec); err != nil {
+		panic(err)
+	}
+	return
+}
+
+// FromString parses the cron expression. Besides the five fields,
+// the macros such as `@daily` and `@hourly` are supported.
+func (s *Schedule) FromString(spec string) error {
+	spec = strings.TrimSpace(spec)
+	expr := spec
+	if macro, ok := macros[strings.ToLower(spec)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return fmt.Errorf("expected 5 fields in cron expression '%s', got %d", spec, len(fields))
+	}
+
+	var parsed Schedule
+	var err error
+	if parsed.minutes, err = fieldMinute.parse(fields[0]); err != nil {
+		return err
+	}
+	if parsed.hours, err = fieldHour.parse(fields[1]); err != nil {
+		return err
+	}
+	if parsed.daysOfMon, err = fieldDayOfMonth.parse(fields[2]); err != nil {
+		return err
+	}
+	if parsed.months, err = fieldMonth.parse(fields[3]); err != nil {
+		return err
+	}
+	if parsed.daysOfWeek, err = fieldDayOfWeek.parse(fields[4]); err != nil {
+		return err
+	}
+
+	// Both 0 and 7 are Sunday
+	if parsed.daysOfWeek&(1<<7) != 0 {
+		parsed.daysOfWeek = parsed.daysOfWeek&^(1<<7) | 1
+	}
+	parsed.anyDay = strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[4], "*")
+	parsed.spec = spec
+	*s = parsed
+	return nil
+}
+
+// parse parses a comma separated list of values, ranges, and steps
+// e.g. `1,15-20,*/10` into a bit set.
+func (f *field) parse(s string) (set uint64, err error) {
+	for part := range strings.SplitSeq(s, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step '%s' in %s field", stepPart, f.name)
+			}
+		}
+
+		var start, end int
+		if rangePart == "*" {
+			start, end = f.min, f.max
+		} else if startPart, endPart, isRange := strings.Cut(rangePart, "-"); isRange {
+			if start, err = f.value(startPart); err != nil {
+				return 0, err
+			}
+			if end, err = f.value(endPart); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("invalid range '%s' in %s field", rangePart, f.name)
+			}
+		} else {
+			if start, err = f.value(rangePart); err != nil {
+				return 0, err
+			}
+			end = start
+			if hasStep {
+				end = f.max
+			}
+		}
+
+		for i := start; i <= end; i += step {
+			set |= 1 << i
+		}
+	}
+	return set, nil
+}
+
+func (f *field) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value '%s' in %s field", s, f.name)
+	}
+	return v, nil
+}
+
+func (s Schedule) IsEmpty() bool {
+	return s.spec == ""
+}
+
+func (s Schedule) String() string {
+	return s.spec
+}
+
+func (s Schedule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.spec)
+}
+
+func (s *Schedule) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case nil:
+		*s = Schedule{}
+		return nil
+	case string:
+		if value == "" {
+			*s = Schedule{}
+			return nil
+		}
+		return s.FromString(value)
+	default:
+		return ErrUnexpectedType
+	}
+}
+
+// Next returns the first time after the given time that matches the schedule.
+// The schedule is matched in the location of the given time.
+// Zero time is returned when the schedule is empty or nothing matches.
+func (s Schedule) Next(t time.Time) time.Time {
+	if s.IsEmpty() {
+		return time.Time{}
+	}
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if s.months&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hours&(1<<t.Hour()) == 0 {
+			t = nextHour(t)
+			continue
+		}
+		if s.minutes&(1<<t.Minute()) == 0 {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// nextHour returns the start of the next hour. The hour is added
+// as a duration, so that the daylight saving time transitions
+// don't cause the same hour to be returned again.
+func nextHour(t time.Time) time.Time {
+	next := t.Add(time.Hour)
+	return next.Add(-time.Duration(next.Minute()) * time.Minute)
+}
+
+func (s Schedule) matchDay(t time.Time) bool {
+	domMatch := s.daysOfMon&(1<<t.Day()) != 0
+	dowMatch := s.daysOfWeek&(1<<int(t.Weekday())) != 0
+	if s.anyDay {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
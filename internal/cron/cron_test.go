@@ -0,0 +1,83 @@
+package cron
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNext(t *testing.T) {
+	helsinki, err := time.LoadLocation("Europe/Helsinki")
+	require.NoError(t, err, "load location")
+	// Friday
+	start := time.Date(2025, 5, 2, 12, 30, 15, 0, time.UTC)
+
+	tests := []struct {
+		spec     string
+		from     time.Time
+		expected time.Time
+	}{
+		{"* * * * *", start, time.Date(2025, 5, 2, 12, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", start, time.Date(2025, 5, 2, 12, 45, 0, 0, time.UTC)},
+		{"0 2 * * *", start, time.Date(2025, 5, 3, 2, 0, 0, 0, time.UTC)},
+		{"@daily", start, time.Date(2025, 5, 3, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", start, time.Date(2025, 5, 2, 13, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", start, time.Date(2025, 5, 5, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", start.Add(-4 * time.Hour), time.Date(2025, 5, 2, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", start, time.Date(2025, 5, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", start, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 12 29 2 *", start, time.Date(2028, 2, 29, 12, 30, 0, 0, time.UTC)},
+		{"0 0 30 2 *", start, time.Time{}},
+		// Either day field matches when both are restricted
+		{"0 0 10 * sat", start, time.Date(2025, 5, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 2 * * *", start.In(helsinki), time.Date(2025, 5, 3, 2, 0, 0, 0, helsinki)},
+		// 03:30 doesn't exist in Helsinki when the DST starts, so the day is skipped
+		{"30 3 * * *", time.Date(2025, 3, 30, 0, 0, 0, 0, helsinki), time.Date(2025, 3, 31, 3, 30, 0, 0, helsinki)},
+		// 03:30 occurs twice in Helsinki when the DST ends
+		{"30 3 * * *", time.Date(2025, 10, 26, 0, 45, 0, 0, time.UTC).In(helsinki), time.Date(2025, 10, 26, 1, 30, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.spec)
+		require.NoError(t, err, test.spec)
+		assert.True(t, test.expected.Equal(s.Next(test.from)), "%s: expected %s, got %s", test.spec, test.expected, s.Next(test.from))
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"@every 5m",
+		"* * * foo *",
+	}
+	for _, spec := range specs {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestScheduleJSON(t *testing.T) {
+	var v struct {
+		Schedule Schedule `json:"schedule"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(`{"schedule": "0 2 * * *"}`), &v))
+	assert.Equal(t, MustParse("0 2 * * *"), v.Schedule)
+	assert.Equal(t, "0 2 * * *", v.Schedule.String())
+
+	b, err := json.Marshal(&v)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"schedule": "0 2 * * *"}`, string(b))
+
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"schedule": 5}`), &v), ErrUnexpectedType)
+	assert.Error(t, json.Unmarshal([]byte(`{"schedule": "* *"}`), &v))
+}
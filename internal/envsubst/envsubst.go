@@ -10,7 +10,17 @@ var (
 	envSubstRe = regexp.MustCompile(`\$?\$\{([^}]+)\}`)
 )
 
+// Replace replaces the variables in the text with the values from the map.
 func Replace(text string, vars map[string]string) (string, error) {
+	return ReplaceFunc(text, func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	})
+}
+
+// ReplaceFunc replaces the variables in the text with the values returned
+// by the lookup function. The lookup function reports whether the key was found.
+func ReplaceFunc(text string, lookup func(key string) (string, bool)) (string, error) {
 	unknownKeys := make(map[string]bool)
 	s := envSubstRe.ReplaceAllStringFunc(text, func(match string) string {
 		// replace escaped characters
@@ -21,7 +31,7 @@ func Replace(text string, vars map[string]string) (string, error) {
 		// remove surrounding ${} characters
 		key := strings.TrimSpace(match[2 : len(match)-1])
 
-		v, ok := vars[key]
+		v, ok := lookup(key)
 		if !ok {
 			unknownKeys[key] = true
 		}
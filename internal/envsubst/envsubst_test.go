@@ -57,3 +57,20 @@ func TestReplaceMissingKeys(t *testing.T) {
 
 	assert.Equal(expectedText, actualText)
 }
+
+func TestReplaceFunc(t *testing.T) {
+	assert := assert.New(t)
+
+	text := "token = ${file:/run/secrets/token}, missing = ${MISSING}"
+	lookup := func(key string) (string, bool) {
+		if key == "file:/run/secrets/token" {
+			return "secret", true
+		}
+		return "", false
+	}
+
+	actualText, err := ReplaceFunc(text, lookup)
+
+	assert.EqualError(err, "no value found for keys: MISSING")
+	assert.Equal("token = secret, missing = ", actualText)
+}
@@ -1,6 +1,8 @@
 package gitsync
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 
@@ -10,10 +12,188 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/logging"
+	"go.lepovirta.org/otk/internal/osenv"
 	"golang.org/x/crypto/ssh"
 )
 
-const defaultGitUsername = "git"
+const (
+	defaultGitUsername = "git"
+	reasonCredentials  = "failed to resolve credentials"
+)
+
+// CredentialsError is used when the credentials of a repository
+// can't be resolved before a fetch or a push.
+type CredentialsError struct {
+	RepoId string
+	Cause  error
+}
+
+func (e *CredentialsError) Error() string {
+	return fmt.Sprintf("failed to resolve credentials for repo '%s': %s", e.RepoId, e.Cause)
+}
+
+func (e *CredentialsError) Unwrap() error {
+	return e.Cause
+}
+
+// failureReason returns the reason for the given error, or the reason
+// for the credentials when the credentials couldn't be resolved.
+func failureReason(err error, reason string) string {
+	var credentialsErr *CredentialsError
+	if errors.As(err, &credentialsErr) {
+		return reasonCredentials
+	}
+	return reason
+}
+
+// credentialSource acquires short-lived credentials for a repository.
+type credentialSource interface {
+	// get returns the credentials, which may be cached.
+	get(ctx context.Context, log *slog.Logger) (transport.AuthMethod, error)
+
+	// invalidate removes the cached credentials.
+	invalidate()
+}
+
+// authProvider provides the auth method for a repository.
+// When the credentials are read from files, a credential helper,
+// or a credential source,
+// the auth method is created again on every use, so that rotated
+// credentials are picked up without restarting.
+type authProvider struct {
+	fs       billy.Filesystem
+	repoId   string
+	config   *config.Repository
+	auth     transport.AuthMethod
+	rotating bool
+	helper   *credentialHelper
+	source   credentialSource
+}
+
+func (p *authProvider) init(
+	osEnv *osenv.OsEnv,
+	repoId string,
+	repoConfig *config.Repository,
+	log *slog.Logger,
+) (err error) {
+	fs := osEnv.Fs
+	p.fs = fs
+	p.repoId = repoId
+	p.config = repoConfig
+	if repoConfig.AuthMethod() == config.AuthMethodCredentialHelper {
+		log.Debug("using credential helper for auth", slog.String("command", repoConfig.CredentialHelper.Command))
+		p.rotating = true
+		p.helper = &credentialHelper{}
+		return p.helper.init(&repoConfig.CredentialHelper, repoConfig.URL)
+	}
+	if repoConfig.AuthMethod() == config.AuthMethodExec {
+		log.Debug("using command for auth", slog.String("command", repoConfig.Exec.Command))
+		p.rotating = true
+		execCreds := &execCredentials{}
+		execCreds.init(&repoConfig.Exec, repoConfig.SshCredentials, osEnv.EnvVars.All())
+		p.source = execCreds
+		return nil
+	}
+	if repoConfig.AuthMethod() == config.AuthMethodGitHubApp {
+		log.Debug(
+			"using GitHub App for auth",
+			slog.Int64("appId", repoConfig.GitHubApp.AppId),
+			slog.Int64("installationId", repoConfig.GitHubApp.InstallationId),
+		)
+		p.rotating = true
+		app := &githubApp{}
+		app.init(fs, osEnv.HttpTransport, &repoConfig.GitHubApp)
+		p.source = app
+		return nil
+	}
+	p.rotating = repoConfig.HasSecretFiles() ||
+		repoConfig.AuthMethod() == config.AuthMethodSshKey
+	p.auth, err = configToAuth(fs, repoConfig, log)
+	return
+}
+
+// get returns the auth method for the next fetch or push.
+func (p *authProvider) get(ctx context.Context) (transport.AuthMethod, error) {
+	if !p.rotating {
+		return p.auth, nil
+	}
+	log := logging.FromContext(ctx).With(slog.String("repoId", p.repoId))
+
+	if p.helper != nil {
+		auth, err := p.helper.get(ctx)
+		if err != nil {
+			return nil, &CredentialsError{RepoId: p.repoId, Cause: err}
+		}
+		log.DebugContext(
+			ctx, "credentials resolved",
+			slog.String("authMethod", config.AuthMethodCredentialHelper.String()),
+			slog.String("username", auth.Username),
+		)
+		return auth, nil
+	}
+
+	if p.source != nil {
+		auth, err := p.source.get(ctx, log)
+		if err != nil {
+			return nil, &CredentialsError{RepoId: p.repoId, Cause: err}
+		}
+		return auth, nil
+	}
+
+	repoConfig := *p.config
+	if err := repoConfig.ReadSecretFiles(p.fs); err != nil {
+		return nil, &CredentialsError{RepoId: p.repoId, Cause: err}
+	}
+	auth, err := configToAuth(p.fs, &repoConfig, log)
+	if err != nil {
+		return nil, &CredentialsError{RepoId: p.repoId, Cause: err}
+	}
+	log.DebugContext(
+		ctx, "credentials resolved",
+		slog.String("authMethod", repoConfig.AuthMethod().String()),
+	)
+	return auth, nil
+}
+
+// approve stores the credentials to the credential helper
+// after they have been accepted by the remote. The credentials are
+// stored only when they are fresh from the helper.
+func (p *authProvider) approve(ctx context.Context, auth transport.AuthMethod) {
+	basicAuth, ok := auth.(*http.BasicAuth)
+	if p.helper == nil || !ok {
+		return
+	}
+	if err := p.helper.store(ctx, basicAuth); err != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "failed to store credentials", slog.Any("error", err))
+	}
+}
+
+// reject erases the credentials from the credential helper and
+// the credential source cache after they have been rejected by the remote,
+// so that the invalid credentials are not used again.
+func (p *authProvider) reject(ctx context.Context, auth transport.AuthMethod, err error) {
+	if !isAuthError(err) {
+		return
+	}
+	if p.source != nil {
+		p.source.invalidate()
+	}
+	basicAuth, ok := auth.(*http.BasicAuth)
+	if p.helper == nil || !ok {
+		return
+	}
+	logging.FromContext(ctx).InfoContext(ctx, "credentials rejected by the remote, erasing them from the credential helper")
+	if err := p.helper.erase(ctx, basicAuth); err != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "failed to erase credentials", slog.Any("error", err))
+	}
+}
+
+// isAuthError reports whether the error is caused by the remote rejecting the credentials.
+func isAuthError(err error) bool {
+	return errors.Is(err, transport.ErrAuthenticationRequired) ||
+		errors.Is(err, transport.ErrAuthorizationFailed)
+}
 
 func configToAuth(
 	fs billy.Filesystem,
@@ -48,6 +228,8 @@ func configToAuth(
 		return auth, nil
 	case config.AuthMethodSshKey:
 		return sshKeyAuth(fs, repoConfig.SshCredentials, log)
+	case config.AuthMethodCredentialHelper, config.AuthMethodExec, config.AuthMethodGitHubApp:
+		return nil, fmt.Errorf("%s auth must be resolved on use", repoConfig.AuthMethod())
 	default:
 		return nil, fmt.Errorf("unknown auth method")
 	}
@@ -58,12 +240,6 @@ func sshKeyAuth(
 	creds config.SshCredentials,
 	log *slog.Logger,
 ) (transport.AuthMethod, error) {
-	username := creds.Username
-	if username == "" {
-		username = defaultGitUsername
-	}
-	log.Debug("using ssh key auth", slog.String("username", username))
-
 	sshKeyBytes, err := util.ReadFile(fs, creds.KeyPath)
 	log.Debug("ssh key read", slog.Int("bytes", len(sshKeyBytes)))
 	if err != nil {
@@ -73,17 +249,31 @@ func sshKeyAuth(
 			err,
 		)
 	}
+	return sshPublicKeysAuth(creds, sshKeyBytes, creds.KeyPassword, log)
+}
+
+func sshPublicKeysAuth(
+	creds config.SshCredentials,
+	sshKeyBytes []byte,
+	keyPassword string,
+	log *slog.Logger,
+) (transport.AuthMethod, error) {
+	username := creds.Username
+	if username == "" {
+		username = defaultGitUsername
+	}
+	log.Debug("using ssh key auth", slog.String("username", username))
 
 	auth, err := gitssh.NewPublicKeys(
 		username,
 		sshKeyBytes,
-		creds.KeyPassword,
+		keyPassword,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to configure SSH key auth: %w", err)
 	}
 
-	if creds.IgnoreHostKey {
+	if creds.IgnoresHostKey() {
 		log.Warn("disabling SSH host key check")
 		auth.HostKeyCallback = ssh.InsecureIgnoreHostKey()
 	} else if creds.HostKey != "" {
@@ -0,0 +1,68 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	fsutil "github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/osenv"
+)
+
+func TestAuthProviderRotatesSecretFiles(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	ctx := context.Background()
+	fs := memfs.New()
+	require.NoError(fsutil.WriteFile(fs, "/run/secrets/token", []byte("token1\n"), 0o600))
+	repoConfig := config.Repository{
+		URL: "https://gitlab.com/jpallari/otk.git",
+		Credentials: config.Credentials{
+			HttpToken:     "token1",
+			HttpTokenFile: "/run/secrets/token",
+		},
+	}
+
+	var provider authProvider
+	require.NoError(provider.init(&osenv.OsEnv{Fs: fs}, "otk-gitlab", &repoConfig, slog.Default()))
+	auth, err := provider.get(ctx)
+	require.NoError(err)
+	assert.Equal(&http.TokenAuth{Token: "token1"}, auth)
+
+	require.NoError(fsutil.WriteFile(fs, "/run/secrets/token", []byte("token2\n"), 0o600))
+	auth, err = provider.get(ctx)
+	require.NoError(err)
+	assert.Equal(&http.TokenAuth{Token: "token2"}, auth)
+	assert.Equal("token1", repoConfig.HttpToken, "config is not modified")
+
+	require.NoError(fs.Remove("/run/secrets/token"))
+	_, err = provider.get(ctx)
+	var credentialsErr *CredentialsError
+	require.ErrorAs(err, &credentialsErr)
+	assert.Equal("otk-gitlab", credentialsErr.RepoId)
+	assert.NotContains(err.Error(), "token2")
+	assert.Equal(reasonCredentials, failureReason(fmt.Errorf("wrapped: %w", err), "failed to fetch"))
+}
+
+func TestAuthProviderStatic(t *testing.T) {
+	require := require.New(t)
+	repoConfig := config.Repository{
+		URL:         "https://gitlab.com/jpallari/otk.git",
+		Credentials: config.Credentials{HttpToken: "token"},
+	}
+
+	var provider authProvider
+	require.NoError(provider.init(&osenv.OsEnv{Fs: memfs.New()}, "otk-gitlab", &repoConfig, slog.Default()))
+	first, err := provider.get(context.Background())
+	require.NoError(err)
+	second, err := provider.get(context.Background())
+	require.NoError(err)
+	assert.Same(t, first, second)
+	assert.Equal(t, "failed to fetch", failureReason(fmt.Errorf("other"), "failed to fetch"))
+}
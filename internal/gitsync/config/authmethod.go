@@ -31,6 +31,18 @@ const (
 
 	// AuthMethodSshKey means that SSH keys are used for authentication.
 	AuthMethodSshKey
+
+	// AuthMethodCredentialHelper means that HTTP basic auth credentials
+	// are acquired from a Git credential helper.
+	AuthMethodCredentialHelper
+
+	// AuthMethodExec means that the credentials are acquired
+	// by running an external command.
+	AuthMethodExec
+
+	// AuthMethodGitHubApp means that GitHub App installation access tokens
+	// are used for authentication.
+	AuthMethodGitHubApp
 )
 
 func (a AuthMethod) MarshalJSON() ([]byte, error) {
@@ -48,6 +60,12 @@ func (a AuthMethod) MarshalJSON() ([]byte, error) {
 		s = "ssh-agent"
 	case AuthMethodSshKey:
 		s = "ssh"
+	case AuthMethodCredentialHelper:
+		s = "credential-helper"
+	case AuthMethodExec:
+		s = "exec"
+	case AuthMethodGitHubApp:
+		s = "github-app"
 	default:
 		return nil, fmt.Errorf("unknown auth method '%s'", a)
 	}
@@ -72,6 +90,12 @@ func (a *AuthMethod) UnmarshalJSON(b []byte) error {
 		*a = AuthMethodSshAgent
 	case "ssh", "ssh-key":
 		*a = AuthMethodSshKey
+	case "credential-helper":
+		*a = AuthMethodCredentialHelper
+	case "exec":
+		*a = AuthMethodExec
+	case "github-app":
+		*a = AuthMethodGitHubApp
 	default:
 		return fmt.Errorf("unexpected value '%s' for auth method", v)
 	}
@@ -92,6 +116,12 @@ func (a AuthMethod) String() string {
 		return "ssh-agent"
 	case AuthMethodSshKey:
 		return "ssh"
+	case AuthMethodCredentialHelper:
+		return "credential-helper"
+	case AuthMethodExec:
+		return "exec"
+	case AuthMethodGitHubApp:
+		return "github-app"
 	default:
 		return fmt.Sprintf("unknown(%d)", a)
 	}
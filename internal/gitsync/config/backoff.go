@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Backoff specifies how the delay between retry attempts grows.
+type Backoff int
+
+const (
+	// BackoffUndefined means that the backoff is not specified.
+	// Exponential backoff is used by default.
+	BackoffUndefined Backoff = iota
+
+	// BackoffConstant means that the delay is the same between every attempt.
+	BackoffConstant
+
+	// BackoffLinear means that the delay grows linearly between attempts.
+	BackoffLinear
+
+	// BackoffExponential means that the delay doubles between attempts.
+	BackoffExponential
+)
+
+func (b Backoff) MarshalJSON() ([]byte, error) {
+	var s string
+	switch b {
+	case BackoffUndefined:
+		return json.Marshal(nil)
+	case BackoffConstant:
+		s = "constant"
+	case BackoffLinear:
+		s = "linear"
+	case BackoffExponential:
+		s = "exponential"
+	default:
+		return nil, fmt.Errorf("unknown backoff '%s'", b)
+	}
+	return json.Marshal(s)
+}
+
+func (b *Backoff) UnmarshalJSON(bs []byte) error {
+	var v string
+	if err := json.Unmarshal(bs, &v); err != nil {
+		return err
+	}
+	switch strings.ToLower(v) {
+	case "", "undefined":
+		*b = BackoffUndefined
+	case "constant":
+		*b = BackoffConstant
+	case "linear":
+		*b = BackoffLinear
+	case "exponential":
+		*b = BackoffExponential
+	default:
+		return fmt.Errorf("unexpected value '%s' for backoff", v)
+	}
+	return nil
+}
+
+func (b Backoff) String() string {
+	switch b {
+	case BackoffUndefined:
+		return ""
+	case BackoffConstant:
+		return "constant"
+	case BackoffLinear:
+		return "linear"
+	case BackoffExponential:
+		return "exponential"
+	default:
+		return fmt.Sprintf("unknown(%d)", b)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"path/filepath"
+	"slices"
 
 	"go.lepovirta.org/otk/internal/envvar"
 )
@@ -13,17 +15,32 @@ const StdinPath = "-"
 type CliFlags struct {
 	Run             bool
 	Once            bool
-	ConfigPath      string
+	ConfigPaths     []string
+	ConfigFormat    Format
 	CredentialsPath string
+	Concurrency     int
+	ForceFullSync   bool
+	IgnoreWindows   bool
+	WatchConfig     bool
 }
 
 func (f *CliFlags) validate() error {
-	if f.ConfigPath == "" {
+	if len(f.ConfigPaths) == 0 {
 		return fmt.Errorf("config path not specified")
 	}
-	if f.ConfigPath == StdinPath && f.CredentialsPath == StdinPath {
+	configFromStdin := slices.Contains(f.ConfigPaths, StdinPath)
+	if configFromStdin && len(f.ConfigPaths) > 1 {
+		return fmt.Errorf("loading config from STDIN and from files at the same time is not supported")
+	}
+	if configFromStdin && f.CredentialsPath == StdinPath {
 		return fmt.Errorf("loading config and credentials from STDIN at the same time is not supported")
 	}
+	if f.WatchConfig && (configFromStdin || f.CredentialsPath == StdinPath) {
+		return fmt.Errorf("watching config changes is not supported when reading config or credentials from STDIN")
+	}
+	if f.Concurrency < 0 {
+		return fmt.Errorf("concurrency cannot be negative")
+	}
 	return nil
 }
 
@@ -38,7 +55,7 @@ func (f *CliFlags) Parse(
 	flagSet.Usage = func() {
 		_, _ = fmt.Fprintf(
 			flagSet.Output(),
-			"Usage: %s [-config <path>] [-credentials <path>] [-once] [-run] [-h | --help]\n\nOptions:\n",
+			"Usage: %s [-config <path>]... [-credentials <path>] [-config-format <format>] [-once] [-run] [-concurrency <n>] [-force-full-sync] [-ignore-windows] [-watch-config] [-h | --help]\n\nOptions:\n",
 			args[0],
 		)
 		flagSet.PrintDefaults()
@@ -56,11 +73,16 @@ func (f *CliFlags) Parse(
 		false,
 		"Run Git sync only once instead of the repeatedly as specified in the configuration.",
 	)
-	flagSet.StringVar(
-		&f.ConfigPath,
+	flagSet.Func(
 		"config",
-		"-",
-		"Path to a configuration file. Use '-' to read from STDIN. By default, config is read from STDIN.",
+		"Path to a configuration file or a directory of configuration files. Can be repeated to merge multiple files. Use '-' to read from STDIN. By default, config is read from STDIN.",
+		func(path string) error {
+			if path == "" {
+				return fmt.Errorf("config path cannot be empty")
+			}
+			f.ConfigPaths = append(f.ConfigPaths, path)
+			return nil
+		},
 	)
 	flagSet.StringVar(
 		&f.CredentialsPath,
@@ -69,17 +91,60 @@ func (f *CliFlags) Parse(
 		"Path to a credentials file. Use '-' to read from STDIN.",
 	)
 
+	flagSet.Var(
+		&f.ConfigFormat,
+		"config-format",
+		"Format of the configuration and credentials files: json, yaml, or toml. By default, the format is detected from the file extension, and JSON is used for STDIN.",
+	)
+
+	flagSet.IntVar(
+		&f.Concurrency,
+		"concurrency",
+		0,
+		"Number of mappings and targets to sync at the same time. Overrides the value in the configuration.",
+	)
+
+	flagSet.BoolVar(
+		&f.ForceFullSync,
+		"force-full-sync",
+		false,
+		"Push all of the matching refs even when they haven't changed since the last sync.",
+	)
+
+	flagSet.BoolVar(
+		&f.IgnoreWindows,
+		"ignore-windows",
+		false,
+		"Sync the mappings even when they are outside of their sync windows or during blackouts.",
+	)
+
+	flagSet.BoolVar(
+		&f.WatchConfig,
+		"watch-config",
+		false,
+		"Reload the configuration when the config or credentials file changes. The configuration is always reloaded on SIGHUP.",
+	)
+
 	if err := flagSet.Parse(args[1:]); err != nil {
 		return err
 	}
 
 	// Fall back to env vars
-	if f.ConfigPath == "" {
-		f.ConfigPath = envVars.GetForApp(AppName, "CONFIG_PATH")
+	if len(f.ConfigPaths) == 0 {
+		if paths := envVars.GetForApp(AppName, "CONFIG_PATH"); paths != "" {
+			f.ConfigPaths = filepath.SplitList(paths)
+		} else {
+			f.ConfigPaths = []string{StdinPath}
+		}
 	}
 	if f.CredentialsPath == "" {
 		f.CredentialsPath = envVars.GetForApp(AppName, "CREDENTIALS")
 	}
+	if f.ConfigFormat == FormatUndefined {
+		if err := f.ConfigFormat.Set(envVars.GetForApp(AppName, "CONFIG_FORMAT")); err != nil {
+			return err
+		}
+	}
 
 	return f.validate()
 }
@@ -1,14 +1,19 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"net/url"
 	"strings"
+	"time"
 
+	"github.com/go-git/go-billy/v5"
+	"go.lepovirta.org/otk/internal/cron"
 	"go.lepovirta.org/otk/internal/duration"
-	"go.lepovirta.org/otk/internal/envsubst"
 	"go.lepovirta.org/otk/internal/envvar"
 	"go.lepovirta.org/otk/internal/matcher"
 	"go.lepovirta.org/otk/internal/validation"
@@ -17,6 +22,8 @@ import (
 const (
 	AppName             = "gitsync"
 	envVarSubstErrorMsg = "environment variable substitution failed"
+	defaultInterval     = time.Hour
+	defaultGitHubApiURL = "https://api.github.com"
 )
 
 // ConfigSingle is used for syncing a single local Git repository
@@ -53,10 +60,85 @@ type Config struct {
 
 	// Mappings specifies which Git repositories are synchronised where.
 	Mappings []SyncMapping `json:"mappings"`
+
+	// Profiles specifies the settings shared by many repositories.
+	// The key is the ID of the profile, which is referenced in the
+	// repository profile field.
+	Profiles map[string]Profile `json:"profiles"`
+
+	// Concurrency specifies how many mappings are synchronised and
+	// how many targets are pushed to at the same time.
+	// By default, everything is synchronised one at a time.
+	Concurrency int `json:"concurrency"`
+
+	// StateDir specifies the directory where the sync state is stored.
+	// The state records the refs pushed to the targets, so that unchanged refs
+	// don't need to be pushed again. When left unset, the state is stored next
+	// to the local path of the source repository.
+	StateDir string `json:"stateDir"`
+
+	// Server specifies the HTTP server that is run alongside the
+	// synchronisation loop.
+	Server Server `json:"server"`
+
+	// Tracing specifies where the traces of the synchronisation are exported.
+	// Tracing is disabled by default.
+	Tracing Tracing `json:"tracing"`
+
+	// origins records the files of the config when it's merged from many files.
+	origins *origins
+}
+
+// Tracing specifies how the traces are exported using
+// the OpenTelemetry protocol (OTLP) over HTTP.
+type Tracing struct {
+	// Endpoint is the base URL of the OTLP/HTTP receiver e.g. `http://localhost:4318`.
+	// The spans are sent to path `/v1/traces` under the endpoint.
+	// Tracing is disabled when the endpoint is not set.
+	Endpoint string `json:"endpoint"`
+
+	// Headers contains additional HTTP headers sent to the receiver
+	// e.g. for authentication.
+	Headers map[string]string `json:"headers"`
+
+	// ServiceName is the name of the service reported in the traces.
+	// Default is `gitsync`.
+	ServiceName string `json:"serviceName"`
+}
+
+// Server specifies the HTTP server that is run alongside the
+// synchronisation loop.
+type Server struct {
+	// Address is the address the server listens on e.g. `:8080`.
+	// The server is disabled when the address is not set.
+	Address string `json:"address"`
+
+	// WebhookPath is the URL path where push webhooks are received.
+	// Default is `/webhook`.
+	WebhookPath string `json:"webhookPath"`
+
+	// When Metrics is set to `true`, the sync metrics are served
+	// in the Prometheus text format from path `/metrics`.
+	Metrics bool `json:"metrics"`
+
+	// LivenessDeadline specifies how long a single synchronisation can take
+	// before the liveness check served from path `/healthz` fails.
+	// Default is 1 hour.
+	LivenessDeadline duration.D `json:"livenessDeadline"`
+
+	// StatusHistory is the number of the latest runs of each mapping
+	// served from path `/status`. Default is 10.
+	StatusHistory int `json:"statusHistory"`
 }
 
 // Repository specifies details of a single Git repository
 type Repository struct {
+	// Profile contains the IDs of the profiles the repository inherits
+	// its credentials, storage, and retry settings from. The later profiles
+	// override the earlier ones, and the settings of the repository override
+	// the profiles.
+	Profile ProfileNames `json:"profile"`
+
 	// Credentials specifies the authentication credentials used
 	// when connecting to the Git repository.
 	Credentials
@@ -66,12 +148,39 @@ type Repository struct {
 
 	// When InMemory is set to `true`, the Git repository is downloaded
 	// to memory rather than the file system.
-	InMemory bool `json:"inMemory"`
+	InMemory *bool `json:"inMemory"`
 
 	// LocalPath specifies the path where the Git repository is downloaded to.
 	// When InMemory is set to `true`, this value is ignored.
 	// When left unset, a temporary directory is created for the Git repository.
 	LocalPath string `json:"localPath"`
+
+	// Retry specifies how failed fetches and pushes are retried.
+	Retry Retry `json:"retry"`
+}
+
+// Retry specifies how failed network operations against
+// the Git repository are retried.
+type Retry struct {
+	// MaxAttempts is the maximum number of attempts made for a single operation.
+	// Default is 1, which means that failed operations are not retried.
+	MaxAttempts int `json:"maxAttempts"`
+
+	// Backoff specifies how the delay between attempts grows.
+	// Possible values: constant, linear, exponential. Default is exponential.
+	Backoff Backoff `json:"backoff"`
+
+	// MinDelay is the delay before the first retry.
+	// Default is 1 second.
+	MinDelay duration.D `json:"minDelay"`
+
+	// MaxDelay is the maximum delay between attempts.
+	// Default is 1 minute.
+	MaxDelay duration.D `json:"maxDelay"`
+
+	// Jitter is the maximum random delay added to each delay between attempts.
+	// The added jitter is at most half of the delay. Default is no jitter.
+	Jitter duration.D `json:"jitter"`
 }
 
 // Credentials specifies the authentication credentials used
@@ -85,6 +194,10 @@ type Credentials struct {
 	// Git repositories.
 	HttpToken string `json:"httpToken"`
 
+	// HttpTokenFile is the path to a file that contains the HTTP token.
+	// Can't be used together with HttpToken.
+	HttpTokenFile string `json:"httpTokenFile"`
+
 	// HttpCredentials specifies HTTP basic auth credentials used for
 	// connecting to HTTPS-based Git repositories
 	HttpCredentials HttpCredentials `json:"httpCredentials"`
@@ -92,6 +205,23 @@ type Credentials struct {
 	// SshCredentials specifies credentials used when connecting to
 	// SSH-based Git repositories.
 	SshCredentials SshCredentials `json:"sshCredentials"`
+
+	// CredentialHelper specifies a Git credential helper used for acquiring
+	// HTTP basic auth credentials for HTTPS-based Git repositories.
+	CredentialHelper CredentialHelper `json:"credentialHelper"`
+
+	// Exec specifies a command that is run for acquiring short-lived
+	// credentials for the Git repository.
+	Exec ExecCredentials `json:"exec"`
+
+	// GitHubApp specifies a GitHub App used for acquiring installation
+	// access tokens for HTTPS-based Git repositories hosted in GitHub.
+	GitHubApp GitHubApp `json:"githubApp"`
+
+	// WebhookSecret specifies the secret used for verifying the push webhooks
+	// received for the Git repository. Webhooks are not accepted for
+	// repositories without a secret.
+	WebhookSecret string `json:"webhookSecret"`
 }
 
 // HttpCredentials specifies HTTP basic auth credentials used for
@@ -102,6 +232,61 @@ type HttpCredentials struct {
 
 	// Password is the HTTP basic auth password field
 	Password string `json:"password"`
+
+	// PasswordFile is the path to a file that contains the HTTP basic auth password.
+	// Can't be used together with Password.
+	PasswordFile string `json:"passwordFile"`
+}
+
+// CredentialHelper specifies a Git credential helper command.
+// The helper is run with the action (`get`, `store`, or `erase`) as the last
+// argument, and it communicates using the protocol described in
+// the gitcredentials(7) manual page.
+type CredentialHelper struct {
+	// Command is the credential helper command to run e.g. `git`
+	// or `/usr/local/bin/git-credential-vault`.
+	Command string `json:"command"`
+
+	// Args contains the arguments passed to the command before the action
+	// e.g. `["credential-cache"]` for running `git credential-cache get`.
+	Args []string `json:"args"`
+}
+
+// ExecCredentials specifies a command that prints the credentials
+// as a JSON object to the standard output.
+// The object may contain the fields `token`, `username`, `password`,
+// `sshPrivateKey`, and `expiresAt`. The credentials are cached until
+// shortly before the time in `expiresAt`.
+type ExecCredentials struct {
+	// Command is the command to run e.g. `/usr/local/bin/token-broker`.
+	Command string `json:"command"`
+
+	// Args contains the arguments passed to the command.
+	Args []string `json:"args"`
+
+	// Env contains the environment variables set for the command
+	// in addition to the environment of gitsync.
+	Env map[string]string `json:"env"`
+}
+
+// GitHubApp specifies a GitHub App and its installation.
+// The installation access tokens are used as the password for HTTP basic auth
+// with the username `x-access-token`.
+type GitHubApp struct {
+	// AppId is the ID of the GitHub App.
+	AppId int64 `json:"appId"`
+
+	// InstallationId is the ID of the GitHub App installation
+	// that has access to the Git repository.
+	InstallationId int64 `json:"installationId"`
+
+	// PrivateKeyPath is the path to the private key of the GitHub App in PEM format.
+	PrivateKeyPath string `json:"privateKeyPath"`
+
+	// ApiURL is the base URL of the GitHub API.
+	// Default value is "https://api.github.com".
+	// For GitHub Enterprise Server, use e.g. "https://github.example.com/api/v3".
+	ApiURL string `json:"apiUrl"`
 }
 
 // SshCredentials specifies credentials used when connecting to
@@ -109,7 +294,7 @@ type HttpCredentials struct {
 type SshCredentials struct {
 	// When UseAgent is set to `true`, SSH agent is used for acquiring
 	// the SSH key for connecting to the remote repository.
-	UseAgent bool `json:"useAgent"`
+	UseAgent *bool `json:"useAgent"`
 
 	// Username is the SSH username to use for connecting to the Git repository.
 	// Default value is "git".
@@ -121,6 +306,10 @@ type SshCredentials struct {
 	// KeyPassword specifies the password for unlocking the SSH key specified in KeyPath.
 	KeyPassword string `json:"keyPassword"`
 
+	// KeyPasswordFile is the path to a file that contains the password
+	// for unlocking the SSH key. Can't be used together with KeyPassword.
+	KeyPasswordFile string `json:"keyPasswordFile"`
+
 	// HostKey is the SSH host key expected from the remote server.
 	// When left unset, host key is checked from the known hosts file.
 	// HostKey is supplied in authorized_keys format according to sshd(8) manual page.
@@ -134,11 +323,18 @@ type SshCredentials struct {
 
 	// When IgnoreHostKey is set to `true`, the SSH host key for the Git repository
 	// is not verified. Not recommended to be used in production!
-	IgnoreHostKey bool `json:"ignoreHostKey"`
+	IgnoreHostKey *bool `json:"ignoreHostKey"`
 }
 
 // Mappings specifies which Git repositories are synchronised where.
 type SyncMapping struct {
+	// Id identifies the mapping in the sync state and metrics.
+	// When left unset, the ID is formed from the source and target IDs
+	// e.g. `otk-github->otk-gitlab,otk-codeberg`. If another mapping
+	// already uses the same ID, the mapping index is appended to it
+	// e.g. `otk-github->otk-gitlab#2`.
+	Id string `json:"id"`
+
 	// SyncSpec specifies what to sync to the target repository and when.
 	SyncSpec
 
@@ -155,6 +351,35 @@ type SyncSpec struct {
 	// Default is 1 hour.
 	Interval duration.D `json:"interval"`
 
+	// Schedule specifies when to synchronise the Git repository using
+	// a cron expression e.g. `0 2 * * *` for every night at 02:00.
+	// Can't be used together with the interval.
+	Schedule cron.Schedule `json:"schedule"`
+
+	// TimeZone specifies the time zone of the schedule e.g. `Europe/Helsinki`.
+	// Default is the local time zone.
+	TimeZone string `json:"timeZone"`
+
+	// Jitter is the maximum random delay added to each synchronisation,
+	// so that the synchronisations of many mappings don't all start at
+	// the same time. Default is no jitter.
+	Jitter duration.D `json:"jitter"`
+
+	// Windows contains the periods of time when the synchronisation is allowed.
+	// Synchronisations due outside the windows are deferred until the next window.
+	// By default, the synchronisation is allowed at any time.
+	Windows []TimeWindow `json:"windows"`
+
+	// Blackouts contains the periods of time when the synchronisation is not
+	// allowed even when they are within the windows.
+	Blackouts []TimeWindow `json:"blackouts"`
+
+	// PollInterval specifies how frequently to check the source for changes
+	// between the synchronisations. Only the refs are listed from the source
+	// during the check, and a synchronisation is started when they have changed.
+	// Polling is disabled by default.
+	PollInterval duration.D `json:"pollInterval"`
+
 	// Branches contains the matcher rules to determine which branches to
 	// synchronise to the target Git repository.
 	Branches []matcher.M `json:"branches"`
@@ -162,6 +387,83 @@ type SyncSpec struct {
 	// Tags contains the matcher rules to determine which tags to
 	// synchronise to the target Git repository.
 	Tags []matcher.M `json:"tags"`
+
+	// Refs contains the matcher rules to determine which other refs to
+	// synchronise to the target Git repository. The rules are matched
+	// against full ref names such as `refs/notes/commits`.
+	Refs []matcher.M `json:"refs"`
+
+	// When Prune is set to `true`, branches and tags that match the
+	// branch and tag matchers but no longer exist in the source repository
+	// are deleted from the target Git repository.
+	Prune bool `json:"prune"`
+
+	// UpdatePolicy specifies how refs that already exist in the target
+	// Git repository are updated. Default is to force update the refs.
+	UpdatePolicy UpdatePolicy `json:"updatePolicy"`
+
+	// RefMappings contains the rules for renaming branches and tags
+	// in the target Git repository. The first matching rule is applied.
+	// When no rule matches, the ref has the same name in the target.
+	RefMappings []RefMapping `json:"refMappings"`
+}
+
+/////////////////////////////////////////////////
+// Sync interval
+/////////////////////////////////////////////////
+
+// SyncInterval returns the interval between synchronisations
+// or the default interval when it's not specified.
+func (ss *SyncSpec) SyncInterval() time.Duration {
+	if ss.Interval.Duration <= 0 {
+		return defaultInterval
+	}
+	return ss.Interval.Duration
+}
+
+// NextSync returns the time of the synchronisation following the one
+// started at the given time. The jitter is not included.
+func (ss *SyncSpec) NextSync(last time.Time) time.Time {
+	if ss.Schedule.IsEmpty() {
+		return last.Add(ss.SyncInterval())
+	}
+	next := ss.Schedule.Next(last.In(ss.Location()))
+	if next.IsZero() {
+		// Schedules that never match are rejected during validation
+		return last.Add(ss.SyncInterval())
+	}
+	return next
+}
+
+// Location returns the time zone of the schedule.
+func (ss *SyncSpec) Location() *time.Location {
+	if ss.TimeZone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(ss.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// ScheduleString describes when the synchronisations are run.
+func (ss *SyncSpec) ScheduleString() string {
+	var sb strings.Builder
+	if ss.Schedule.IsEmpty() {
+		sb.WriteString("every ")
+		sb.WriteString(ss.SyncInterval().String())
+	} else {
+		sb.WriteString(ss.Schedule.String())
+		sb.WriteString(" (")
+		sb.WriteString(ss.Location().String())
+		sb.WriteString(")")
+	}
+	if ss.Jitter.Duration > 0 {
+		sb.WriteString(" with jitter up to ")
+		sb.WriteString(ss.Jitter.String())
+	}
+	return sb.String()
 }
 
 /////////////////////////////////////////////////
@@ -178,7 +480,16 @@ func (c *Credentials) AuthMethod() AuthMethod {
 	if c.HttpCredentials.enabled() {
 		return AuthMethodHttpCredentials
 	}
-	if c.SshCredentials.UseAgent {
+	if c.CredentialHelper.enabled() {
+		return AuthMethodCredentialHelper
+	}
+	if c.Exec.enabled() {
+		return AuthMethodExec
+	}
+	if c.GitHubApp.enabled() {
+		return AuthMethodGitHubApp
+	}
+	if c.SshCredentials.UsesAgent() {
 		return AuthMethodSshAgent
 	}
 	if c.SshCredentials.keyEnabled() {
@@ -191,23 +502,63 @@ func (s *SshCredentials) keyEnabled() bool {
 	return s.KeyPath != ""
 }
 
+// UsesAgent reports whether the SSH agent is enabled.
+func (s *SshCredentials) UsesAgent() bool {
+	return isTrue(s.UseAgent)
+}
+
+// IgnoresHostKey reports whether the SSH host key verification is disabled.
+func (s *SshCredentials) IgnoresHostKey() bool {
+	return isTrue(s.IgnoreHostKey)
+}
+
+// IsInMemory reports whether the Git repository is downloaded to memory.
+func (r *Repository) IsInMemory() bool {
+	return isTrue(r.InMemory)
+}
+
 func (h *HttpCredentials) enabled() bool {
 	return h.Username != "" && h.Password != ""
 }
 
+func (h *CredentialHelper) enabled() bool {
+	return h.Command != ""
+}
+
+func (e *ExecCredentials) enabled() bool {
+	return e.Command != ""
+}
+
+func (g *GitHubApp) enabled() bool {
+	return g.AppId != 0
+}
+
+// APIBaseURL returns the base URL of the GitHub API
+// or the default URL when it's not specified.
+func (g *GitHubApp) APIBaseURL() string {
+	if g.ApiURL == "" {
+		return defaultGitHubApiURL
+	}
+	return strings.TrimSuffix(g.ApiURL, "/")
+}
+
 /////////////////////////////////////////////////
 // Credentials merge
 /////////////////////////////////////////////////
 
-func (cs *ConfigSingle) mergeCredentials(credentials map[string]Credentials) {
+func (cs *ConfigSingle) mergeCredentials(
+	credentials map[string]Credentials,
+	profiles map[string]Profile,
+) {
 	for targetId, creds := range credentials {
 		target, ok := cs.Targets[targetId]
 		if ok {
 			target.merge(&creds)
 			cs.Targets[targetId] = target
-		} else {
+		}
+		if !mergeProfileCredentials(profiles, targetId, &creds) && !ok {
 			slog.Warn(
-				"credentials specified for target but target not found in configuration",
+				"credentials specified for target but target or profile not found in configuration",
 				slog.String("target", targetId),
 			)
 		}
@@ -220,91 +571,195 @@ func (cfg *Config) mergeCredentials(credentials map[string]Credentials) {
 		if ok {
 			repo.merge(&creds)
 			cfg.Repositories[repoId] = repo
-		} else {
+		}
+		if !mergeProfileCredentials(cfg.Profiles, repoId, &creds) && !ok {
 			slog.Warn(
-				"credentials specified for repository but repository not found in configuration",
+				"credentials specified for repository but repository or profile not found in configuration",
 				slog.String("repo", repoId),
 			)
 		}
 	}
 }
 
+// mergeProfileCredentials merges the credentials to the profile with the given ID.
+// Returns false when the profile is not found.
+func mergeProfileCredentials(
+	profiles map[string]Profile,
+	profileId string,
+	credentials *Credentials,
+) bool {
+	profile, ok := profiles[profileId]
+	if !ok {
+		return false
+	}
+	profile.Credentials.merge(credentials)
+	profiles[profileId] = profile
+	return true
+}
+
 func (c *Credentials) merge(other *Credentials) {
-	overrideStr(&c.HttpToken, other.HttpToken)
+	if other.TargetAuthMethod != AuthMethodUndefined {
+		c.TargetAuthMethod = other.TargetAuthMethod
+	}
+	overrideSecret(&c.HttpToken, &c.HttpTokenFile, other.HttpToken, other.HttpTokenFile)
 	overrideStr(&c.HttpCredentials.Username, other.HttpCredentials.Username)
-	overrideStr(&c.HttpCredentials.Password, other.HttpCredentials.Password)
+	overrideSecret(
+		&c.HttpCredentials.Password, &c.HttpCredentials.PasswordFile,
+		other.HttpCredentials.Password, other.HttpCredentials.PasswordFile,
+	)
 	overrideBool(&c.SshCredentials.UseAgent, other.SshCredentials.UseAgent)
 	overrideStr(&c.SshCredentials.Username, other.SshCredentials.Username)
 	overrideStr(&c.SshCredentials.KeyPath, other.SshCredentials.KeyPath)
-	overrideStr(&c.SshCredentials.KeyPassword, other.SshCredentials.KeyPassword)
+	overrideSecret(
+		&c.SshCredentials.KeyPassword, &c.SshCredentials.KeyPasswordFile,
+		other.SshCredentials.KeyPassword, other.SshCredentials.KeyPasswordFile,
+	)
+	overrideStr(&c.SshCredentials.HostKey, other.SshCredentials.HostKey)
+	if len(other.SshCredentials.KnownHostsPaths) > 0 {
+		c.SshCredentials.KnownHostsPaths = other.SshCredentials.KnownHostsPaths
+	}
 	overrideBool(&c.SshCredentials.IgnoreHostKey, other.SshCredentials.IgnoreHostKey)
+	if other.CredentialHelper.enabled() {
+		c.CredentialHelper = other.CredentialHelper
+	}
+	if other.Exec.enabled() {
+		c.Exec = other.Exec
+		c.Exec.Env = maps.Clone(other.Exec.Env)
+	}
+	if other.GitHubApp.enabled() {
+		c.GitHubApp = other.GitHubApp
+	}
+	overrideStr(&c.WebhookSecret, other.WebhookSecret)
 }
 
 /////////////////////////////////////////////////
 // Environment variable substitution
 /////////////////////////////////////////////////
 
-func (cs *ConfigSingle) resolveEnvVars(envVars map[string]string) {
+func (cs *ConfigSingle) resolveEnvVars(res *resolver) {
 	var err error
-	cs.Path, err = envsubst.Replace(cs.Path, envVars)
+	cs.Path, err = res.replace(cs.Path)
 	if err != nil {
 		logEnvVarSubstWarning(err, "", "localPath")
 	}
 
 	for k, target := range cs.Targets {
-		target.resolveEnvVars(k, envVars)
+		target.resolveEnvVars(k, res)
 		cs.Targets[k] = target
 	}
 }
 
-func (cfg *Config) resolveEnvVars(envVars map[string]string) {
+func (cfg *Config) resolveEnvVars(res *resolver) {
+	var err error
+	cfg.resolveOptionsEnvVars(res)
+	cfg.Server.Address, err = res.replace(cfg.Server.Address)
+	if err != nil {
+		logEnvVarSubstWarning(err, "server", "address")
+	}
 	for k, repo := range cfg.Repositories {
-		repo.resolveEnvVars(k, envVars)
+		repo.resolveEnvVars(k, res)
 		cfg.Repositories[k] = repo
 	}
 }
 
-func (r *Repository) resolveEnvVars(parent string, envVars map[string]string) {
+// resolveOptionsEnvVars resolves the environment variables in the fields
+// that are shared with the single repository config.
+func (cfg *Config) resolveOptionsEnvVars(res *resolver) {
 	var err error
-	r.URL, err = envsubst.Replace(r.URL, envVars)
+	cfg.StateDir, err = res.replace(cfg.StateDir)
+	if err != nil {
+		logEnvVarSubstWarning(err, "", "stateDir")
+	}
+	cfg.Tracing.Endpoint, err = res.replace(cfg.Tracing.Endpoint)
+	if err != nil {
+		logEnvVarSubstWarning(err, "tracing", "endpoint")
+	}
+	for k, v := range cfg.Tracing.Headers {
+		cfg.Tracing.Headers[k], err = res.replace(v)
+		if err != nil {
+			logEnvVarSubstWarning(err, "tracing", "headers", k)
+		}
+	}
+}
+
+func (r *Repository) resolveEnvVars(parent string, res *resolver) {
+	var err error
+	r.URL, err = res.replace(r.URL)
 	if err != nil {
 		logEnvVarSubstWarning(err, parent, "url")
 	}
-	r.LocalPath, err = envsubst.Replace(r.LocalPath, envVars)
+	r.LocalPath, err = res.replace(r.LocalPath)
 	if err != nil {
 		logEnvVarSubstWarning(err, parent, "localPath")
 	}
-	r.HttpToken, err = envsubst.Replace(r.HttpToken, envVars)
+	r.HttpToken, err = res.replace(r.HttpToken)
 	if err != nil {
 		logEnvVarSubstWarning(err, parent, "httpToken")
 	}
-	r.HttpCredentials.resolveEnvVars(parent, envVars)
-	r.SshCredentials.resolveEnvVars(parent, envVars)
+	r.WebhookSecret, err = res.replace(r.WebhookSecret)
+	if err != nil {
+		logEnvVarSubstWarning(err, parent, "webhookSecret")
+	}
+	r.HttpCredentials.resolveEnvVars(parent, res)
+	r.SshCredentials.resolveEnvVars(parent, res)
+	r.CredentialHelper.Command, err = res.replace(r.CredentialHelper.Command)
+	if err != nil {
+		logEnvVarSubstWarning(err, parent, "credentialHelper", "command")
+	}
+	r.Exec.resolveEnvVars(parent, res)
+	r.GitHubApp.resolveEnvVars(parent, res)
+	r.resolveSecretFilePaths(parent, res)
 }
 
-func (h *HttpCredentials) resolveEnvVars(parent string, envVars map[string]string) {
+func (e *ExecCredentials) resolveEnvVars(parent string, res *resolver) {
 	var err error
-	h.Username, err = envsubst.Replace(h.Username, envVars)
+	e.Command, err = res.replace(e.Command)
+	if err != nil {
+		logEnvVarSubstWarning(err, parent, "exec", "command")
+	}
+	for key, value := range e.Env {
+		e.Env[key], err = res.replace(value)
+		if err != nil {
+			logEnvVarSubstWarning(err, parent, "exec", "env", key)
+		}
+	}
+}
+
+func (g *GitHubApp) resolveEnvVars(parent string, res *resolver) {
+	var err error
+	g.PrivateKeyPath, err = res.replace(g.PrivateKeyPath)
+	if err != nil {
+		logEnvVarSubstWarning(err, parent, "githubApp", "privateKeyPath")
+	}
+	g.ApiURL, err = res.replace(g.ApiURL)
+	if err != nil {
+		logEnvVarSubstWarning(err, parent, "githubApp", "apiUrl")
+	}
+}
+
+func (h *HttpCredentials) resolveEnvVars(parent string, res *resolver) {
+	var err error
+	h.Username, err = res.replace(h.Username)
 	if err != nil {
 		logEnvVarSubstWarning(err, parent, "username")
 	}
-	h.Password, err = envsubst.Replace(h.Password, envVars)
+	h.Password, err = res.replace(h.Password)
 	if err != nil {
 		logEnvVarSubstWarning(err, parent, "password")
 	}
 }
 
-func (s *SshCredentials) resolveEnvVars(parent string, envVars map[string]string) {
+func (s *SshCredentials) resolveEnvVars(parent string, res *resolver) {
 	var err error
-	s.Username, err = envsubst.Replace(s.Username, envVars)
+	s.Username, err = res.replace(s.Username)
 	if err != nil {
 		logEnvVarSubstWarning(err, parent, "username")
 	}
-	s.KeyPassword, err = envsubst.Replace(s.KeyPassword, envVars)
+	s.KeyPassword, err = res.replace(s.KeyPassword)
 	if err != nil {
 		logEnvVarSubstWarning(err, parent, "keyPassword")
 	}
-	s.KeyPath, err = envsubst.Replace(s.KeyPath, envVars)
+	s.KeyPath, err = res.replace(s.KeyPath)
 	if err != nil {
 		logEnvVarSubstWarning(err, parent, "keyPath")
 	}
@@ -319,17 +774,23 @@ func logEnvVarSubstWarning(err error, field ...string) {
 // Validation
 /////////////////////////////////////////////////
 
-func (cs *ConfigSingle) validate(v *validation.V) {
+func (cs *ConfigSingle) validate(v *validation.V, profiles map[string]Profile) {
 	v.FailWhen(
 		len(cs.Targets) == 0,
 		"targets",
 		"at least one target must be specified",
 	)
 
+	profilesV := v.Sub("profiles")
+	for profileId, profile := range profiles {
+		profile.validate(profilesV.Sub(profileId))
+	}
+
 	reposV := v.Sub("targets")
 	for targetId, target := range cs.Targets {
 		targetV := reposV.Sub(targetId)
 		target.validate(targetV)
+		target.validateProfiles(targetV, profiles)
 	}
 }
 
@@ -345,10 +806,51 @@ func (ss *SyncSpec) validate(v *validation.V) {
 		"must not be negative",
 	)
 	v.FailWhen(
-		len(ss.Branches) == 0 && len(ss.Tags) == 0,
-		"branches/tags",
-		"at least one branch or tag spec must be specified",
+		ss.PollInterval.Nanoseconds() < 0,
+		"pollInterval",
+		"must not be negative",
+	)
+	v.FailWhen(
+		ss.PollInterval.Nanoseconds() > 0 && ss.Schedule.IsEmpty() && ss.PollInterval.Duration >= ss.SyncInterval(),
+		"pollInterval",
+		"must be shorter than interval",
+	)
+	v.FailWhen(
+		ss.Interval.Nanoseconds() > 0 && !ss.Schedule.IsEmpty(),
+		"interval/schedule",
+		"interval and schedule cannot be used together",
+	)
+	v.FailWhen(
+		!ss.Schedule.IsEmpty() && ss.Schedule.Next(time.Now()).IsZero(),
+		"schedule",
+		"schedule never matches",
 	)
+	if ss.TimeZone != "" {
+		_, err := time.LoadLocation(ss.TimeZone)
+		v.FailFWhen(
+			err != nil,
+			"timeZone",
+			"unknown time zone %s", ss.TimeZone,
+		)
+	}
+	v.FailWhen(
+		ss.Jitter.Nanoseconds() < 0,
+		"jitter",
+		"must not be negative",
+	)
+	validateTimeWindows(v.Sub("windows"), ss.Windows, ss.Location())
+	validateTimeWindows(v.Sub("blackouts"), ss.Blackouts, ss.Location())
+	v.FailWhen(
+		len(ss.Branches) == 0 && len(ss.Tags) == 0 && len(ss.Refs) == 0,
+		"branches/tags/refs",
+		"at least one branch, tag, or ref spec must be specified",
+	)
+
+	switch ss.UpdatePolicy {
+	case UpdatePolicyUndefined, UpdatePolicyForce, UpdatePolicyFastForwardOnly, UpdatePolicySkipDiverged:
+	default:
+		v.FailF("updatePolicy", "unexpected update policy %s", ss.UpdatePolicy)
+	}
 
 	branchV := v.Sub("branches")
 	for i, branch := range ss.Branches {
@@ -359,9 +861,76 @@ func (ss *SyncSpec) validate(v *validation.V) {
 	for i, tag := range ss.Tags {
 		tagV.IndexFailFWhen(tag.IsEmpty(), i, "matcher must not be empty")
 	}
+
+	refV := v.Sub("refs")
+	for i, ref := range ss.Refs {
+		refV.IndexFailFWhen(ref.IsEmpty(), i, "matcher must not be empty")
+		refV.IndexFailFWhen(
+			!ref.IsEmpty() && !ref.UsesRegex() && !strings.HasPrefix(ref.String(), "refs/"),
+			i,
+			"ref %s must start with refs/", ref.String(),
+		)
+	}
+
+	refMappingsV := v.Sub("refMappings")
+	for i, refMapping := range ss.RefMappings {
+		refMapping.validate(refMappingsV.IndexedSub(i))
+	}
+	ss.validateRefMappingCollisions(refMappingsV, RefMappingBranches, ss.Branches)
+	ss.validateRefMappingCollisions(refMappingsV, RefMappingTags, ss.Tags)
+}
+
+// validateRefMappingCollisions checks that the refs that are known by name
+// are not mapped to the same name in the target. Collisions for refs matched
+// using regex are detected during the sync.
+func (ss *SyncSpec) validateRefMappingCollisions(
+	v *validation.V,
+	refs string,
+	matchers []matcher.M,
+) {
+	if len(ss.RefMappings) == 0 {
+		return
+	}
+	mappedNames := make(map[string]string, len(matchers))
+	for _, m := range matchers {
+		if m.UsesRegex() || m.IsEmpty() {
+			continue
+		}
+		name := m.String()
+		mappedName := ss.mapRef(refs, name)
+		if other, ok := mappedNames[mappedName]; ok && other != name {
+			v.FailF(
+				refs,
+				"both %s and %s are mapped to %s",
+				other, name, mappedName,
+			)
+			continue
+		}
+		mappedNames[mappedName] = name
+	}
+}
+
+func (rm *RefMapping) validate(v *validation.V) {
+	v.FailFWhen(
+		rm.Refs != RefMappingAny && rm.Refs != RefMappingBranches && rm.Refs != RefMappingTags,
+		"refs",
+		"unexpected refs %s", rm.Refs,
+	)
+	v.FailWhen(
+		rm.Replace == "" && rm.StripPrefix == "" && rm.AddPrefix == "",
+		"replace/stripPrefix/addPrefix",
+		"at least one of replace, strip prefix, or add prefix must be specified",
+	)
+	v.FailWhen(
+		rm.Replace != "" && rm.Match.IsEmpty(),
+		"match",
+		"match must be specified when replace is used",
+	)
 }
 
 func (cfg *Config) validate(v *validation.V) {
+	cfg.validateOptions(v)
+	cfg.Server.validate(cfg.origins.optionV(v, optionServer).Sub("server"))
 	v.FailWhen(
 		len(cfg.Repositories) == 0,
 		"repositories",
@@ -373,17 +942,31 @@ func (cfg *Config) validate(v *validation.V) {
 		"at least one mapping must be specified",
 	)
 
-	reposV := v.Sub("repositories")
+	for profileId, profile := range cfg.Profiles {
+		profile.validate(cfg.origins.profileV(v, profileId))
+	}
+
 	for repoId, repo := range cfg.Repositories {
-		repoV := reposV.Sub(repoId)
+		repoV := cfg.origins.repositoryV(v, repoId)
 		repo.validate(repoV)
+		repo.validateProfiles(repoV, cfg.Profiles)
 	}
 
-	mappingsV := v.Sub("mappings")
+	mappingIds := make(map[string]int, len(cfg.Mappings))
 	for i, mapping := range cfg.Mappings {
-		mappingV := mappingsV.IndexedSub(i)
+		mappingV := cfg.origins.mappingV(v, i)
 		mapping.validate(mappingV)
 
+		if first, ok := mappingIds[mapping.Id]; ok {
+			mappingV.FailF(
+				"id",
+				"mapping ID %s is already used by mapping %d, set a unique id for the mapping",
+				mapping.Id, first,
+			)
+		} else {
+			mappingIds[mapping.Id] = i
+		}
+
 		if _, ok := cfg.Repositories[mapping.Source]; !ok {
 			mappingV.FailF("source", "source %s is not specified", mapping.Source)
 		}
@@ -397,6 +980,47 @@ func (cfg *Config) validate(v *validation.V) {
 	}
 }
 
+// validateOptions validates the fields that are shared
+// with the single repository config.
+func (cfg *Config) validateOptions(v *validation.V) {
+	cfg.origins.optionV(v, optionConcurrency).FailWhen(
+		cfg.Concurrency < 0,
+		"concurrency",
+		"concurrency cannot be negative",
+	)
+	cfg.Tracing.validate(cfg.origins.optionV(v, optionTracing).Sub("tracing"))
+}
+
+func (t *Tracing) validate(v *validation.V) {
+	if t.Endpoint == "" {
+		return
+	}
+	u, err := url.Parse(t.Endpoint)
+	v.FailWhen(
+		err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "",
+		"endpoint",
+		"must be an HTTP or HTTPS URL",
+	)
+}
+
+func (s *Server) validate(v *validation.V) {
+	v.FailWhen(
+		s.WebhookPath != "" && !strings.HasPrefix(s.WebhookPath, "/"),
+		"webhookPath",
+		"must start with /",
+	)
+	v.FailWhen(
+		s.LivenessDeadline.Nanoseconds() < 0,
+		"livenessDeadline",
+		"must not be negative",
+	)
+	v.FailWhen(
+		s.StatusHistory < 0,
+		"statusHistory",
+		"must not be negative",
+	)
+}
+
 func (sm *SyncMapping) validate(v *validation.V) {
 	v.FailWhen(
 		sm.Source == "",
@@ -424,6 +1048,7 @@ func (r *Repository) validate(v *validation.V) {
 		"url",
 		"both repository URL and local path cannot be empty",
 	)
+	r.Retry.validate(v.Sub("retry"))
 
 	var authV *validation.V
 	switch r.TargetAuthMethod {
@@ -450,7 +1075,7 @@ func (r *Repository) validate(v *validation.V) {
 	case AuthMethodSshAgent:
 		authV = v.Sub("sshCredentials")
 		authV.FailWhen(
-			!r.SshCredentials.UseAgent,
+			!r.SshCredentials.UsesAgent(),
 			"useAgent",
 			"expected SSH agent to be enabled",
 		)
@@ -461,24 +1086,154 @@ func (r *Repository) validate(v *validation.V) {
 			"keyPath",
 			"expected SSH key path to be set",
 		)
+	case AuthMethodCredentialHelper:
+		v.Sub("credentialHelper").FailWhen(
+			r.CredentialHelper.Command == "",
+			"command",
+			"expected credential helper command to be set",
+		)
+	case AuthMethodExec:
+		v.Sub("exec").FailWhen(
+			r.Exec.Command == "",
+			"command",
+			"expected exec command to be set",
+		)
+	case AuthMethodGitHubApp:
+		r.GitHubApp.validate(v.Sub("githubApp"))
 	default:
 		v.FailF("authMethod", "unexpected auth method %s", r.TargetAuthMethod)
 	}
+
+	if r.TargetAuthMethod == AuthMethodUndefined && r.GitHubApp.enabled() {
+		r.GitHubApp.validate(v.Sub("githubApp"))
+	}
+
+	var httpOnlyAuth string
+	switch r.AuthMethod() {
+	case AuthMethodCredentialHelper:
+		httpOnlyAuth = "credential helper"
+	case AuthMethodGitHubApp:
+		httpOnlyAuth = "GitHub App auth"
+	}
+	if httpOnlyAuth != "" {
+		u, err := url.Parse(r.URL)
+		v.FailFWhen(
+			err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "",
+			"url",
+			"%s requires an HTTP or HTTPS URL",
+			httpOnlyAuth,
+		)
+	}
+}
+
+func (g *GitHubApp) validate(v *validation.V) {
+	v.FailWhen(g.AppId <= 0, "appId", "expected GitHub App ID to be set")
+	v.FailWhen(g.InstallationId <= 0, "installationId", "expected GitHub App installation ID to be set")
+	v.FailWhen(g.PrivateKeyPath == "", "privateKeyPath", "expected GitHub App private key path to be set")
+	if g.ApiURL != "" {
+		u, err := url.Parse(g.ApiURL)
+		v.FailWhen(
+			err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "",
+			"apiUrl",
+			"must be an HTTP or HTTPS URL",
+		)
+	}
+}
+
+func (r *Retry) validate(v *validation.V) {
+	v.FailWhen(
+		r.MaxAttempts < 0,
+		"maxAttempts",
+		"must not be negative",
+	)
+	v.FailWhen(
+		r.MinDelay.Nanoseconds() < 0,
+		"minDelay",
+		"must not be negative",
+	)
+	v.FailWhen(
+		r.MaxDelay.Nanoseconds() < 0,
+		"maxDelay",
+		"must not be negative",
+	)
+	v.FailWhen(
+		r.MaxDelay.Nanoseconds() > 0 && r.MaxDelay.Nanoseconds() < r.MinDelay.Nanoseconds(),
+		"maxDelay",
+		"must not be less than min delay",
+	)
+	v.FailWhen(
+		r.Jitter.Nanoseconds() < 0,
+		"jitter",
+		"must not be negative",
+	)
+	switch r.Backoff {
+	case BackoffUndefined, BackoffConstant, BackoffLinear, BackoffExponential:
+	default:
+		v.FailF("backoff", "unexpected backoff %s", r.Backoff)
+	}
 }
 
 /////////////////////////////////////////////////
 // Parsing
 /////////////////////////////////////////////////
 
+// Parse parses the config and the credentials in JSON format.
+// Comments and trailing commas are allowed in the JSON.
+// The secret files referenced in the config are read from the given file system.
 func (cfg *Config) Parse(
 	envVars envvar.Vars,
+	fs billy.Filesystem,
 	config io.Reader,
 	credentials io.Reader,
 ) error {
-	var temp struct {
-		ConfigSingle
-		Config
+	return cfg.ParseFormat(envVars, fs, config, FormatJSON, credentials, FormatJSON)
+}
+
+// ParseFormat parses the config and the credentials in the given formats.
+// All of the formats are converted to JSON before decoding them,
+// so they are validated the same way.
+func (cfg *Config) ParseFormat(
+	envVars envvar.Vars,
+	fs billy.Filesystem,
+	config io.Reader,
+	configFormat Format,
+	credentials io.Reader,
+	credentialsFormat Format,
+) error {
+	configJSON, err := ToJSON(config, configFormat)
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
 	}
+	if credentials != nil {
+		credentialsJSON, err := ToJSON(credentials, credentialsFormat)
+		if err != nil {
+			return fmt.Errorf("failed to parse credentials: %w", err)
+		}
+		credentials = bytes.NewReader(credentialsJSON)
+	}
+	return cfg.parseJSON(envVars, fs, bytes.NewReader(configJSON), credentials)
+}
+
+// rawConfig is a config decoded before it's known
+// whether it's a single or a full config.
+type rawConfig struct {
+	ConfigSingle
+	Config
+}
+
+// isSingle reports whether the config is a single config.
+// The full config is recognized from the repositories and the mappings.
+func (raw *rawConfig) isSingle() bool {
+	return len(raw.Repositories) == 0 && len(raw.Mappings) == 0
+}
+
+func (cfg *Config) parseJSON(
+	envVars envvar.Vars,
+	fs billy.Filesystem,
+	config io.Reader,
+	credentials io.Reader,
+) error {
+	var temp rawConfig
 
 	// Read config stream (JSON)
 	if err := json.NewDecoder(config).Decode(&temp); err != nil {
@@ -486,25 +1241,49 @@ func (cfg *Config) Parse(
 	}
 
 	// Full config not specified, so we assume there's a single config
-	if len(temp.Repositories) == 0 && len(temp.Mappings) == 0 {
-		if err := temp.ConfigSingle.parse(envVars, credentials); err != nil {
-			return err
-		}
-		cfg.fromSingle(&temp.ConfigSingle)
-		return nil
+	if temp.isSingle() {
+		return cfg.parseSingle(envVars, fs, &temp, "", credentials)
 	}
 
 	// Parse full config
-	if err := temp.Config.parse(envVars, credentials); err != nil {
+	if err := temp.Config.parse(envVars, fs, credentials); err != nil {
 		return err
 	}
 	*cfg = temp.Config
 	return nil
 }
 
+// parseSingle parses the single config from the raw config.
+// The validation errors are reported under the file when it's given.
+func (cfg *Config) parseSingle(
+	envVars envvar.Vars,
+	fs billy.Filesystem,
+	raw *rawConfig,
+	file string,
+	credentials io.Reader,
+) error {
+	if err := raw.ConfigSingle.parse(envVars, fs, credentials, raw.Profiles, file); err != nil {
+		return err
+	}
+	cfg.fromSingle(&raw.ConfigSingle)
+	cfg.Profiles = raw.Profiles
+	cfg.Concurrency = raw.Concurrency
+	cfg.StateDir = raw.StateDir
+	cfg.Tracing = raw.Tracing
+	cfg.resolveOptionsEnvVars(newResolver(envVars, fs))
+
+	var v validation.V
+	v.Init()
+	cfg.validateOptions(fileV(&v, file))
+	return v.ToError()
+}
+
 func (cs *ConfigSingle) parse(
 	envVars envvar.Vars,
+	fs billy.Filesystem,
 	credentials io.Reader,
+	profiles map[string]Profile,
+	file string,
 ) error {
 	// When credentials stream is defined, read credentials (JSON) and
 	// merge them to the config.
@@ -512,20 +1291,29 @@ func (cs *ConfigSingle) parse(
 	if err != nil {
 		return err
 	}
-	cs.mergeCredentials(parsedCreds)
+	cs.mergeCredentials(parsedCreds, profiles)
+
+	// Inherit the settings from the profiles
+	for targetId, target := range cs.Targets {
+		target.applyProfiles(profiles)
+		cs.Targets[targetId] = target
+	}
 
 	// Resolve any environment variables used in strings
-	cs.resolveEnvVars(envVars.ToMap())
+	cs.resolveEnvVars(newResolver(envVars, fs))
 
-	// Validate the config
+	// Read the secrets from files and validate the config
 	var v validation.V
 	v.Init()
-	cs.validate(&v)
+	fv := fileV(&v, file)
+	cs.readSecretFiles(fv, fs)
+	cs.validate(fv, profiles)
 	return v.ToError()
 }
 
 func (cfg *Config) parse(
 	envVars envvar.Vars,
+	fs billy.Filesystem,
 	credentials io.Reader,
 ) error {
 	// When credentials stream is defined, read credentials (JSON) and
@@ -536,12 +1324,17 @@ func (cfg *Config) parse(
 	}
 	cfg.mergeCredentials(parsedCreds)
 
+	// Inherit the settings from the profiles
+	cfg.applyProfiles()
+	cfg.applyMappingIds()
+
 	// Resolve any environment variables used in strings
-	cfg.resolveEnvVars(envVars.ToMap())
+	cfg.resolveEnvVars(newResolver(envVars, fs))
 
-	// Validate the config
+	// Read the secrets from files and validate the config
 	var v validation.V
 	v.Init()
+	cfg.readSecretFiles(&v, fs)
 	cfg.validate(&v)
 	return v.ToError()
 }
@@ -566,7 +1359,6 @@ func (cfg *Config) fromSingle(cs *ConfigSingle) {
 		},
 		LocalPath: cs.Path,
 		URL:       "",
-		InMemory:  false,
 	}
 
 	for targetId, target := range cs.Targets {
@@ -577,4 +1369,32 @@ func (cfg *Config) fromSingle(cs *ConfigSingle) {
 			SyncSpec: target.SyncSpec,
 		})
 	}
+	cfg.applyMappingIds()
+}
+
+// applyMappingIds sets the default ID for the mappings without an ID.
+// When the default ID is already taken by another mapping,
+// the mapping index is appended to it to keep the IDs unique.
+func (cfg *Config) applyMappingIds() {
+	usedIds := make(map[string]bool, len(cfg.Mappings))
+	for _, mapping := range cfg.Mappings {
+		if mapping.Id != "" {
+			usedIds[mapping.Id] = true
+		}
+	}
+	for i := range cfg.Mappings {
+		if cfg.Mappings[i].Id != "" {
+			continue
+		}
+		id := cfg.Mappings[i].defaultId()
+		if usedIds[id] {
+			id = fmt.Sprintf("%s#%d", id, i)
+		}
+		usedIds[id] = true
+		cfg.Mappings[i].Id = id
+	}
+}
+
+func (sm *SyncMapping) defaultId() string {
+	return sm.Source + "->" + strings.Join(sm.Targets, ",")
 }
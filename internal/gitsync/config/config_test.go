@@ -2,20 +2,24 @@ package config
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/cron"
 	"go.lepovirta.org/otk/internal/duration"
 	"go.lepovirta.org/otk/internal/envvar"
 	"go.lepovirta.org/otk/internal/matcher"
+	"go.lepovirta.org/otk/internal/validation"
 )
 
 var envVarsMap = map[string]string{
 	"HOME":                    "/home/testuser",
 	"GITLAB_SSH_KEY_PASSWORD": "gitlab_ssh_password",
 	"GITLAB_USERNAME":         "gitlabuser",
+	"TRACING_TOKEN":           "tracing_token",
 }
 
 const goodConfigJson = `
@@ -44,7 +48,14 @@ const goodConfigJson = `
         "keyPath": "./gitlab/ssh-key.ed25519",
         "keyPassword": "${GITLAB_SSH_KEY_PASSWORD}"
       },
-      "url": "ssh://gitlab.com:${GITLAB_USERNAME}/otk.git"
+      "url": "ssh://gitlab.com:${GITLAB_USERNAME}/otk.git",
+      "retry": {
+        "maxAttempts": 3,
+        "backoff": "linear",
+        "minDelay": "2s",
+        "maxDelay": "10s",
+        "jitter": "500ms"
+      }
     },
     "keruu-gitlab": {
       "authMethod": "http-token",
@@ -61,6 +72,18 @@ const goodConfigJson = `
       "url": "https://gitlab.com/${GITLAB_USERNAME}/yahe.git"
     }
   },
+  "concurrency": 4,
+  "stateDir": "${HOME}/.local/state/gitsync",
+  "server": {
+    "address": ":8080",
+    "metrics": true,
+    "livenessDeadline": "30m",
+    "statusHistory": 20
+  },
+  "tracing": {
+    "endpoint": "http://localhost:4318",
+    "headers": { "Authorization": "Bearer ${TRACING_TOKEN}" }
+  },
   "mappings": [
     {
       "source": "otk-github",
@@ -69,16 +92,23 @@ const goodConfigJson = `
       "branches": [ { "spec": "main" } ],
       "tags": [
         { "spec": "v.*", "useRegex": true }
+      ],
+      "refMappings": [
+        { "refs": "branches", "match": "main", "addPrefix": "upstream/" },
+        { "refs": "tags", "match": "/^v(.*)$/", "replace": "vendor/acme/v$1" }
       ]
     },
     {
       "source": "keruu-github",
       "targets": [ "keruu-gitlab", "keruu-ssh" ],
       "interval": "6h",
+      "pollInterval": "30s",
       "branches": [
         { "spec": "main.*", "useRegex": true }
       ],
-      "tags": []
+      "tags": [],
+      "refs": [ "refs/notes/commits", "/^refs/pull/[0-9]+/head$/" ],
+      "prune": true
     },
     {
       "source": "yahe-github",
@@ -87,7 +117,23 @@ const goodConfigJson = `
       "branches": [ { "spec": "main" } ],
       "tags": [
         { "spec": "release-.*", "useRegex": true }
-      ]
+      ],
+      "updatePolicy": "fast-forward-only"
+    },
+    {
+      "id": "yahe-nightly",
+      "source": "yahe-github",
+      "targets": [ "yahe-gitlab" ],
+      "schedule": "0 2 * * mon-fri",
+      "timeZone": "UTC",
+      "jitter": "5m",
+      "windows": [
+        { "days": [ "mon-fri" ], "start": "01:00", "end": "05:00" }
+      ],
+      "blackouts": [
+        { "from": "2026-12-20", "until": "2027-01-06" }
+      ],
+      "branches": [ { "spec": "develop" } ]
     }
   ]
 }
@@ -98,7 +144,8 @@ const goodCredentialsJson = `
   "keruu-github": {
     "httpCredentials": {
       "password": "testuser_password"
-    }
+    },
+    "webhookSecret": "webhook_secret"
   },
   "keruu-gitlab": {
     "httpToken": "http_token"
@@ -122,11 +169,11 @@ var goodConfig = Config{
 		"otk-github": {
 			Credentials: Credentials{
 				SshCredentials: SshCredentials{
-					UseAgent: true,
+					UseAgent: new(true),
 				},
 			},
 			URL:      "ssh://github.com:jpallari/otk.git",
-			InMemory: true,
+			InMemory: new(true),
 		},
 		"keruu-github": {
 			Credentials: Credentials{
@@ -134,6 +181,7 @@ var goodConfig = Config{
 					Username: "testuser",
 					Password: "testuser_password",
 				},
+				WebhookSecret: "webhook_secret",
 			},
 			URL: "https://github.com/jpallari/keruu.git",
 		},
@@ -150,6 +198,13 @@ var goodConfig = Config{
 				},
 			},
 			URL: "ssh://gitlab.com:gitlabuser/otk.git",
+			Retry: Retry{
+				MaxAttempts: 3,
+				Backoff:     BackoffLinear,
+				MinDelay:    duration.New(2 * time.Second),
+				MaxDelay:    duration.New(10 * time.Second),
+				Jitter:      duration.New(500 * time.Millisecond),
+			},
 		},
 		"keruu-gitlab": {
 			Credentials: Credentials{
@@ -163,7 +218,7 @@ var goodConfig = Config{
 				SshCredentials: SshCredentials{
 					KeyPassword:   "ssh_key_password",
 					KeyPath:       "/home/testuser/.ssh/ssh-key.ed25519",
-					IgnoreHostKey: true,
+					IgnoreHostKey: new(true),
 				},
 			},
 			URL: "ssh://192.168.100.69/srv/git/keruu.git",
@@ -178,8 +233,21 @@ var goodConfig = Config{
 			URL: "https://gitlab.com/gitlabuser/yahe.git",
 		},
 	},
+	Concurrency: 4,
+	StateDir:    "/home/testuser/.local/state/gitsync",
+	Server: Server{
+		Address:          ":8080",
+		Metrics:          true,
+		LivenessDeadline: duration.New(30 * time.Minute),
+		StatusHistory:    20,
+	},
+	Tracing: Tracing{
+		Endpoint: "http://localhost:4318",
+		Headers:  map[string]string{"Authorization": "Bearer tracing_token"},
+	},
 	Mappings: []SyncMapping{
 		{
+			Id:      "otk-github->otk-gitlab",
 			Source:  "otk-github",
 			Targets: []string{"otk-gitlab"},
 			SyncSpec: SyncSpec{
@@ -190,20 +258,40 @@ var goodConfig = Config{
 				Tags: []matcher.M{
 					matcher.FromStringOrPanic(`/v.*/`),
 				},
+				RefMappings: []RefMapping{
+					{
+						Refs:      RefMappingBranches,
+						Match:     matcher.FromStringOrPanic("main"),
+						AddPrefix: "upstream/",
+					},
+					{
+						Refs:    RefMappingTags,
+						Match:   matcher.FromStringOrPanic(`/^v(.*)$/`),
+						Replace: "vendor/acme/v$1",
+					},
+				},
 			},
 		},
 		{
+			Id:      "keruu-github->keruu-gitlab,keruu-ssh",
 			Source:  "keruu-github",
 			Targets: []string{"keruu-gitlab", "keruu-ssh"},
 			SyncSpec: SyncSpec{
-				Interval: duration.New(time.Duration(6) * time.Hour),
+				Interval:     duration.New(time.Duration(6) * time.Hour),
+				PollInterval: duration.New(30 * time.Second),
 				Branches: []matcher.M{
 					matcher.FromStringOrPanic(`/main.*/`),
 				},
 				Tags: []matcher.M{},
+				Refs: []matcher.M{
+					matcher.FromStringOrPanic("refs/notes/commits"),
+					matcher.FromStringOrPanic(`/^refs/pull/[0-9]+/head$/`),
+				},
+				Prune: true,
 			},
 		},
 		{
+			Id:      "yahe-github->yahe-gitlab",
 			Source:  "yahe-github",
 			Targets: []string{"yahe-gitlab"},
 			SyncSpec: SyncSpec{
@@ -214,11 +302,42 @@ var goodConfig = Config{
 				Tags: []matcher.M{
 					matcher.FromStringOrPanic(`/release-.*/`),
 				},
+				UpdatePolicy: UpdatePolicyFastForwardOnly,
+			},
+		},
+		{
+			Id:      "yahe-nightly",
+			Source:  "yahe-github",
+			Targets: []string{"yahe-gitlab"},
+			SyncSpec: SyncSpec{
+				Schedule: cron.MustParse("0 2 * * mon-fri"),
+				TimeZone: "UTC",
+				Jitter:   duration.New(5 * time.Minute),
+				Windows: validTimeWindows(
+					TimeWindow{Days: []string{"mon-fri"}, Start: "01:00", End: "05:00"},
+				),
+				Blackouts: validTimeWindows(
+					TimeWindow{From: "2026-12-20", Until: "2027-01-06"},
+				),
+				Branches: []matcher.M{
+					matcher.FromStringOrPanic("develop"),
+				},
 			},
 		},
 	},
 }
 
+// validTimeWindows parses the windows in UTC like the validation does.
+func validTimeWindows(windows ...TimeWindow) []TimeWindow {
+	var v validation.V
+	v.Init()
+	validateTimeWindows(&v, windows, time.UTC)
+	if err := v.ToError(); err != nil {
+		panic(err)
+	}
+	return windows
+}
+
 func TestParseGood(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
@@ -228,8 +347,338 @@ func TestParseGood(t *testing.T) {
 	configStream := bytes.NewBufferString(goodConfigJson)
 	credentialsStream := bytes.NewBufferString(goodCredentialsJson)
 
-	err := conf.Parse(envVars, configStream, credentialsStream)
+	err := conf.Parse(envVars, nil, configStream, credentialsStream)
 	require.NoError(err, "config parse")
 
 	assert.Equal(goodConfig, conf)
 }
+
+const refMappingCollisionConfigJson = `
+{
+  "repositories": {
+    "source": { "url": "https://github.com/jpallari/otk.git" },
+    "target": { "url": "https://gitlab.com/jpallari/otk.git" }
+  },
+  "mappings": [
+    {
+      "source": "source",
+      "targets": [ "target" ],
+      "branches": [ "main", "internal/main" ],
+      "refMappings": [
+        { "stripPrefix": "internal/" }
+      ]
+    }
+  ]
+}
+`
+
+func TestParseRefMappingCollision(t *testing.T) {
+	assert := assert.New(t)
+	var conf Config
+	var envVars envvar.Vars
+	configStream := bytes.NewBufferString(refMappingCollisionConfigJson)
+
+	err := conf.Parse(envVars, nil, configStream, nil)
+
+	assert.ErrorContains(err, "both main and internal/main are mapped to main")
+}
+
+func TestParseDefaultMappingIdsAreUnique(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	var conf Config
+	var envVars envvar.Vars
+	configStream := bytes.NewBufferString(`{
+  "repositories": {
+    "source": { "url": "https://github.com/jpallari/otk.git" },
+    "target": { "url": "https://gitlab.com/jpallari/otk.git" }
+  },
+  "mappings": [
+    { "source": "source", "targets": [ "target" ], "branches": [ "main" ] },
+    { "source": "source", "targets": [ "target" ], "tags": [ "/^v/" ] },
+    { "id": "nightly", "source": "source", "targets": [ "target" ], "branches": [ "nightly" ] }
+  ]
+}`)
+
+	err := conf.Parse(envVars, nil, configStream, nil)
+
+	require.NoError(err)
+	assert.Equal("source->target", conf.Mappings[0].Id)
+	assert.Equal("source->target#1", conf.Mappings[1].Id)
+	assert.Equal("nightly", conf.Mappings[2].Id)
+}
+
+func TestParseDuplicateMappingId(t *testing.T) {
+	assert := assert.New(t)
+	var conf Config
+	var envVars envvar.Vars
+	configStream := bytes.NewBufferString(`{
+  "repositories": {
+    "source": { "url": "https://github.com/jpallari/otk.git" },
+    "target": { "url": "https://gitlab.com/jpallari/otk.git" }
+  },
+  "mappings": [
+    { "id": "otk", "source": "source", "targets": [ "target" ], "branches": [ "main" ] },
+    { "id": "otk", "source": "source", "targets": [ "target" ], "tags": [ "/^v/" ] }
+  ]
+}`)
+
+	err := conf.Parse(envVars, nil, configStream, nil)
+
+	assert.ErrorContains(err, "mapping ID otk is already used by mapping 0")
+}
+
+func TestTargetNamespace(t *testing.T) {
+	ss := SyncSpec{
+		RefMappings: []RefMapping{
+			{
+				Refs:    RefMappingTags,
+				Match:   matcher.FromStringOrPanic(`/^release-(?P<major>[0-9]+)\.(?P<minor>[0-9]+)$/`),
+				Replace: "v${major}.$minor",
+			},
+			{
+				Refs:    RefMappingTags,
+				Match:   matcher.FromStringOrPanic("latest"),
+				Replace: "stable",
+			},
+			{
+				Refs:    RefMappingTags,
+				Match:   matcher.FromStringOrPanic(`/rc/`),
+				Replace: "pre",
+			},
+		},
+	}
+	selected := func(name string) bool {
+		return strings.HasPrefix(name, "release-") || name == "latest" || strings.Contains(name, "rc")
+	}
+	ns := ss.TargetNamespace()
+
+	tests := []struct {
+		name     string
+		contains bool
+	}{
+		{"v1.2", true},
+		{"v1.x", false},
+		{"stable", true},
+		{"latest", false},
+		// Unanchored regex replacements are not reversed
+		{"1.0-pre", false},
+		{"1.0-rc", false},
+		{"other", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.contains, ns.Contains(RefMappingTags, test.name, selected))
+		})
+	}
+}
+
+func TestParseInvalidTracingEndpoint(t *testing.T) {
+	assert := assert.New(t)
+	var conf Config
+	var envVars envvar.Vars
+	configStream := bytes.NewBufferString(`{
+  "path": ".",
+  "targets": {
+    "target": { "url": "https://gitlab.com/jpallari/otk.git", "branches": [ "main" ] }
+  },
+  "tracing": { "endpoint": "localhost:4318" }
+}`)
+
+	err := conf.Parse(envVars, nil, configStream, nil)
+
+	assert.ErrorContains(err, "endpoint: must be an HTTP or HTTPS URL")
+}
+
+func TestParseScheduleWithInterval(t *testing.T) {
+	assert := assert.New(t)
+	var conf Config
+	var envVars envvar.Vars
+	configStream := bytes.NewBufferString(`{
+  "path": ".",
+  "targets": {
+    "target": {
+      "url": "https://gitlab.com/jpallari/otk.git",
+      "branches": [ "main" ],
+      "interval": "1h",
+      "schedule": "@daily",
+      "timeZone": "Nowhere/Unknown"
+    }
+  }
+}`)
+
+	err := conf.Parse(envVars, nil, configStream, nil)
+
+	assert.ErrorContains(err, "interval and schedule cannot be used together")
+	assert.ErrorContains(err, "unknown time zone Nowhere/Unknown")
+}
+
+func TestSyncSpecNextSync(t *testing.T) {
+	assert := assert.New(t)
+	last := time.Date(2025, 5, 2, 12, 30, 0, 0, time.UTC)
+
+	var ss SyncSpec
+	assert.Equal(last.Add(time.Hour), ss.NextSync(last))
+	assert.Equal("every 1h0m0s", ss.ScheduleString())
+
+	ss.Interval = duration.New(30 * time.Minute)
+	assert.Equal(last.Add(30*time.Minute), ss.NextSync(last))
+
+	ss = SyncSpec{
+		Schedule: cron.MustParse("0 2 * * mon-fri"),
+		TimeZone: "UTC",
+		Jitter:   duration.New(time.Minute),
+	}
+	assert.True(time.Date(2025, 5, 5, 2, 0, 0, 0, time.UTC).Equal(ss.NextSync(last)))
+	assert.Equal("0 2 * * mon-fri (UTC) with jitter up to 1m0s", ss.ScheduleString())
+}
+
+func TestParseCredentialHelper(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	var conf Config
+	var envVars envvar.Vars
+	envVars.FromMap(map[string]string{"HELPER": "/usr/bin/git-credential-store"})
+
+	err := conf.Parse(envVars, nil, bytes.NewBufferString(`{
+  "repositories": {
+    "source": { "url": "git@github.com:jpallari/otk.git" },
+    "target": {
+      "url": "https://gitlab.com/jpallari/otk.git",
+      "credentialHelper": { "command": "${HELPER}", "args": [ "--file", "/creds" ] }
+    }
+  },
+  "mappings": [ { "source": "source", "targets": [ "target" ], "branches": [ "main" ] } ]
+}`), nil)
+	require.NoError(err)
+
+	target := conf.Repositories["target"]
+	assert.Equal(AuthMethodCredentialHelper, target.AuthMethod())
+	assert.Equal("/usr/bin/git-credential-store", target.CredentialHelper.Command)
+	assert.Equal([]string{"--file", "/creds"}, target.CredentialHelper.Args)
+}
+
+func TestParseCredentialHelperInvalid(t *testing.T) {
+	assert := assert.New(t)
+	var conf Config
+	var envVars envvar.Vars
+
+	err := conf.Parse(envVars, nil, bytes.NewBufferString(`{
+  "repositories": {
+    "source": {
+      "url": "git@github.com:jpallari/otk.git",
+      "credentialHelper": { "command": "git-credential-store" }
+    },
+    "target": {
+      "url": "https://gitlab.com/jpallari/otk.git",
+      "authMethod": "credential-helper"
+    }
+  },
+  "mappings": [ { "source": "source", "targets": [ "target" ], "branches": [ "main" ] } ]
+}`), nil)
+
+	assert.ErrorContains(err, "credential helper requires an HTTP or HTTPS URL")
+	assert.ErrorContains(err, "command: expected credential helper command to be set")
+}
+
+func TestParseExecCredentials(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	var conf Config
+	var envVars envvar.Vars
+	envVars.FromMap(map[string]string{"BROKER_KEY": "broker_key"})
+
+	err := conf.Parse(envVars, nil, bytes.NewBufferString(`{
+  "profiles": {
+    "broker": {
+      "exec": { "command": "token-broker", "args": [ "issue" ], "env": { "BROKER_KEY": "${BROKER_KEY}" } }
+    }
+  },
+  "repositories": {
+    "source": { "url": "https://github.com/jpallari/otk.git", "profile": "broker" },
+    "target": { "url": "https://gitlab.com/jpallari/otk.git", "profile": "broker" }
+  },
+  "mappings": [ { "source": "source", "targets": [ "target" ], "branches": [ "main" ] } ]
+}`), nil)
+	require.NoError(err)
+
+	source := conf.Repositories["source"]
+	assert.Equal(AuthMethodExec, source.AuthMethod())
+	assert.Equal("token-broker", source.Exec.Command)
+	assert.Equal([]string{"issue"}, source.Exec.Args)
+	assert.Equal(map[string]string{"BROKER_KEY": "broker_key"}, source.Exec.Env)
+	assert.Equal(map[string]string{"BROKER_KEY": "broker_key"}, conf.Repositories["target"].Exec.Env)
+}
+
+func TestParseExecCredentialsInvalid(t *testing.T) {
+	assert := assert.New(t)
+	var conf Config
+	var envVars envvar.Vars
+
+	err := conf.Parse(envVars, nil, bytes.NewBufferString(`{
+  "repositories": {
+    "source": { "url": "https://github.com/jpallari/otk.git" },
+    "target": { "url": "https://gitlab.com/jpallari/otk.git", "authMethod": "exec" }
+  },
+  "mappings": [ { "source": "source", "targets": [ "target" ], "branches": [ "main" ] } ]
+}`), nil)
+
+	assert.ErrorContains(err, "exec:\n      command: expected exec command to be set")
+}
+
+func TestParseGitHubApp(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	var conf Config
+	var envVars envvar.Vars
+
+	err := conf.Parse(envVars, nil, bytes.NewBufferString(`{
+  "repositories": {
+    "source": { "url": "https://gitlab.com/jpallari/otk.git" },
+    "github": {
+      "url": "https://github.com/jpallari/otk.git",
+      "githubApp": { "appId": 1234, "installationId": 42, "privateKeyPath": "/keys/app.pem" }
+    },
+    "ghes": {
+      "url": "https://github.example.com/jpallari/otk.git",
+      "authMethod": "github-app",
+      "githubApp": {
+        "appId": 1234,
+        "installationId": 43,
+        "privateKeyPath": "/keys/app.pem",
+        "apiUrl": "https://github.example.com/api/v3/"
+      }
+    }
+  },
+  "mappings": [ { "source": "source", "targets": [ "github", "ghes" ], "branches": [ "main" ] } ]
+}`), nil)
+	require.NoError(err)
+
+	github := conf.Repositories["github"]
+	assert.Equal(AuthMethodGitHubApp, github.AuthMethod())
+	assert.Equal("https://api.github.com", github.GitHubApp.APIBaseURL())
+	ghes := conf.Repositories["ghes"]
+	assert.Equal("https://github.example.com/api/v3", ghes.GitHubApp.APIBaseURL())
+}
+
+func TestParseGitHubAppInvalid(t *testing.T) {
+	assert := assert.New(t)
+	var conf Config
+	var envVars envvar.Vars
+
+	err := conf.Parse(envVars, nil, bytes.NewBufferString(`{
+  "repositories": {
+    "source": {
+      "url": "git@github.com:jpallari/otk.git",
+      "githubApp": { "appId": 1234, "apiUrl": "api.github.com" }
+    },
+    "target": { "url": "https://gitlab.com/jpallari/otk.git" }
+  },
+  "mappings": [ { "source": "source", "targets": [ "target" ], "branches": [ "main" ] } ]
+}`), nil)
+
+	assert.ErrorContains(err, "GitHub App auth requires an HTTP or HTTPS URL")
+	assert.ErrorContains(err, "installationId: expected GitHub App installation ID to be set")
+	assert.ErrorContains(err, "privateKeyPath: expected GitHub App private key path to be set")
+	assert.ErrorContains(err, "apiUrl: must be an HTTP or HTTPS URL")
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.lepovirta.org/otk/internal/jsonc"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the file format of the config and the credentials.
+type Format int
+
+const (
+	// FormatUndefined means that the format is detected from the file extension.
+	// JSON is used when the format can't be detected.
+	FormatUndefined Format = iota
+
+	// FormatJSON is JSON with optional comments and trailing commas.
+	FormatJSON
+
+	// FormatYAML is YAML.
+	FormatYAML
+
+	// FormatTOML is TOML.
+	FormatTOML
+)
+
+// FormatFromPath detects the format from the file extension.
+func FormatFromPath(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".jsonc":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatUndefined
+	}
+}
+
+// Set parses the format from the flag value.
+func (f *Format) Set(s string) error {
+	switch strings.ToLower(s) {
+	case "", "auto":
+		*f = FormatUndefined
+	case "json", "jsonc":
+		*f = FormatJSON
+	case "yaml", "yml":
+		*f = FormatYAML
+	case "toml":
+		*f = FormatTOML
+	default:
+		return fmt.Errorf("unexpected value '%s' for config format", s)
+	}
+	return nil
+}
+
+func (f Format) String() string {
+	switch f {
+	case FormatUndefined:
+		return ""
+	case FormatJSON:
+		return "json"
+	case FormatYAML:
+		return "yaml"
+	case FormatTOML:
+		return "toml"
+	default:
+		return fmt.Sprintf("unknown(%d)", f)
+	}
+}
+
+// ToJSON reads the config in the given format and converts it to JSON,
+// so that all of the formats are decoded and validated the same way.
+func ToJSON(r io.Reader, format Format) ([]byte, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var v any
+	switch format {
+	case FormatUndefined, FormatJSON:
+		return jsonc.ToJSON(b)
+	case FormatYAML:
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+	case FormatTOML:
+		if err := toml.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("invalid TOML: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown config format %s", format)
+	}
+	if v == nil {
+		// Empty document
+		return []byte("{}"), nil
+	}
+	return json.Marshal(normalize(v))
+}
+
+// normalize converts the values decoded from YAML and TOML to values
+// that can be encoded as JSON. Dates and times are converted to the
+// string formats used in the config.
+func normalize(v any) any {
+	switch value := v.(type) {
+	case map[string]any:
+		for k, item := range value {
+			value[k] = normalize(item)
+		}
+		return value
+	case map[any]any:
+		m := make(map[string]any, len(value))
+		for k, item := range value {
+			m[fmt.Sprint(k)] = normalize(item)
+		}
+		return m
+	case []map[string]any:
+		items := make([]any, len(value))
+		for i, item := range value {
+			items[i] = normalize(item)
+		}
+		return items
+	case []any:
+		for i, item := range value {
+			value[i] = normalize(item)
+		}
+		return value
+	case time.Time:
+		return timeString(value)
+	default:
+		return value
+	}
+}
+
+func timeString(t time.Time) string {
+	// TOML local dates and times are decoded using these time zones
+	switch t.Location().String() {
+	case "date-local":
+		return t.Format(time.DateOnly)
+	case "time-local":
+		if t.Second() == 0 {
+			return t.Format(timeOfDayFormat)
+		}
+		return t.Format(time.TimeOnly)
+	case "datetime-local":
+		return t.Format("2006-01-02T15:04:05")
+	}
+	if t.Location() == time.UTC && t.Equal(t.Truncate(24*time.Hour)) {
+		// YAML dates are decoded as UTC times
+		return t.Format(time.DateOnly)
+	}
+	return t.Format(time.RFC3339Nano)
+}
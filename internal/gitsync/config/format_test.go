@@ -0,0 +1,329 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/envvar"
+)
+
+const goodConfigYaml = `
+repositories:
+  otk-github:
+    sshCredentials:
+      useAgent: true
+    url: ssh://github.com:jpallari/otk.git
+    inMemory: true
+  keruu-github:
+    httpCredentials:
+      username: testuser
+    url: https://github.com/jpallari/keruu.git
+  yahe-github:
+    localPath: ${HOME}/git/yahe.git
+    url: https://github.com/jpallari/yahe.git
+  otk-gitlab:
+    authMethod: ssh
+    sshCredentials:
+      keyPath: ./gitlab/ssh-key.ed25519
+      keyPassword: ${GITLAB_SSH_KEY_PASSWORD}
+    url: ssh://gitlab.com:${GITLAB_USERNAME}/otk.git
+    retry:
+      maxAttempts: 3
+      backoff: linear
+      minDelay: 2s
+      maxDelay: 10s
+      jitter: 500ms
+  keruu-gitlab:
+    authMethod: http-token
+    url: https://gitlab.com/${GITLAB_USERNAME}/keruu.git
+  keruu-ssh:
+    sshCredentials:
+      keyPath: ${HOME}/.ssh/ssh-key.ed25519
+      ignoreHostKey: true
+    url: ssh://192.168.100.69/srv/git/keruu.git
+  yahe-gitlab:
+    url: https://gitlab.com/${GITLAB_USERNAME}/yahe.git
+concurrency: 4
+stateDir: ${HOME}/.local/state/gitsync
+server:
+  address: ":8080"
+  metrics: true
+  livenessDeadline: 30m
+  statusHistory: 20
+tracing:
+  endpoint: http://localhost:4318
+  headers:
+    Authorization: Bearer ${TRACING_TOKEN}
+mappings:
+  - source: otk-github
+    targets: [otk-gitlab]
+    interval: 60m
+    branches:
+      - spec: main
+    tags:
+      - spec: v.*
+        useRegex: true
+    refMappings:
+      - refs: branches
+        match: main
+        addPrefix: upstream/
+      - refs: tags
+        match: /^v(.*)$/
+        replace: vendor/acme/v$1
+  - source: keruu-github
+    targets: [keruu-gitlab, keruu-ssh]
+    interval: 6h
+    pollInterval: 30s
+    branches:
+      - spec: main.*
+        useRegex: true
+    tags: []
+    refs:
+      - refs/notes/commits
+      - /^refs/pull/[0-9]+/head$/
+    prune: true
+  - source: yahe-github
+    targets: [yahe-gitlab]
+    interval: 48h
+    branches:
+      - spec: main
+    tags:
+      - spec: release-.*
+        useRegex: true
+    updatePolicy: fast-forward-only
+  - id: yahe-nightly
+    source: yahe-github
+    targets: [yahe-gitlab]
+    schedule: "0 2 * * mon-fri"
+    timeZone: UTC
+    jitter: 5m
+    windows:
+      - days: [mon-fri]
+        start: "01:00"
+        end: "05:00"
+    blackouts:
+      - from: 2026-12-20
+        until: 2027-01-06
+    branches:
+      - spec: develop
+`
+
+const goodCredentialsYaml = `
+keruu-github:
+  httpCredentials:
+    password: testuser_password
+  webhookSecret: webhook_secret
+keruu-gitlab:
+  httpToken: http_token
+keruu-ssh:
+  sshCredentials:
+    keyPassword: ssh_key_password
+yahe-gitlab:
+  httpCredentials:
+    username: http_username
+    password: http_password
+`
+
+const goodConfigToml = `
+concurrency = 4
+stateDir = "${HOME}/.local/state/gitsync"
+
+[server]
+address = ":8080"
+metrics = true
+livenessDeadline = "30m"
+statusHistory = 20
+
+[tracing]
+endpoint = "http://localhost:4318"
+headers = { Authorization = "Bearer ${TRACING_TOKEN}" }
+
+[repositories.otk-github]
+sshCredentials = { useAgent = true }
+url = "ssh://github.com:jpallari/otk.git"
+inMemory = true
+
+[repositories.keruu-github]
+httpCredentials = { username = "testuser" }
+url = "https://github.com/jpallari/keruu.git"
+
+[repositories.yahe-github]
+localPath = "${HOME}/git/yahe.git"
+url = "https://github.com/jpallari/yahe.git"
+
+[repositories.otk-gitlab]
+authMethod = "ssh"
+sshCredentials = { keyPath = "./gitlab/ssh-key.ed25519", keyPassword = "${GITLAB_SSH_KEY_PASSWORD}" }
+url = "ssh://gitlab.com:${GITLAB_USERNAME}/otk.git"
+retry = { maxAttempts = 3, backoff = "linear", minDelay = "2s", maxDelay = "10s", jitter = "500ms" }
+
+[repositories.keruu-gitlab]
+authMethod = "http-token"
+url = "https://gitlab.com/${GITLAB_USERNAME}/keruu.git"
+
+[repositories.keruu-ssh]
+sshCredentials = { keyPath = "${HOME}/.ssh/ssh-key.ed25519", ignoreHostKey = true }
+url = "ssh://192.168.100.69/srv/git/keruu.git"
+
+[repositories.yahe-gitlab]
+url = "https://gitlab.com/${GITLAB_USERNAME}/yahe.git"
+
+[[mappings]]
+source = "otk-github"
+targets = ["otk-gitlab"]
+interval = "60m"
+branches = [{ spec = "main" }]
+tags = [{ spec = "v.*", useRegex = true }]
+refMappings = [
+  { refs = "branches", match = "main", addPrefix = "upstream/" },
+  { refs = "tags", match = "/^v(.*)$/", replace = "vendor/acme/v$1" },
+]
+
+[[mappings]]
+source = "keruu-github"
+targets = ["keruu-gitlab", "keruu-ssh"]
+interval = "6h"
+pollInterval = "30s"
+branches = [{ spec = "main.*", useRegex = true }]
+tags = []
+refs = ["refs/notes/commits", "/^refs/pull/[0-9]+/head$/"]
+prune = true
+
+[[mappings]]
+source = "yahe-github"
+targets = ["yahe-gitlab"]
+interval = "48h"
+branches = [{ spec = "main" }]
+tags = [{ spec = "release-.*", useRegex = true }]
+updatePolicy = "fast-forward-only"
+
+[[mappings]]
+id = "yahe-nightly"
+source = "yahe-github"
+targets = ["yahe-gitlab"]
+schedule = "0 2 * * mon-fri"
+timeZone = "UTC"
+jitter = "5m"
+windows = [{ days = ["mon-fri"], start = 01:00:00, end = 05:00:00 }]
+blackouts = [{ from = 2026-12-20, until = 2027-01-06 }]
+branches = [{ spec = "develop" }]
+`
+
+const goodCredentialsToml = `
+[keruu-github]
+httpCredentials = { password = "testuser_password" }
+webhookSecret = "webhook_secret"
+
+[keruu-gitlab]
+httpToken = "http_token"
+
+[keruu-ssh]
+sshCredentials = { keyPassword = "ssh_key_password" }
+
+[yahe-gitlab]
+httpCredentials = { username = "http_username", password = "http_password" }
+`
+
+func TestParseFormats(t *testing.T) {
+	var envVars envvar.Vars
+	envVars.FromMap(envVarsMap)
+
+	tests := []struct {
+		name        string
+		format      Format
+		config      string
+		credentials string
+	}{
+		{"yaml", FormatYAML, goodConfigYaml, goodCredentialsYaml},
+		{"toml", FormatTOML, goodConfigToml, goodCredentialsToml},
+		{"json", FormatJSON, goodConfigJson, goodCredentialsJson},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var conf Config
+			err := conf.ParseFormat(
+				envVars,
+				nil,
+				bytes.NewBufferString(test.config),
+				test.format,
+				bytes.NewBufferString(test.credentials),
+				test.format,
+			)
+			require.NoError(t, err, "config parse")
+			assert.Equal(t, goodConfig, conf)
+		})
+	}
+}
+
+func TestParseJsonWithComments(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	var conf Config
+	var envVars envvar.Vars
+	configStream := bytes.NewBufferString(`{
+  // Single target
+  "path": ".",
+  "targets": {
+    "target": {
+      "url": "https://gitlab.com/jpallari/otk.git", /* trailing comma */
+      "branches": [ "main", ],
+    },
+  },
+}`)
+
+	err := conf.Parse(envVars, nil, configStream, nil)
+	require.NoError(err)
+	require.Contains(conf.Repositories, "target")
+	assert.Equal("https://gitlab.com/jpallari/otk.git", conf.Repositories["target"].URL)
+}
+
+func TestParseFormatsSameErrors(t *testing.T) {
+	var envVars envvar.Vars
+	configs := map[Format]string{
+		FormatJSON: `{
+  "repositories": { "source": { "url": "" } },
+  "mappings": [ { "source": "source", "targets": [ "missing" ] } ]
+}`,
+		FormatYAML: `
+repositories:
+  source:
+    url: ""
+mappings:
+  - source: source
+    targets: [missing]
+`,
+		FormatTOML: `
+[repositories.source]
+url = ""
+
+[[mappings]]
+source = "source"
+targets = ["missing"]
+`,
+	}
+
+	errs := make(map[Format]string, len(configs))
+	for format, config := range configs {
+		var conf Config
+		err := conf.ParseFormat(envVars, nil, bytes.NewBufferString(config), format, nil, format)
+		require.Error(t, err, format.String())
+		errs[format] = err.Error()
+	}
+	assert.Equal(t, errs[FormatJSON], errs[FormatYAML])
+	assert.Equal(t, errs[FormatJSON], errs[FormatTOML])
+}
+
+func TestFormat(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(FormatYAML, FormatFromPath("/etc/gitsync/config.yml"))
+	assert.Equal(FormatTOML, FormatFromPath("config.TOML"))
+	assert.Equal(FormatJSON, FormatFromPath("config.jsonc"))
+	assert.Equal(FormatUndefined, FormatFromPath("config"))
+
+	var f Format
+	assert.NoError(f.Set("yaml"))
+	assert.Equal(FormatYAML, f)
+	assert.Error(f.Set("xml"))
+}
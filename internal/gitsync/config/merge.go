@@ -0,0 +1,215 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/go-git/go-billy/v5"
+	"go.lepovirta.org/otk/internal/envvar"
+	"go.lepovirta.org/otk/internal/validation"
+)
+
+const (
+	optionConcurrency = "concurrency"
+	optionStateDir    = "stateDir"
+	optionServer      = "server"
+	optionTracing     = "tracing"
+)
+
+// File is a config file to parse.
+type File struct {
+	// Path is used for detecting the format, and for naming
+	// the file in the error messages.
+	Path   string
+	Reader io.Reader
+
+	// Format of the file. Detected from the path when undefined.
+	Format Format
+}
+
+func (f *File) format() Format {
+	if f.Format != FormatUndefined {
+		return f.Format
+	}
+	return FormatFromPath(f.Path)
+}
+
+// origins records the files where the parts of a merged config came from,
+// so that the validation errors can name the files.
+// Nil origins are valid, and they are used when the config is not read from files.
+type origins struct {
+	options      map[string]string
+	repositories map[string]string
+	profiles     map[string]string
+	mappings     []mappingOrigin
+}
+
+type mappingOrigin struct {
+	file  string
+	index int
+}
+
+func fileV(v *validation.V, file string) *validation.V {
+	if file == "" {
+		return v
+	}
+	return v.Sub(file)
+}
+
+func (o *origins) optionV(v *validation.V, option string) *validation.V {
+	if o == nil {
+		return v
+	}
+	return fileV(v, o.options[option])
+}
+
+func (o *origins) repositoryV(v *validation.V, repoId string) *validation.V {
+	if o == nil {
+		return v.Sub("repositories").Sub(repoId)
+	}
+	return fileV(v, o.repositories[repoId]).Sub("repositories").Sub(repoId)
+}
+
+func (o *origins) profileV(v *validation.V, profileId string) *validation.V {
+	if o == nil {
+		return v.Sub("profiles").Sub(profileId)
+	}
+	return fileV(v, o.profiles[profileId]).Sub("profiles").Sub(profileId)
+}
+
+func (o *origins) mappingV(v *validation.V, index int) *validation.V {
+	if o == nil || index >= len(o.mappings) {
+		return v.Sub("mappings").IndexedSub(index)
+	}
+	origin := o.mappings[index]
+	return fileV(v, origin.file).Sub("mappings").IndexedSub(origin.index)
+}
+
+// ParseFiles parses the config from one or more files. The repositories
+// and the mappings from all of the files are merged in the given order.
+// The options such as `concurrency` and `server` can only be specified once.
+// The simple config format is only supported when there's a single file.
+// The secret files referenced in the config are read from the given file system.
+// The errors name the files they were found from.
+func (cfg *Config) ParseFiles(
+	envVars envvar.Vars,
+	fs billy.Filesystem,
+	files []File,
+	credentials *File,
+) error {
+	var credentialsReader io.Reader
+	if credentials != nil {
+		credentialsJSON, err := ToJSON(credentials.Reader, credentials.format())
+		if err != nil {
+			return fmt.Errorf("failed to parse credentials: %w", err)
+		}
+		credentialsReader = bytes.NewReader(credentialsJSON)
+	}
+
+	var merged Config
+	merged.origins = &origins{
+		options:      make(map[string]string, 4),
+		repositories: make(map[string]string),
+		profiles:     make(map[string]string),
+	}
+	errs := make([]error, 0, len(files))
+	for _, file := range files {
+		var temp rawConfig
+		b, err := ToJSON(file.Reader, file.format())
+		if err == nil {
+			err = json.NewDecoder(bytes.NewReader(b)).Decode(&temp)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse config %s: %w", file.Path, err)
+		}
+		if len(files) == 1 && temp.isSingle() {
+			return cfg.parseSingle(envVars, fs, &temp, file.Path, credentialsReader)
+		}
+		if temp.Path != "" || len(temp.Targets) > 0 {
+			return fmt.Errorf("config %s: simple config can't be combined with other config files", file.Path)
+		}
+		errs = append(errs, merged.merge(&temp.Config, file.Path)...)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	if err := merged.parse(envVars, fs, credentialsReader); err != nil {
+		return err
+	}
+	*cfg = merged
+	return nil
+}
+
+// merge adds the repositories, the profiles, the mappings, and the options
+// from the config to the merged config. Errors are returned for the repositories,
+// the profiles, and the options that have already been specified in another file.
+func (cfg *Config) merge(other *Config, file string) (errs []error) {
+	if cfg.Repositories == nil {
+		cfg.Repositories = make(map[string]Repository, len(other.Repositories))
+	}
+	for _, repoId := range slices.Sorted(maps.Keys(other.Repositories)) {
+		if otherFile, ok := cfg.origins.repositories[repoId]; ok {
+			errs = append(errs, fmt.Errorf(
+				"repository %s is specified in both %s and %s",
+				repoId, otherFile, file,
+			))
+			continue
+		}
+		cfg.Repositories[repoId] = other.Repositories[repoId]
+		cfg.origins.repositories[repoId] = file
+	}
+
+	if cfg.Profiles == nil {
+		cfg.Profiles = make(map[string]Profile, len(other.Profiles))
+	}
+	for _, profileId := range slices.Sorted(maps.Keys(other.Profiles)) {
+		if otherFile, ok := cfg.origins.profiles[profileId]; ok {
+			errs = append(errs, fmt.Errorf(
+				"profile %s is specified in both %s and %s",
+				profileId, otherFile, file,
+			))
+			continue
+		}
+		cfg.Profiles[profileId] = other.Profiles[profileId]
+		cfg.origins.profiles[profileId] = file
+	}
+
+	for i, mapping := range other.Mappings {
+		cfg.Mappings = append(cfg.Mappings, mapping)
+		cfg.origins.mappings = append(cfg.origins.mappings, mappingOrigin{file: file, index: i})
+	}
+
+	errs = appendErr(errs, mergeOption(cfg.origins, optionConcurrency, file, &cfg.Concurrency, other.Concurrency))
+	errs = appendErr(errs, mergeOption(cfg.origins, optionStateDir, file, &cfg.StateDir, other.StateDir))
+	errs = appendErr(errs, mergeOption(cfg.origins, optionServer, file, &cfg.Server, other.Server))
+	errs = appendErr(errs, mergeOption(cfg.origins, optionTracing, file, &cfg.Tracing, other.Tracing))
+	return
+}
+
+// mergeOption sets the option when it's specified in the file.
+// An error is returned when it has already been set from another file.
+func mergeOption[T any](o *origins, option, file string, dst *T, value T) error {
+	if reflect.ValueOf(&value).Elem().IsZero() {
+		return nil
+	}
+	if otherFile, ok := o.options[option]; ok {
+		return fmt.Errorf("%s is specified in both %s and %s", option, otherFile, file)
+	}
+	*dst = value
+	o.options[option] = file
+	return nil
+}
+
+func appendErr(errs []error, err error) []error {
+	if err != nil {
+		return append(errs, err)
+	}
+	return errs
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/envvar"
+)
+
+func configFile(path, content string) File {
+	return File{Path: path, Reader: bytes.NewBufferString(content)}
+}
+
+func TestParseFilesMerged(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	var conf Config
+	var envVars envvar.Vars
+
+	err := conf.ParseFiles(envVars, nil, []File{
+		configFile("otk.json", `{
+  "concurrency": 2,
+  "repositories": {
+    "otk-github": { "url": "https://github.com/jpallari/otk.git" },
+    "otk-gitlab": { "url": "https://gitlab.com/jpallari/otk.git" }
+  },
+  "mappings": [ { "source": "otk-github", "targets": [ "otk-gitlab" ], "branches": [ "main" ] } ]
+}`),
+		configFile("keruu.yaml", `
+repositories:
+  keruu-github:
+    url: https://github.com/jpallari/keruu.git
+mappings:
+  - source: keruu-github
+    targets: [otk-gitlab]
+    branches: [main]
+`),
+	}, &File{Path: "credentials.toml", Reader: bytes.NewBufferString(`
+[otk-gitlab]
+httpToken = "token"
+`)})
+	require.NoError(err)
+
+	assert.Equal(2, conf.Concurrency)
+	assert.Len(conf.Repositories, 3)
+	assert.Equal("token", conf.Repositories["otk-gitlab"].Credentials.HttpToken)
+	require.Len(conf.Mappings, 2)
+	assert.Equal("otk-github", conf.Mappings[0].Source)
+	assert.Equal("keruu-github", conf.Mappings[1].Source)
+}
+
+func TestParseFilesDuplicates(t *testing.T) {
+	assert := assert.New(t)
+	var conf Config
+	var envVars envvar.Vars
+
+	err := conf.ParseFiles(envVars, nil, []File{
+		configFile("a.json", `{
+  "concurrency": 2,
+  "profiles": { "bot": {} },
+  "repositories": { "otk": { "url": "https://github.com/jpallari/otk.git" } }
+}`),
+		configFile("b.json", `{
+  "concurrency": 3,
+  "profiles": { "bot": {} },
+  "repositories": { "otk": { "url": "https://gitlab.com/jpallari/otk.git" } }
+}`),
+	}, nil)
+
+	assert.ErrorContains(err, "repository otk is specified in both a.json and b.json")
+	assert.ErrorContains(err, "profile bot is specified in both a.json and b.json")
+	assert.ErrorContains(err, "concurrency is specified in both a.json and b.json")
+}
+
+func TestParseFilesValidationNamesFile(t *testing.T) {
+	assert := assert.New(t)
+	var conf Config
+	var envVars envvar.Vars
+
+	err := conf.ParseFiles(envVars, nil, []File{
+		configFile("repos.json", `{
+  "repositories": {
+    "source": { "url": "https://github.com/jpallari/otk.git" },
+    "target": { "url": "" }
+  }
+}`),
+		configFile("mappings.json", `{
+  "concurrency": -1,
+  "mappings": [
+    { "source": "source", "targets": [ "target" ], "branches": [ "main" ] },
+    { "source": "missing", "targets": [ "target" ], "branches": [ "main" ] }
+  ]
+}`),
+	}, nil)
+
+	require.Error(t, err)
+	report := err.Error()
+	assert.Regexp(`(?s)repos\.json.*repositories.*target`, report)
+	assert.Regexp(`(?s)mappings\.json.*concurrency`, report)
+	assert.Regexp(`(?s)mappings\.json.*mappings.*1.*missing`, report)
+}
+
+func TestParseFilesSingleFileValidationNamesFile(t *testing.T) {
+	assert := assert.New(t)
+	var conf Config
+	var envVars envvar.Vars
+
+	err := conf.ParseFiles(envVars, nil, []File{
+		configFile("gitsync.json", `{
+  "repositories": {
+    "source": { "url": "https://github.com/jpallari/otk.git" },
+    "target": { "url": "" }
+  },
+  "mappings": [ { "source": "missing", "targets": [ "target" ], "branches": [ "main" ] } ]
+}`),
+	}, nil)
+
+	require.Error(t, err)
+	report := err.Error()
+	assert.Regexp(`(?s)gitsync\.json.*repositories.*target`, report)
+	assert.Regexp(`(?s)gitsync\.json.*mappings.*0.*missing`, report)
+}
+
+func TestParseFilesSingleSimpleConfigValidationNamesFile(t *testing.T) {
+	var conf Config
+	var envVars envvar.Vars
+
+	err := conf.ParseFiles(envVars, nil, []File{
+		configFile("simple.yaml", `
+path: .
+targets:
+  target:
+    url: https://gitlab.com/jpallari/otk.git
+`),
+	}, nil)
+
+	require.Error(t, err)
+	assert.Regexp(t, `(?s)simple\.yaml.*targets.*target.*branches`, err.Error())
+}
+
+func TestParseFilesSimpleConfig(t *testing.T) {
+	assert := assert.New(t)
+	var conf Config
+	var envVars envvar.Vars
+
+	err := conf.ParseFiles(envVars, nil, []File{
+		configFile("simple.json", `{
+  "path": ".",
+  "targets": { "target": { "url": "https://gitlab.com/jpallari/otk.git", "branches": [ "main" ] } }
+}`),
+		configFile("standard.json", `{}`),
+	}, nil)
+
+	assert.ErrorContains(err, "simple config can't be combined with other config files")
+}
@@ -0,0 +1,209 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"go.lepovirta.org/otk/internal/validation"
+)
+
+// Profile contains repository settings that are shared by many repositories.
+// The repositories inherit the settings of the profiles they reference.
+type Profile struct {
+	// Credentials specifies the authentication credentials used
+	// when connecting to the Git repositories.
+	Credentials
+
+	// When InMemory is set to `true`, the Git repositories are downloaded
+	// to memory rather than the file system.
+	InMemory *bool `json:"inMemory"`
+
+	// Retry specifies how failed fetches and pushes are retried.
+	Retry Retry `json:"retry"`
+}
+
+// ProfileNames contains the names of the profiles referenced by a repository.
+// Either a single name or a list of names is accepted.
+type ProfileNames []string
+
+func (p *ProfileNames) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		if name == "" {
+			*p = nil
+		} else {
+			*p = ProfileNames{name}
+		}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return fmt.Errorf("expected a profile name or a list of profile names")
+	}
+	*p = names
+	return nil
+}
+
+func (p *Profile) merge(other *Profile) {
+	p.Credentials.dropOtherKinds(&other.Credentials)
+	p.Credentials.merge(&other.Credentials)
+	overrideBool(&p.InMemory, other.InMemory)
+	p.Retry.merge(&other.Retry)
+}
+
+// credentialKinds is a set of kinds of credentials.
+type credentialKinds uint8
+
+const (
+	credentialKindHttpToken credentialKinds = 1 << iota
+	credentialKindHttpCredentials
+	credentialKindSsh
+	credentialKindCredentialHelper
+	credentialKindExec
+	credentialKindGitHubApp
+)
+
+// kinds returns the kinds of credentials that are set.
+func (c *Credentials) kinds() credentialKinds {
+	var kinds credentialKinds
+	if c.HttpToken != "" || c.HttpTokenFile != "" {
+		kinds |= credentialKindHttpToken
+	}
+	if c.HttpCredentials != (HttpCredentials{}) {
+		kinds |= credentialKindHttpCredentials
+	}
+	ssh := &c.SshCredentials
+	if ssh.UseAgent != nil || ssh.KeyPath != "" || ssh.KeyPassword != "" || ssh.KeyPasswordFile != "" {
+		kinds |= credentialKindSsh
+	}
+	if c.CredentialHelper.enabled() {
+		kinds |= credentialKindCredentialHelper
+	}
+	if c.Exec.enabled() {
+		kinds |= credentialKindExec
+	}
+	if c.GitHubApp.enabled() {
+		kinds |= credentialKindGitHubApp
+	}
+	return kinds
+}
+
+// credentialKinds returns the kinds of credentials used by the auth method.
+func (m AuthMethod) credentialKinds() credentialKinds {
+	switch m {
+	case AuthMethodHttpToken:
+		return credentialKindHttpToken
+	case AuthMethodHttpCredentials:
+		return credentialKindHttpCredentials
+	case AuthMethodSshAgent, AuthMethodSshKey:
+		return credentialKindSsh
+	case AuthMethodCredentialHelper:
+		return credentialKindCredentialHelper
+	case AuthMethodExec:
+		return credentialKindExec
+	case AuthMethodGitHubApp:
+		return credentialKindGitHubApp
+	}
+	return 0
+}
+
+// dropOtherKinds clears the credentials, and the auth method, of the kinds
+// that are not set in the other credentials. Nothing is cleared when
+// the other credentials don't set any kind of credentials.
+func (c *Credentials) dropOtherKinds(other *Credentials) {
+	kinds := other.kinds()
+	if kinds == 0 {
+		return
+	}
+	if kinds&credentialKindHttpToken == 0 {
+		c.HttpToken, c.HttpTokenFile = "", ""
+	}
+	if kinds&credentialKindHttpCredentials == 0 {
+		c.HttpCredentials = HttpCredentials{}
+	}
+	if kinds&credentialKindSsh == 0 {
+		c.SshCredentials.UseAgent = nil
+		c.SshCredentials.KeyPath = ""
+		c.SshCredentials.KeyPassword = ""
+		c.SshCredentials.KeyPasswordFile = ""
+	}
+	if kinds&credentialKindCredentialHelper == 0 {
+		c.CredentialHelper = CredentialHelper{}
+	}
+	if kinds&credentialKindExec == 0 {
+		c.Exec = ExecCredentials{}
+	}
+	if kinds&credentialKindGitHubApp == 0 {
+		c.GitHubApp = GitHubApp{}
+	}
+	if c.TargetAuthMethod.credentialKinds()&kinds == 0 {
+		c.TargetAuthMethod = AuthMethodUndefined
+	}
+}
+
+func (r *Retry) merge(other *Retry) {
+	overrideInt(&r.MaxAttempts, other.MaxAttempts)
+	if other.Backoff != BackoffUndefined {
+		r.Backoff = other.Backoff
+	}
+	overrideDuration(&r.MinDelay, other.MinDelay)
+	overrideDuration(&r.MaxDelay, other.MaxDelay)
+	overrideDuration(&r.Jitter, other.Jitter)
+}
+
+// applyProfiles replaces the repository settings with the settings
+// inherited from the profiles. The later profiles override the earlier ones,
+// and the repository's own settings override all of the profiles.
+// Setting a kind of credentials replaces the inherited credentials of
+// other kinds, e.g. SSH credentials replace an inherited HTTP token.
+// Unknown profiles are skipped, and they are reported during validation.
+func (r *Repository) applyProfiles(profiles map[string]Profile) {
+	if len(r.Profile) == 0 {
+		return
+	}
+	var inherited Profile
+	for _, name := range r.Profile {
+		if profile, ok := profiles[name]; ok {
+			inherited.merge(&profile)
+		}
+	}
+	inherited.merge(&Profile{
+		Credentials: r.Credentials,
+		InMemory:    r.InMemory,
+		Retry:       r.Retry,
+	})
+	r.Credentials = inherited.Credentials
+	r.InMemory = inherited.InMemory
+	r.Retry = inherited.Retry
+}
+
+func (cfg *Config) applyProfiles() {
+	for repoId, repo := range cfg.Repositories {
+		repo.applyProfiles(cfg.Profiles)
+		cfg.Repositories[repoId] = repo
+	}
+}
+
+func (p *Profile) validate(v *validation.V) {
+	p.Retry.validate(v.Sub("retry"))
+	switch p.TargetAuthMethod {
+	case AuthMethodUndefined, AuthMethodNone, AuthMethodHttpToken,
+		AuthMethodHttpCredentials, AuthMethodSshAgent, AuthMethodSshKey,
+		AuthMethodCredentialHelper, AuthMethodExec, AuthMethodGitHubApp:
+	default:
+		v.FailF("authMethod", "unexpected auth method %s", p.TargetAuthMethod)
+	}
+}
+
+func (r *Repository) validateProfiles(v *validation.V, profiles map[string]Profile) {
+	profileV := v.Sub("profile")
+	for i, name := range r.Profile {
+		if _, ok := profiles[name]; !ok {
+			profileV.IndexFailF(i, "profile %s is not specified", name)
+		}
+		if slices.Index(r.Profile, name) != i {
+			profileV.IndexFailF(i, "profile %s is referenced more than once", name)
+		}
+	}
+}
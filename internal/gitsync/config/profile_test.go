@@ -0,0 +1,180 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/duration"
+	"go.lepovirta.org/otk/internal/envvar"
+)
+
+const profilesConfigJson = `{
+  "profiles": {
+    "github-bot": {
+      "authMethod": "ssh",
+      "sshCredentials": { "keyPath": "/keys/github" },
+      "retry": { "maxAttempts": 3, "minDelay": "5s" }
+    },
+    "in-memory": { "inMemory": true, "retry": { "maxAttempts": 5 } },
+    "gitlab-bot": { "authMethod": "http-token" }
+  },
+  "repositories": {
+    "otk-github": {
+      "url": "git@github.com:jpallari/otk.git",
+      "profile": "github-bot"
+    },
+    "keruu-github": {
+      "url": "git@github.com:jpallari/keruu.git",
+      "profile": [ "github-bot", "in-memory" ],
+      "sshCredentials": { "keyPath": "/keys/keruu" }
+    },
+    "otk-gitlab": {
+      "url": "https://gitlab.com/jpallari/otk.git",
+      "profile": "gitlab-bot"
+    }
+  },
+  "mappings": [
+    { "source": "otk-github", "targets": [ "otk-gitlab" ], "branches": [ "main" ] },
+    { "source": "keruu-github", "targets": [ "otk-gitlab" ], "branches": [ "main" ] }
+  ]
+}`
+
+const profilesCredentialsJson = `{
+  "gitlab-bot": { "httpToken": "profile_token" },
+  "github-bot": { "sshCredentials": { "keyPassword": "key_password" } }
+}`
+
+func TestParseProfiles(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	var conf Config
+	var envVars envvar.Vars
+
+	err := conf.Parse(
+		envVars,
+		nil,
+		bytes.NewBufferString(profilesConfigJson),
+		bytes.NewBufferString(profilesCredentialsJson),
+	)
+	require.NoError(err)
+
+	otk := conf.Repositories["otk-github"]
+	assert.Equal(AuthMethodSshKey, otk.AuthMethod())
+	assert.Equal("/keys/github", otk.SshCredentials.KeyPath)
+	assert.Equal("key_password", otk.SshCredentials.KeyPassword)
+	assert.Equal(Retry{MaxAttempts: 3, MinDelay: duration.D{Duration: 5 * time.Second}}, otk.Retry)
+	assert.False(otk.IsInMemory())
+
+	keruu := conf.Repositories["keruu-github"]
+	assert.Equal("/keys/keruu", keruu.SshCredentials.KeyPath, "repository overrides the profile")
+	assert.Equal("key_password", keruu.SshCredentials.KeyPassword)
+	assert.True(keruu.IsInMemory())
+	assert.Equal(5, keruu.Retry.MaxAttempts, "later profile overrides the earlier one")
+	assert.Equal(5*time.Second, keruu.Retry.MinDelay.Duration)
+
+	gitlab := conf.Repositories["otk-gitlab"]
+	assert.Equal(AuthMethodHttpToken, gitlab.AuthMethod())
+	assert.Equal("profile_token", gitlab.HttpToken)
+}
+
+func TestParseProfilesOverrides(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	var conf Config
+	var envVars envvar.Vars
+
+	err := conf.Parse(envVars, nil, bytes.NewBufferString(`{
+  "profiles": {
+    "gitlab-bot": {
+      "authMethod": "http-token",
+      "httpToken": "profile_token",
+      "inMemory": true,
+      "sshCredentials": { "ignoreHostKey": true }
+    }
+  },
+  "repositories": {
+    "source": { "url": "https://github.com/jpallari/otk.git" },
+    "ssh-target": {
+      "url": "ssh://git@gitlab.com/jpallari/otk.git",
+      "profile": "gitlab-bot",
+      "sshCredentials": { "keyPath": "/keys/gitlab", "ignoreHostKey": false }
+    },
+    "disk-target": {
+      "url": "https://gitlab.com/jpallari/keruu.git",
+      "profile": "gitlab-bot",
+      "inMemory": false
+    }
+  },
+  "mappings": [
+    { "source": "source", "targets": [ "ssh-target", "disk-target" ], "branches": [ "main" ] }
+  ]
+}`), nil)
+	require.NoError(err)
+
+	sshTarget := conf.Repositories["ssh-target"]
+	assert.Equal(AuthMethodSshKey, sshTarget.AuthMethod(), "SSH credentials replace the inherited HTTP token")
+	assert.Empty(sshTarget.HttpToken)
+	assert.Equal("/keys/gitlab", sshTarget.SshCredentials.KeyPath)
+	assert.False(sshTarget.SshCredentials.IgnoresHostKey(), "false overrides the inherited true")
+	assert.True(sshTarget.IsInMemory())
+
+	diskTarget := conf.Repositories["disk-target"]
+	assert.Equal(AuthMethodHttpToken, diskTarget.AuthMethod())
+	assert.Equal("profile_token", diskTarget.HttpToken)
+	assert.False(diskTarget.IsInMemory(), "false overrides the inherited true")
+}
+
+func TestParseProfilesInvalid(t *testing.T) {
+	assert := assert.New(t)
+	var conf Config
+	var envVars envvar.Vars
+
+	err := conf.Parse(envVars, nil, bytes.NewBufferString(`{
+  "profiles": {
+    "gitlab-bot": { "authMethod": "http-token", "retry": { "maxAttempts": -1 } }
+  },
+  "repositories": {
+    "source": { "url": "https://github.com/jpallari/otk.git", "profile": "missing" },
+    "target": { "url": "https://gitlab.com/jpallari/otk.git", "profile": "gitlab-bot" }
+  },
+  "mappings": [ { "source": "source", "targets": [ "target" ], "branches": [ "main" ] } ]
+}`), nil)
+
+	assert.ErrorContains(err, "profile missing is not specified")
+	assert.Regexp(`(?s)profiles:\s+gitlab-bot:\s+retry:\s+maxAttempts: must not be negative`, err.Error())
+	assert.Regexp(`(?s)target:.*httpToken: expected HTTP token to be set`, err.Error())
+}
+
+func TestParseProfilesSingleConfig(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	var conf Config
+	var envVars envvar.Vars
+
+	err := conf.Parse(envVars, nil, bytes.NewBufferString(`{
+  "path": ".",
+  "profiles": { "gitlab-bot": { "httpToken": "token" } },
+  "targets": {
+    "target": { "url": "https://gitlab.com/jpallari/otk.git", "profile": "gitlab-bot", "branches": [ "main" ] }
+  }
+}`), nil)
+	require.NoError(err)
+
+	assert.Equal("token", conf.Repositories["target"].HttpToken)
+}
+
+func TestProfileNamesUnmarshal(t *testing.T) {
+	assert := assert.New(t)
+	var names ProfileNames
+
+	assert.NoError(names.UnmarshalJSON([]byte(`"github-bot"`)))
+	assert.Equal(ProfileNames{"github-bot"}, names)
+	assert.NoError(names.UnmarshalJSON([]byte(`["github-bot", "in-memory"]`)))
+	assert.Equal(ProfileNames{"github-bot", "in-memory"}, names)
+	assert.NoError(names.UnmarshalJSON([]byte(`""`)))
+	assert.Empty(names)
+	assert.Error(names.UnmarshalJSON([]byte(`1`)))
+}
@@ -0,0 +1,329 @@
+package config
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.lepovirta.org/otk/internal/matcher"
+)
+
+const (
+	RefMappingAny      = ""
+	RefMappingBranches = "branches"
+	RefMappingTags     = "tags"
+)
+
+// RefMapping specifies how the name of a ref in the source repository
+// is rewritten for the target repository.
+type RefMapping struct {
+	// Refs specifies which kind of refs the mapping is applied to.
+	// Possible values: branches, tags. When left unset, the mapping
+	// is applied to both branches and tags.
+	Refs string `json:"refs"`
+
+	// Match determines which refs the mapping is applied to.
+	// When left unset, the mapping is applied to all refs.
+	Match matcher.M `json:"match"`
+
+	// Replace is the name the matching ref is renamed to.
+	// When Match is a regex, capture groups can be referenced with `$1`, `${name}`, etc.
+	Replace string `json:"replace"`
+
+	// StripPrefix is removed from the start of the ref name after
+	// Replace is applied.
+	StripPrefix string `json:"stripPrefix"`
+
+	// AddPrefix is added to the start of the ref name after
+	// StripPrefix is applied.
+	AddPrefix string `json:"addPrefix"`
+}
+
+func (rm *RefMapping) appliesTo(refs string, name string) bool {
+	if rm.Refs != RefMappingAny && rm.Refs != refs {
+		return false
+	}
+	return rm.Match.MatchString(name)
+}
+
+func (rm *RefMapping) apply(name string) string {
+	if rm.Replace != "" {
+		name = rm.Match.Replace(name, rm.Replace)
+	}
+	name = strings.TrimPrefix(name, rm.StripPrefix)
+	return rm.AddPrefix + name
+}
+
+// MapBranch returns the name of the branch in the target repository.
+func (ss *SyncSpec) MapBranch(branch string) string {
+	return ss.mapRef(RefMappingBranches, branch)
+}
+
+// MapTag returns the name of the tag in the target repository.
+func (ss *SyncSpec) MapTag(tag string) string {
+	return ss.mapRef(RefMappingTags, tag)
+}
+
+// mapRef applies the first ref mapping that matches the ref name.
+func (ss *SyncSpec) mapRef(refs string, name string) string {
+	for i := range ss.RefMappings {
+		if ss.RefMappings[i].appliesTo(refs, name) {
+			return ss.RefMappings[i].apply(name)
+		}
+	}
+	return name
+}
+
+// TargetNamespace describes the ref names that the ref mappings
+// of a sync spec can produce in the target repository.
+type TargetNamespace struct {
+	spec *SyncSpec
+
+	// templates contains the templates for the names produced by
+	// the regex replacements of each ref mapping. The template is nil when
+	// the ref mapping doesn't use a regex replacement or the replacement
+	// can't be reversed.
+	templates []*replacementTemplate
+}
+
+// TargetNamespace returns the namespace of the ref names produced
+// by the ref mappings in the target repository.
+func (ss *SyncSpec) TargetNamespace() *TargetNamespace {
+	ns := &TargetNamespace{
+		spec:      ss,
+		templates: make([]*replacementTemplate, len(ss.RefMappings)),
+	}
+	for i := range ss.RefMappings {
+		ns.templates[i] = ss.RefMappings[i].replacementTemplate()
+	}
+	return ns
+}
+
+// Contains reports whether the given ref name in the target repository
+// is produced from a source ref accepted by the selected function.
+// The source ref names are resolved by reversing the ref mappings.
+// Names produced by regex replacements that can't be reversed are
+// never contained in the namespace.
+func (ns *TargetNamespace) Contains(refs string, name string, selected func(string) bool) bool {
+	sources := []string{name}
+	for i := range ns.spec.RefMappings {
+		rm := &ns.spec.RefMappings[i]
+		if rm.Refs != RefMappingAny && rm.Refs != refs {
+			continue
+		}
+		for _, replaced := range rm.unprefix(name) {
+			switch {
+			case rm.Replace == "":
+				sources = append(sources, replaced)
+			case ns.templates[i] != nil:
+				if source, ok := ns.templates[i].reverse(replaced); ok {
+					sources = append(sources, source)
+				}
+			case !rm.Match.UsesRegex() && replaced == rm.Replace:
+				if rm.Match.IsEmpty() {
+					return true
+				}
+				sources = append(sources, rm.Match.String())
+			}
+		}
+	}
+	for _, source := range sources {
+		if selected(source) && ns.spec.mapRef(refs, source) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// unprefix returns the names the ref could have had before
+// the prefixes were stripped and added.
+func (rm *RefMapping) unprefix(name string) []string {
+	name, ok := strings.CutPrefix(name, rm.AddPrefix)
+	if !ok {
+		return nil
+	}
+	if rm.StripPrefix == "" {
+		return []string{name}
+	}
+	names := []string{rm.StripPrefix + name}
+	if !strings.HasPrefix(name, rm.StripPrefix) {
+		names = append(names, name)
+	}
+	return names
+}
+
+// replacementTemplate describes the names produced by a regex replacement
+// and how the source names are rebuilt from them.
+type replacementTemplate struct {
+	// pattern matches the names produced by the replacement.
+	// The capture groups of the pattern match the capture group
+	// references in the replacement.
+	pattern *regexp.Regexp
+
+	// refs contains the source capture group index for each
+	// capture group in the pattern.
+	refs []int
+
+	// source contains the parts of the source name. Each part is either
+	// a literal string or a reference to a source capture group.
+	source []templatePart
+}
+
+type templatePart struct {
+	literal string
+	group   int
+}
+
+// replacementTemplate returns a template for reversing the regex replacement.
+// Only regexes that match the whole name (i.e. start with `^` and end with `$`)
+// and consist of literals and capture groups are supported. Every capture group
+// must be referenced in the replacement, because otherwise the source name
+// can't be rebuilt. Returns nil when the replacement can't be reversed.
+func (rm *RefMapping) replacementTemplate() *replacementTemplate {
+	if !rm.Match.UsesRegex() || rm.Replace == "" {
+		return nil
+	}
+	spec := strings.TrimSuffix(strings.TrimPrefix(rm.Match.String(), "/"), "/")
+	if !strings.HasPrefix(spec, "^") || !strings.HasSuffix(spec, "$") {
+		return nil
+	}
+	re, err := syntax.Parse(spec, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	source, ok := sourceParts(re)
+	if !ok {
+		return nil
+	}
+	groups := map[string]captureGroup{}
+	collectCaptureGroups(re, groups)
+
+	t := &replacementTemplate{source: source}
+	var sb strings.Builder
+	sb.WriteString("^")
+	template := rm.Replace
+	for len(template) > 0 {
+		i := strings.IndexByte(template, '$')
+		if i < 0 {
+			sb.WriteString(regexp.QuoteMeta(template))
+			break
+		}
+		sb.WriteString(regexp.QuoteMeta(template[:i]))
+		template = template[i+1:]
+		var ref string
+		switch {
+		case strings.HasPrefix(template, "$"):
+			sb.WriteString(`\$`)
+			template = template[1:]
+			continue
+		case strings.HasPrefix(template, "{"):
+			end := strings.IndexByte(template, '}')
+			if end < 0 {
+				sb.WriteString(`\$`)
+				continue
+			}
+			ref, template = template[1:end], template[end+1:]
+		default:
+			end := strings.IndexFunc(template, func(r rune) bool {
+				return !(r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r))
+			})
+			if end < 0 {
+				end = len(template)
+			}
+			if end == 0 {
+				sb.WriteString(`\$`)
+				continue
+			}
+			ref, template = template[:end], template[end:]
+		}
+		// References to unknown groups are replaced with an empty string
+		if group, ok := groups[ref]; ok {
+			sb.WriteString("(" + group.pattern + ")")
+			t.refs = append(t.refs, group.index)
+		}
+	}
+	sb.WriteString("$")
+	for _, part := range t.source {
+		if part.group > 0 && !slices.Contains(t.refs, part.group) {
+			return nil
+		}
+	}
+	pattern, err := regexp.Compile(sb.String())
+	if err != nil || pattern.NumSubexp() != len(t.refs) {
+		return nil
+	}
+	t.pattern = pattern
+	return t
+}
+
+// reverse returns the source name the given name could have been
+// produced from. Returns false when the name doesn't match the template.
+func (t *replacementTemplate) reverse(name string) (string, bool) {
+	match := t.pattern.FindStringSubmatch(name)
+	if match == nil {
+		return "", false
+	}
+	values := make(map[int]string, len(t.refs))
+	for i, group := range t.refs {
+		if _, ok := values[group]; !ok {
+			values[group] = match[i+1]
+		}
+	}
+	var sb strings.Builder
+	for _, part := range t.source {
+		if part.group > 0 {
+			sb.WriteString(values[part.group])
+		} else {
+			sb.WriteString(part.literal)
+		}
+	}
+	return sb.String(), true
+}
+
+// sourceParts splits the regex to literals and top-level capture groups.
+// Returns false when the regex contains anything else than literals,
+// capture groups, and the start and end anchors.
+func sourceParts(re *syntax.Regexp) ([]templatePart, bool) {
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	var parts []templatePart
+	for _, sub := range subs {
+		switch sub.Op {
+		case syntax.OpBeginText, syntax.OpEndText, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpEmptyMatch:
+		case syntax.OpLiteral:
+			if sub.Flags&syntax.FoldCase != 0 {
+				return nil, false
+			}
+			parts = append(parts, templatePart{literal: string(sub.Rune)})
+		case syntax.OpCapture:
+			parts = append(parts, templatePart{group: sub.Cap})
+		default:
+			return nil, false
+		}
+	}
+	return parts, true
+}
+
+type captureGroup struct {
+	index   int
+	pattern string
+}
+
+// collectCaptureGroups collects the capture groups by their index and name.
+func collectCaptureGroups(re *syntax.Regexp, groups map[string]captureGroup) {
+	if re.Op == syntax.OpCapture {
+		group := captureGroup{index: re.Cap, pattern: re.Sub[0].String()}
+		groups[strconv.Itoa(re.Cap)] = group
+		if re.Name != "" {
+			groups[re.Name] = group
+		}
+	}
+	for _, sub := range re.Sub {
+		collectCaptureGroups(sub, groups)
+	}
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"go.lepovirta.org/otk/internal/envsubst"
+	"go.lepovirta.org/otk/internal/envvar"
+	"go.lepovirta.org/otk/internal/validation"
+)
+
+const (
+	// secretFilePrefix is the prefix of the variables that are replaced
+	// with the contents of a file e.g. `${file:/run/secrets/token}`.
+	secretFilePrefix = "file:"
+
+	// secretFileEnvVarSuffix is the suffix of the environment variables that
+	// contain the path to the file of the value e.g. `GITHUB_TOKEN_FILE`
+	// for `${GITHUB_TOKEN}`.
+	secretFileEnvVarSuffix = "_FILE"
+)
+
+var errNoFilesystem = errors.New("no file system available for reading files")
+
+// resolver replaces the variables used in the config with the values from
+// the environment variables and the files.
+type resolver struct {
+	envVars map[string]string
+	fs      billy.Filesystem
+}
+
+func newResolver(envVars envvar.Vars, fs billy.Filesystem) *resolver {
+	return &resolver{envVars: envVars.ToMap(), fs: fs}
+}
+
+func (r *resolver) replace(text string) (string, error) {
+	return envsubst.ReplaceFunc(text, r.lookup)
+}
+
+func (r *resolver) lookup(key string) (string, bool) {
+	if path, ok := strings.CutPrefix(key, secretFilePrefix); ok {
+		v, err := ReadSecretFile(r.fs, strings.TrimSpace(path))
+		return v, err == nil
+	}
+	if v, ok := r.envVars[key]; ok {
+		return v, true
+	}
+	if path, ok := r.envVars[key+secretFileEnvVarSuffix]; ok {
+		v, err := ReadSecretFile(r.fs, path)
+		return v, err == nil
+	}
+	return "", false
+}
+
+// ReadSecretFile reads a secret such as a token or a password from a file.
+// The trailing newlines are removed from the secret.
+func ReadSecretFile(fs billy.Filesystem, path string) (string, error) {
+	if fs == nil {
+		return "", errNoFilesystem
+	}
+	b, err := util.ReadFile(fs, path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// secretFile is a secret in the credentials that can be read from a file.
+type secretFile struct {
+	name  string
+	value *string
+	path  string
+}
+
+func (c *Credentials) secretFiles() []secretFile {
+	return []secretFile{
+		{name: "httpToken", value: &c.HttpToken, path: c.HttpTokenFile},
+		{name: "password", value: &c.HttpCredentials.Password, path: c.HttpCredentials.PasswordFile},
+		{name: "keyPassword", value: &c.SshCredentials.KeyPassword, path: c.SshCredentials.KeyPasswordFile},
+	}
+}
+
+// HasSecretFiles reports whether any of the secrets are read from files.
+func (c *Credentials) HasSecretFiles() bool {
+	return slices.ContainsFunc(c.secretFiles(), func(secret secretFile) bool {
+		return secret.path != ""
+	})
+}
+
+// ReadSecretFiles reads the secrets again from the files specified in
+// the credentials, so that the rotated secrets are picked up.
+func (c *Credentials) ReadSecretFiles(fs billy.Filesystem) error {
+	var errs []error
+	for _, secret := range c.secretFiles() {
+		if secret.path == "" {
+			continue
+		}
+		value, err := ReadSecretFile(fs, secret.path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read %s from file: %w", secret.name, err))
+			continue
+		}
+		*secret.value = value
+	}
+	return errors.Join(errs...)
+}
+
+// readSecretFiles reads the secrets from the files specified in the credentials.
+func (c *Credentials) readSecretFiles(v *validation.V, fs billy.Filesystem) {
+	readSecretField(v, fs, "httpToken", &c.HttpToken, c.HttpTokenFile)
+	readSecretField(
+		v.Sub("httpCredentials"), fs,
+		"password", &c.HttpCredentials.Password, c.HttpCredentials.PasswordFile,
+	)
+	readSecretField(
+		v.Sub("sshCredentials"), fs,
+		"keyPassword", &c.SshCredentials.KeyPassword, c.SshCredentials.KeyPasswordFile,
+	)
+}
+
+func readSecretField(
+	v *validation.V,
+	fs billy.Filesystem,
+	name string,
+	value *string,
+	path string,
+) {
+	if path == "" {
+		return
+	}
+	if *value != "" {
+		v.FailF(
+			name+"/"+name+"File",
+			"%s and %sFile cannot be used together", name, name,
+		)
+		return
+	}
+	secret, err := ReadSecretFile(fs, path)
+	if err != nil {
+		v.FailF(name+"File", "failed to read %s from file: %s", name, err)
+		return
+	}
+	*value = secret
+}
+
+func (cs *ConfigSingle) readSecretFiles(v *validation.V, fs billy.Filesystem) {
+	targetsV := v.Sub("targets")
+	for targetId, target := range cs.Targets {
+		target.readSecretFiles(targetsV.Sub(targetId), fs)
+		cs.Targets[targetId] = target
+	}
+}
+
+func (cfg *Config) readSecretFiles(v *validation.V, fs billy.Filesystem) {
+	for repoId, repo := range cfg.Repositories {
+		repo.readSecretFiles(cfg.origins.repositoryV(v, repoId), fs)
+		cfg.Repositories[repoId] = repo
+	}
+}
+
+func (c *Credentials) resolveSecretFilePaths(parent string, res *resolver) {
+	var err error
+	c.HttpTokenFile, err = res.replace(c.HttpTokenFile)
+	if err != nil {
+		logEnvVarSubstWarning(err, parent, "httpTokenFile")
+	}
+	c.HttpCredentials.PasswordFile, err = res.replace(c.HttpCredentials.PasswordFile)
+	if err != nil {
+		logEnvVarSubstWarning(err, parent, "passwordFile")
+	}
+	c.SshCredentials.KeyPasswordFile, err = res.replace(c.SshCredentials.KeyPasswordFile)
+	if err != nil {
+		logEnvVarSubstWarning(err, parent, "keyPasswordFile")
+	}
+}
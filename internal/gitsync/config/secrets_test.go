@@ -0,0 +1,95 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	fsutil "github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/envvar"
+)
+
+const secretFilesConfigJson = `{
+  "profiles": {
+    "gitlab-bot": { "httpTokenFile": "/run/secrets/gitlab-token" }
+  },
+  "repositories": {
+    "otk-github": {
+      "url": "git@github.com:jpallari/otk.git",
+      "sshCredentials": { "keyPath": "/keys/github", "keyPasswordFile": "${SECRETS_DIR}/key-password" }
+    },
+    "otk-gitlab": { "url": "https://gitlab.com/jpallari/otk.git", "profile": "gitlab-bot" },
+    "otk-codeberg": {
+      "url": "https://codeberg.org/jpallari/otk.git",
+      "httpCredentials": { "username": "jpallari", "passwordFile": "/run/secrets/codeberg-password" }
+    },
+    "otk-inline": {
+      "url": "https://gitlab.com/jpallari/otk-inline.git",
+      "profile": "gitlab-bot",
+      "httpToken": "${file:/run/secrets/inline-token}",
+      "webhookSecret": "${WEBHOOK_SECRET}"
+    }
+  },
+  "mappings": [
+    { "source": "otk-github", "targets": [ "otk-gitlab", "otk-codeberg", "otk-inline" ], "branches": [ "main" ] }
+  ]
+}`
+
+func TestParseSecretFiles(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	fs := memfs.New()
+	secrets := map[string]string{
+		"/run/secrets/gitlab-token":      "gitlab_token\n",
+		"/run/secrets/key-password":      "key_password\r\n",
+		"/run/secrets/codeberg-password": "codeberg_password",
+		"/run/secrets/inline-token":      "inline_token\n\n",
+		"/run/secrets/webhook-secret":    "webhook_secret\n",
+	}
+	for path, content := range secrets {
+		require.NoError(fsutil.WriteFile(fs, path, []byte(content), 0o600))
+	}
+	var envVars envvar.Vars
+	envVars.FromMap(map[string]string{
+		"SECRETS_DIR":         "/run/secrets",
+		"WEBHOOK_SECRET_FILE": "/run/secrets/webhook-secret",
+	})
+	var conf Config
+
+	err := conf.Parse(envVars, fs, bytes.NewBufferString(secretFilesConfigJson), nil)
+	require.NoError(err)
+
+	assert.Equal("key_password", conf.Repositories["otk-github"].SshCredentials.KeyPassword)
+	gitlab := conf.Repositories["otk-gitlab"]
+	assert.Equal("gitlab_token", gitlab.HttpToken)
+	assert.Equal(AuthMethodHttpToken, gitlab.AuthMethod())
+	assert.Equal("codeberg_password", conf.Repositories["otk-codeberg"].HttpCredentials.Password)
+	assert.Equal("inline_token", conf.Repositories["otk-inline"].HttpToken, "inline token overrides the profile")
+	assert.Equal("webhook_secret", conf.Repositories["otk-inline"].WebhookSecret)
+}
+
+func TestParseSecretFilesInvalid(t *testing.T) {
+	assert := assert.New(t)
+	var envVars envvar.Vars
+	var conf Config
+
+	err := conf.Parse(envVars, memfs.New(), bytes.NewBufferString(`{
+  "repositories": {
+    "source": {
+      "url": "https://github.com/jpallari/otk.git",
+      "httpToken": "token",
+      "httpTokenFile": "/run/secrets/token"
+    },
+    "target": {
+      "url": "https://gitlab.com/jpallari/otk.git",
+      "httpCredentials": { "username": "jpallari", "passwordFile": "/run/secrets/missing" }
+    }
+  },
+  "mappings": [ { "source": "source", "targets": [ "target" ], "branches": [ "main" ] } ]
+}`), nil)
+
+	assert.ErrorContains(err, "httpToken/httpTokenFile: httpToken and httpTokenFile cannot be used together")
+	assert.ErrorContains(err, "passwordFile: failed to read password from file")
+}
@@ -0,0 +1,351 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.lepovirta.org/otk/internal/validation"
+)
+
+const (
+	timeOfDayFormat = "15:04"
+	endOfDay        = "24:00"
+	minutesInDay    = 24 * 60
+
+	// maxWindowSearch limits how far to the future the next allowed time is searched for.
+	maxWindowSearch = 366 * 24 * time.Hour
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// TimeWindow specifies a period of time that recurs on the given days
+// of the week between the given times of the day. The period can be
+// limited to a date range. By default, the period covers all of the time.
+type TimeWindow struct {
+	// Days contains the days of the week when the period starts
+	// e.g. `["mon", "wed-fri"]`. By default, every day.
+	Days []string `json:"days"`
+
+	// Start is the time of the day when the period starts in format `HH:MM`.
+	// By default, the period starts at the start of the day.
+	Start string `json:"start"`
+
+	// End is the time of the day when the period ends in format `HH:MM`.
+	// The end of the day can be specified as `24:00`.
+	// When the end is not after the start, the period continues to the next day.
+	// By default, the period ends at the end of the day.
+	End string `json:"end"`
+
+	// From is the date (`2006-01-02`) or the time (RFC 3339) from which on
+	// the period is in effect. By default, there's no start date.
+	From string `json:"from"`
+
+	// Until is the date (`2006-01-02`) or the time (RFC 3339) until which
+	// the period is in effect. The date is inclusive. By default, there's no end date.
+	Until string `json:"until"`
+
+	// TimeZone specifies the time zone of the period e.g. `Europe/Helsinki`.
+	// Default is the time zone of the schedule.
+	TimeZone string `json:"timeZone"`
+
+	// parsed is set during validation, so that the window
+	// doesn't need to be parsed on every check.
+	parsed *window
+}
+
+// window is the parsed form of the time window.
+type window struct {
+	days  [7]bool
+	start int
+	end   int
+	from  time.Time
+	until time.Time
+	loc   *time.Location
+}
+
+// windowSet contains the parsed windows and blackouts of a sync spec.
+type windowSet struct {
+	windows   []*window
+	blackouts []*window
+}
+
+// SyncAllowed reports whether the synchronisation is allowed at the given time
+// based on the windows and the blackouts.
+func (ss *SyncSpec) SyncAllowed(t time.Time) bool {
+	ws := ss.windowSet()
+	return ws.allows(t)
+}
+
+// NextAllowedSync returns the first time at or after the given time when
+// the synchronisation is allowed. False is returned when no such time
+// is found within a year.
+func (ss *SyncSpec) NextAllowedSync(t time.Time) (time.Time, bool) {
+	ws := ss.windowSet()
+	limit := t.Add(maxWindowSearch)
+	for next := t; !next.IsZero() && next.Before(limit); next = ws.nextBoundary(next) {
+		if ws.allows(next) {
+			return next, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// HasWindows reports whether the synchronisation is limited by windows or blackouts.
+func (ss *SyncSpec) HasWindows() bool {
+	return len(ss.Windows) > 0 || len(ss.Blackouts) > 0
+}
+
+// windowSet collects the windows and the blackouts parsed during validation.
+// The windows that haven't been validated are parsed here instead.
+// Invalid windows are rejected during validation, so they are ignored here.
+func (ss *SyncSpec) windowSet() (ws windowSet) {
+	var loc *time.Location
+	parsed := func(tw *TimeWindow) *window {
+		if tw.parsed != nil {
+			return tw.parsed
+		}
+		if loc == nil {
+			loc = ss.Location()
+		}
+		if w, err := tw.parse(loc); err == nil {
+			return &w
+		}
+		return nil
+	}
+	for i := range ss.Windows {
+		if w := parsed(&ss.Windows[i]); w != nil {
+			ws.windows = append(ws.windows, w)
+		}
+	}
+	for i := range ss.Blackouts {
+		if w := parsed(&ss.Blackouts[i]); w != nil {
+			ws.blackouts = append(ws.blackouts, w)
+		}
+	}
+	return
+}
+
+func (ws *windowSet) allows(t time.Time) bool {
+	for _, b := range ws.blackouts {
+		if b.contains(t) {
+			return false
+		}
+	}
+	if len(ws.windows) == 0 {
+		return true
+	}
+	for _, w := range ws.windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextBoundary returns the earliest time after the given time when any of
+// the windows or the blackouts starts or ends. Whether the synchronisation
+// is allowed can change only at these times.
+func (ws *windowSet) nextBoundary(t time.Time) (next time.Time) {
+	for _, windows := range [][]*window{ws.windows, ws.blackouts} {
+		for _, w := range windows {
+			if b := w.nextBoundary(t); next.IsZero() || b.Before(next) {
+				next = b
+			}
+		}
+	}
+	return
+}
+
+// nextBoundary returns the earliest time after the given time when
+// the window may start or end. The start of the next day and the changes
+// in the time zone offset are included, because they change the day of
+// the week and the time of the day.
+func (w *window) nextBoundary(t time.Time) (next time.Time) {
+	t = t.In(w.loc)
+	year, month, day := t.Date()
+	_, zoneEnd := t.ZoneBounds()
+	candidates := [...]time.Time{
+		w.from,
+		w.until,
+		zoneEnd,
+		time.Date(year, month, day, 0, w.start, 0, 0, w.loc),
+		time.Date(year, month, day, 0, w.end, 0, 0, w.loc),
+		time.Date(year, month, day+1, 0, 0, 0, 0, w.loc),
+		time.Date(year, month, day+1, 0, w.start, 0, 0, w.loc),
+		time.Date(year, month, day+1, 0, w.end, 0, 0, w.loc),
+	}
+	for _, c := range candidates {
+		if c.After(t) && (next.IsZero() || c.Before(next)) {
+			next = c
+		}
+	}
+	return
+}
+
+func (w *window) contains(t time.Time) bool {
+	t = t.In(w.loc)
+	if !w.from.IsZero() && t.Before(w.from) {
+		return false
+	}
+	if !w.until.IsZero() && !t.Before(w.until) {
+		return false
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	weekday := t.Weekday()
+	if w.start < w.end {
+		return w.days[weekday] && w.start <= minute && minute < w.end
+	}
+
+	// The period continues to the next day
+	previousDay := (weekday + 6) % 7
+	return (w.days[weekday] && minute >= w.start) || (w.days[previousDay] && minute < w.end)
+}
+
+func (tw *TimeWindow) parse(defaultLoc *time.Location) (w window, err error) {
+	w.loc = defaultLoc
+	if tw.TimeZone != "" {
+		if w.loc, err = time.LoadLocation(tw.TimeZone); err != nil {
+			return w, fmt.Errorf("unknown time zone %s", tw.TimeZone)
+		}
+	}
+	if w.days, err = parseWeekdays(tw.Days); err != nil {
+		return
+	}
+	if w.start, err = parseTimeOfDay(tw.Start, false); err != nil {
+		return
+	}
+	if w.end, err = parseTimeOfDay(tw.End, true); err != nil {
+		return
+	}
+	if w.from, err = parseDateOrTime(tw.From, w.loc, false); err != nil {
+		return
+	}
+	if w.until, err = parseDateOrTime(tw.Until, w.loc, true); err != nil {
+		return
+	}
+	if !w.from.IsZero() && !w.until.IsZero() && !w.from.Before(w.until) {
+		return w, fmt.Errorf("from %s must be before until %s", tw.From, tw.Until)
+	}
+	return w, nil
+}
+
+// validateTimeWindows parses the windows and stores the parsed forms
+// in the valid windows.
+func validateTimeWindows(v *validation.V, windows []TimeWindow, defaultLoc *time.Location) {
+	for i := range windows {
+		w, err := windows[i].parse(defaultLoc)
+		if err != nil {
+			v.IndexFail(i, err.Error())
+			continue
+		}
+		windows[i].parsed = &w
+	}
+}
+
+// parseWeekdays parses the day names and ranges such as `mon-fri`.
+// All days are included when no days are specified.
+func parseWeekdays(days []string) (set [7]bool, err error) {
+	if len(days) == 0 {
+		for i := range set {
+			set[i] = true
+		}
+		return
+	}
+	for _, day := range days {
+		startName, endName, isRange := strings.Cut(strings.ToLower(day), "-")
+		start, ok := weekdayNames[startName]
+		if !ok {
+			return set, fmt.Errorf("unknown day %s", day)
+		}
+		end := start
+		if isRange {
+			if end, ok = weekdayNames[endName]; !ok {
+				return set, fmt.Errorf("unknown day %s", day)
+			}
+		}
+		for d := start; ; d = (d + 1) % 7 {
+			set[d] = true
+			if d == end {
+				break
+			}
+		}
+	}
+	return
+}
+
+// parseTimeOfDay parses the time of the day in format `HH:MM`
+// into minutes since the start of the day. When the time is an end time,
+// the end of the day is accepted as `24:00` and used by default.
+func parseTimeOfDay(s string, isEnd bool) (int, error) {
+	if isEnd && (s == "" || s == endOfDay) {
+		return minutesInDay, nil
+	}
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.Parse(timeOfDayFormat, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %s, expected format HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseDateOrTime parses the date or the RFC 3339 time.
+// When the date is an end date, the time at the end of the date is returned.
+func parseDateOrTime(s string, loc *time.Location, isEnd bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, loc); err == nil {
+		if isEnd {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date or time %s, expected format 2006-01-02 or RFC 3339", s)
+	}
+	return t, nil
+}
+
+// String describes the time window e.g. `mon-fri 09:00-17:00 (UTC)`.
+func (tw *TimeWindow) String() string {
+	parts := make([]string, 0, 5)
+	if len(tw.Days) > 0 {
+		parts = append(parts, strings.Join(tw.Days, ","))
+	}
+	if tw.Start != "" || tw.End != "" {
+		start, end := tw.Start, tw.End
+		if start == "" {
+			start = "00:00"
+		}
+		if end == "" {
+			end = endOfDay
+		}
+		parts = append(parts, start+"-"+end)
+	}
+	if tw.From != "" {
+		parts = append(parts, "from "+tw.From)
+	}
+	if tw.Until != "" {
+		parts = append(parts, "until "+tw.Until)
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "always")
+	}
+	if tw.TimeZone != "" {
+		parts = append(parts, "("+tw.TimeZone+")")
+	}
+	return strings.Join(parts, " ")
+}
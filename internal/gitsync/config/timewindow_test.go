@@ -0,0 +1,172 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/envvar"
+	"go.lepovirta.org/otk/internal/validation"
+)
+
+func TestSyncAllowed(t *testing.T) {
+	assert := assert.New(t)
+	ss := SyncSpec{
+		TimeZone: "UTC",
+		Windows: []TimeWindow{
+			{Days: []string{"mon-fri"}, Start: "09:00", End: "17:00"},
+			{Days: []string{"sat"}, Start: "22:00", End: "02:00"},
+		},
+		Blackouts: []TimeWindow{
+			{From: "2025-05-06", Until: "2025-05-07"},
+			{Days: []string{"fri"}, Start: "12:00", End: "13:00", TimeZone: "Europe/Helsinki"},
+		},
+	}
+
+	// 2025-05-05 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2025, 5, day, hour, minute, 0, 0, time.UTC)
+	}
+	assert.True(ss.SyncAllowed(at(5, 9, 0)))
+	assert.True(ss.SyncAllowed(at(5, 16, 59)))
+	assert.False(ss.SyncAllowed(at(5, 17, 0)))
+	assert.False(ss.SyncAllowed(at(5, 8, 59)))
+	assert.False(ss.SyncAllowed(at(6, 12, 0)), "blackout date")
+	assert.False(ss.SyncAllowed(at(7, 12, 0)), "blackout until is inclusive")
+	assert.True(ss.SyncAllowed(at(8, 12, 0)))
+	assert.False(ss.SyncAllowed(at(9, 9, 30)), "blackout in Helsinki time")
+	assert.True(ss.SyncAllowed(at(9, 10, 0)))
+	assert.True(ss.SyncAllowed(at(10, 23, 0)), "saturday evening")
+	assert.True(ss.SyncAllowed(at(11, 1, 59)), "window continues to sunday")
+	assert.False(ss.SyncAllowed(at(11, 2, 0)))
+	assert.False(ss.SyncAllowed(at(11, 23, 0)))
+
+	assert.True((&SyncSpec{}).SyncAllowed(at(11, 23, 0)), "no windows")
+}
+
+func TestNextAllowedSync(t *testing.T) {
+	assert := assert.New(t)
+	ss := SyncSpec{
+		TimeZone: "UTC",
+		Windows: []TimeWindow{
+			{Days: []string{"mon-fri"}, Start: "09:00", End: "17:00"},
+		},
+		Blackouts: []TimeWindow{
+			{From: "2025-05-13T00:00:00Z", Until: "2025-05-14T10:30:00Z"},
+		},
+	}
+
+	now := time.Date(2025, 5, 5, 10, 15, 30, 0, time.UTC)
+	next, ok := ss.NextAllowedSync(now)
+	assert.True(ok)
+	assert.Equal(now, next, "already allowed")
+
+	next, ok = ss.NextAllowedSync(time.Date(2025, 5, 9, 17, 0, 30, 0, time.UTC))
+	assert.True(ok)
+	assert.Equal(time.Date(2025, 5, 12, 9, 0, 0, 0, time.UTC), next.UTC())
+
+	next, ok = ss.NextAllowedSync(time.Date(2025, 5, 12, 17, 0, 0, 0, time.UTC))
+	assert.True(ok)
+	assert.Equal(time.Date(2025, 5, 14, 10, 30, 0, 0, time.UTC), next.UTC(), "blackout")
+
+	ss.Blackouts = []TimeWindow{{}}
+	_, ok = ss.NextAllowedSync(now)
+	assert.False(ok, "permanent blackout")
+}
+
+func TestNextAllowedSyncAcrossBoundaries(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	helsinki, err := time.LoadLocation("Europe/Helsinki")
+	require.NoError(err, "load location")
+
+	// Clocks are turned from 03:00 to 04:00 in Helsinki on 2025-03-30
+	ss := SyncSpec{
+		Windows: []TimeWindow{
+			{Start: "03:30", End: "05:00", TimeZone: "Europe/Helsinki"},
+		},
+	}
+	next, ok := ss.NextAllowedSync(time.Date(2025, 3, 30, 1, 0, 0, 0, helsinki))
+	assert.True(ok)
+	assert.Equal(time.Date(2025, 3, 30, 4, 0, 0, 0, helsinki), next.In(helsinki), "start skipped by DST")
+
+	ss = SyncSpec{
+		TimeZone: "UTC",
+		Windows: []TimeWindow{
+			{Days: []string{"sun"}, Start: "22:00", End: "24:00", From: "2025-11-01"},
+		},
+	}
+	next, ok = ss.NextAllowedSync(time.Date(2025, 5, 5, 12, 0, 0, 0, time.UTC))
+	assert.True(ok)
+	assert.Equal(time.Date(2025, 11, 2, 22, 0, 0, 0, time.UTC), next.UTC(), "months later")
+	assert.True(ss.SyncAllowed(time.Date(2025, 11, 2, 23, 59, 0, 0, time.UTC)), "until the end of the day")
+	assert.False(ss.SyncAllowed(time.Date(2025, 11, 3, 0, 0, 0, 0, time.UTC)), "not after the end of the day")
+}
+
+func TestValidateTimeWindows(t *testing.T) {
+	assert := assert.New(t)
+	windows := []TimeWindow{
+		{Start: "22:00", End: "24:00"},
+		{Start: "24:00"},
+	}
+	var v validation.V
+	v.Init()
+
+	validateTimeWindows(&v, windows, time.UTC)
+
+	err := v.ToError()
+	assert.ErrorContains(err, "1: invalid time of day 24:00, expected format HH:MM")
+	assert.NotContains(err.Error(), "0: ", "end of day is a valid end")
+	if assert.NotNil(windows[0].parsed, "valid window is parsed") {
+		assert.Equal(22*60, windows[0].parsed.start)
+		assert.Equal(minutesInDay, windows[0].parsed.end)
+	}
+	assert.Nil(windows[1].parsed, "invalid window")
+}
+
+func TestTimeWindowString(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("always", (&TimeWindow{}).String())
+	assert.Equal(
+		"mon-fri,sun 09:00-24:00 (UTC)",
+		(&TimeWindow{Days: []string{"mon-fri", "sun"}, Start: "09:00", TimeZone: "UTC"}).String(),
+	)
+	assert.Equal(
+		"from 2025-12-20 until 2026-01-06",
+		(&TimeWindow{From: "2025-12-20", Until: "2026-01-06"}).String(),
+	)
+}
+
+func TestParseInvalidTimeWindows(t *testing.T) {
+	assert := assert.New(t)
+	var conf Config
+	var envVars envvar.Vars
+	configStream := bytes.NewBufferString(`{
+  "path": ".",
+  "targets": {
+    "target": {
+      "url": "https://gitlab.com/jpallari/otk.git",
+      "branches": [ "main" ],
+      "windows": [
+        { "days": [ "mon-someday" ] },
+        { "start": "9am" },
+        { "timeZone": "Nowhere/Unknown" }
+      ],
+      "blackouts": [
+        { "from": "2025-12-20", "until": "2025-12-01" },
+        { "until": "next week" }
+      ]
+    }
+  }
+}`)
+
+	err := conf.Parse(envVars, nil, configStream, nil)
+
+	assert.ErrorContains(err, "unknown day mon-someday")
+	assert.ErrorContains(err, "invalid time of day 9am, expected format HH:MM")
+	assert.ErrorContains(err, "unknown time zone Nowhere/Unknown")
+	assert.ErrorContains(err, "from 2025-12-20 must be before until 2025-12-01")
+	assert.ErrorContains(err, "invalid date or time next week")
+}
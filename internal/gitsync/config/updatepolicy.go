@@ -0,0 +1,89 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// UpdatePolicy specifies how refs that already exist in
+// the target Git repository are updated.
+type UpdatePolicy int
+
+const (
+	// UpdatePolicyUndefined means that the update policy is not specified.
+	// Refs are force updated by default.
+	UpdatePolicyUndefined UpdatePolicy = iota
+
+	// UpdatePolicyForce means that the target refs are always overwritten
+	// with the source refs.
+	UpdatePolicyForce
+
+	// UpdatePolicyFastForwardOnly means that the target refs are only
+	// updated when the update is a fast-forward. Diverged refs are
+	// reported as errors.
+	UpdatePolicyFastForwardOnly
+
+	// UpdatePolicySkipDiverged means that the target refs are only
+	// updated when the update is a fast-forward. Diverged refs are
+	// skipped without reporting an error.
+	UpdatePolicySkipDiverged
+)
+
+// AllowsForce reports whether target refs can be overwritten
+// even when they have diverged from the source.
+func (u UpdatePolicy) AllowsForce() bool {
+	return u == UpdatePolicyUndefined || u == UpdatePolicyForce
+}
+
+func (u UpdatePolicy) MarshalJSON() ([]byte, error) {
+	var s string
+	switch u {
+	case UpdatePolicyUndefined:
+		return json.Marshal(nil)
+	case UpdatePolicyForce:
+		s = "force"
+	case UpdatePolicyFastForwardOnly:
+		s = "fast-forward-only"
+	case UpdatePolicySkipDiverged:
+		s = "skip-diverged"
+	default:
+		return nil, fmt.Errorf("unknown update policy '%s'", u)
+	}
+	return json.Marshal(s)
+}
+
+func (u *UpdatePolicy) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch strings.ToLower(v) {
+	case "", "undefined":
+		*u = UpdatePolicyUndefined
+	case "force":
+		*u = UpdatePolicyForce
+	case "fast-forward-only", "ff-only":
+		*u = UpdatePolicyFastForwardOnly
+	case "skip-diverged":
+		*u = UpdatePolicySkipDiverged
+	default:
+		return fmt.Errorf("unexpected value '%s' for update policy", v)
+	}
+	return nil
+}
+
+func (u UpdatePolicy) String() string {
+	switch u {
+	case UpdatePolicyUndefined:
+		return ""
+	case UpdatePolicyForce:
+		return "force"
+	case UpdatePolicyFastForwardOnly:
+		return "fast-forward-only"
+	case UpdatePolicySkipDiverged:
+		return "skip-diverged"
+	default:
+		return fmt.Sprintf("unknown(%d)", u)
+	}
+}
@@ -1,13 +1,44 @@
 package config
 
+import "go.lepovirta.org/otk/internal/duration"
+
 func overrideStr(target *string, source string) {
 	if source != "" {
 		*target = source
 	}
 }
 
-func overrideBool(target *bool, source bool) {
-	if source {
+// overrideBool overrides the flag when the source flag is set,
+// so that an explicit `false` overrides an inherited `true`.
+func overrideBool(target **bool, source *bool) {
+	if source != nil {
+		*target = source
+	}
+}
+
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
+
+func overrideInt(target *int, source int) {
+	if source != 0 {
+		*target = source
+	}
+}
+
+func overrideDuration(target *duration.D, source duration.D) {
+	if source.Duration != 0 {
 		*target = source
 	}
 }
+
+// overrideSecret overrides both the secret and the path to the secret file
+// when either of them is set, so that a secret set inline doesn't conflict
+// with a secret file set elsewhere.
+func overrideSecret(target, targetFile *string, source, sourceFile string) {
+	if source == "" && sourceFile == "" {
+		return
+	}
+	*target = source
+	*targetFile = sourceFile
+}
@@ -7,11 +7,13 @@ import (
 	"fmt"
 	"log/slog"
 	"syscall"
+	"time"
 
 	"go.lepovirta.org/otk/internal/gitsync/config"
 	"go.lepovirta.org/otk/internal/logging"
 	"go.lepovirta.org/otk/internal/osenv"
 	"go.lepovirta.org/otk/internal/sighandle"
+	"go.lepovirta.org/otk/internal/tracing"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -19,6 +21,10 @@ type Core struct {
 	osEnv    osenv.OsEnv
 	cliFlags config.CliFlags
 	cfg      config.Config
+	sources  SourceCache
+	tracer   *tracing.Tracer
+	loops    []*syncLoop
+	handler  reloadableHandler
 }
 
 func (c *Core) Init(osEnv osenv.OsEnv) error {
@@ -42,6 +48,8 @@ func (c *Core) Init(osEnv osenv.OsEnv) error {
 	if err := parseConfig(c.osEnv, &c.cliFlags, &c.cfg); err != nil {
 		return fmt.Errorf("failed to parse config: %w", err)
 	}
+	c.sources.stateDir = c.cfg.StateDir
+	c.tracer = newTracer(&c.cfg.Tracing, c.osEnv.HttpTransport)
 	return nil
 }
 
@@ -50,9 +58,14 @@ func (c *Core) Run(ctx context.Context) error {
 
 	if !c.cliFlags.Run {
 		log.DebugContext(ctx, "run dry-run")
-		return c.dryRun()
+		return c.dryRun(ctx)
 	}
 
+	// Tracer is nil when tracing is disabled
+	c.tracer.Start(ctx)
+	defer c.tracer.Shutdown(ctx)
+	ctx = tracing.AddToContext(ctx, c.tracer)
+
 	if c.cliFlags.Once {
 		log.DebugContext(ctx, "run once")
 		return c.runOnce(ctx)
@@ -61,67 +74,112 @@ func (c *Core) Run(ctx context.Context) error {
 	return c.runLoop(ctx)
 }
 
-func (c *Core) dryRun() error {
-	return dryRun(c.osEnv.Stdout, &c.cfg)
+func (c *Core) dryRun(ctx context.Context) error {
+	return dryRun(ctx, c.osEnv.Stdout, &c.osEnv, &c.cfg)
 }
 
 func (c *Core) runOnce(ctx context.Context) error {
 	ctx, sigCancel := sighandle.CancelOnSignals(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer sigCancel()
+	defer c.cleanUp(ctx)
 
-	log := logging.FromContext(ctx)
-
-	cleanUp := func(gitSync *GitSync) {
-		if err := gitSync.Clean(c.osEnv.Fs); err != nil {
-			log.ErrorContext(ctx, "cleanup failed", slog.Any("error", err))
-		}
-	}
+	// Fetches made during this run are shared between the mappings
+	since := time.Now()
+	options := c.syncOptions()
 
-	errs := make([]error, 0, len(c.cfg.Mappings))
-	var gitSync GitSync
-	for _, mapping := range c.cfg.Mappings {
-		if err := gitSync.Init(ctx, &c.osEnv, c.cfg.Repositories, &mapping); err != nil {
-			cleanUp(&gitSync)
-			errs = append(errs, err)
+	// Init modifies the shared source repos, so it's done before
+	// starting any of the syncs.
+	errs := make([]error, len(c.cfg.Mappings))
+	gitSyncs := make([]*GitSync, 0, len(c.cfg.Mappings))
+	for i, mapping := range c.cfg.Mappings {
+		if !options.IgnoreWindows && !mapping.SyncAllowed(since) {
+			logArgs := []any{
+				slog.String("sourceId", mapping.Source),
+				slog.Any("targetIds", mapping.Targets),
+			}
+			if allowedAt, ok := mapping.NextAllowedSync(since); ok {
+				logArgs = append(logArgs, slog.Time("allowedAt", allowedAt))
+			}
+			logging.FromContext(ctx).InfoContext(
+				ctx,
+				"skipping sync outside of the sync windows, use -ignore-windows to sync anyway",
+				logArgs...,
+			)
 			continue
 		}
-		if err := gitSync.RunOnce(ctx); err != nil {
-			errs = append(errs, err)
+		var gitSync GitSync
+		if err := gitSync.Init(ctx, &c.osEnv, &c.sources, c.cfg.Repositories, &mapping, options); err != nil {
+			errs[i] = err
+			continue
 		}
-		cleanUp(&gitSync)
+		gitSyncs = append(gitSyncs, &gitSync)
+	}
+
+	syncErrs := make([]error, len(gitSyncs))
+	var eg errgroup.Group
+	eg.SetLimit(options.Concurrency)
+	for i, gitSync := range gitSyncs {
+		eg.Go(func() error {
+			syncErrs[i] = gitSync.runOnce(ctx, since)
+			return nil
+		})
 	}
-	return errors.Join(errs...)
+	_ = eg.Wait()
+
+	return errors.Join(append(errs, syncErrs...)...)
 }
 
 func (c *Core) runLoop(ctx context.Context) error {
 	ctx, sigCancel := sighandle.CancelOnSignals(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer sigCancel()
+	defer c.cleanUp(ctx)
 
-	log := logging.FromContext(ctx)
-
-	cleanUp := func(gitSync *GitSync) {
-		if err := gitSync.Clean(c.osEnv.Fs); err != nil {
-			log.ErrorContext(ctx, "cleanup failed", slog.Any("error", err))
-		}
-	}
+	// Loops are replaced during reloads, so they are stopped
+	// using the latest list.
+	defer func() { stopLoops(c.loops) }()
 
-	gitSyncs := make([]GitSync, len(c.cfg.Mappings))
-	for i, mapping := range c.cfg.Mappings {
-		gitSync := &gitSyncs[i]
-		if err := gitSync.Init(ctx, &c.osEnv, c.cfg.Repositories, &mapping); err != nil {
-			cleanUp(gitSync)
+	options := c.syncOptions()
+	for i := range c.cfg.Mappings {
+		loop, err := c.startLoop(ctx, &c.cfg, &c.cfg.Mappings[i], options)
+		c.loops = append(c.loops, loop)
+		if err != nil {
 			return err
 		}
-		defer cleanUp(gitSync)
 	}
 
 	eg, ctx := errgroup.WithContext(ctx)
-	for i := range gitSyncs {
-		gitSync := gitSyncs[i]
+	if address := c.cfg.Server.Address; address != "" {
+		c.handler.set(newServeMux(&c.cfg, loopGitSyncs(c.loops)))
 		eg.Go(func() error {
-			return gitSync.RunInLoop(ctx)
+			return serve(ctx, address, &c.handler)
 		})
 	}
+	eg.Go(func() error {
+		c.handleReloads(ctx)
+		return nil
+	})
 
 	return eg.Wait()
 }
+
+// syncOptions combines the sync options from the CLI flags and
+// the configuration. The CLI flags take precedence over the configuration.
+func (c *Core) syncOptions() SyncOptions {
+	options := SyncOptions{
+		Concurrency:   max(c.cfg.Concurrency, 1),
+		ForceFullSync: c.cliFlags.ForceFullSync,
+		StatusHistory: c.cfg.Server.StatusHistory,
+		IgnoreWindows: c.cliFlags.IgnoreWindows,
+	}
+	if c.cliFlags.Concurrency > 0 {
+		options.Concurrency = c.cliFlags.Concurrency
+	}
+	return options
+}
+
+func (c *Core) cleanUp(ctx context.Context) {
+	if err := c.sources.Clean(c.osEnv.Fs); err != nil {
+		log := logging.FromContext(ctx)
+		log.ErrorContext(ctx, "cleanup failed", slog.Any("error", err))
+	}
+}
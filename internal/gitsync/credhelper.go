@@ -0,0 +1,175 @@
+package gitsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/logging"
+)
+
+// Credential helper actions as described in gitcredentials(7).
+const (
+	credentialGet   = "get"
+	credentialStore = "store"
+	credentialErase = "erase"
+)
+
+// credentialHelper runs a Git credential helper for a repository.
+type credentialHelper struct {
+	command  string
+	args     []string
+	protocol string
+	host     string
+	path     string
+	username string
+
+	// stored contains the credentials last stored to the helper,
+	// so that they are not stored again after every push.
+	mu     sync.Mutex
+	stored http.BasicAuth
+}
+
+func (h *credentialHelper) init(helperConfig *config.CredentialHelper, repoURL string) error {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse repository URL: %w", err)
+	}
+	h.command = helperConfig.Command
+	h.args = helperConfig.Args
+	h.protocol = u.Scheme
+	h.host = u.Host
+	h.path = strings.TrimPrefix(u.Path, "/")
+	h.username = u.User.Username()
+	return nil
+}
+
+// get asks the helper for the credentials of the repository.
+func (h *credentialHelper) get(ctx context.Context) (*http.BasicAuth, error) {
+	out, err := h.run(ctx, credentialGet, h.username, "")
+	if err != nil {
+		return nil, err
+	}
+	auth := &http.BasicAuth{Username: h.username}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "username":
+			auth.Username = value
+		case "password":
+			auth.Password = value
+		case "quit":
+			if value == "1" || value == "true" {
+				return nil, errors.New("credential helper asked to stop")
+			}
+		}
+	}
+	if auth.Password == "" {
+		return nil, errors.New("no password returned by the credential helper")
+	}
+	return auth, nil
+}
+
+// store tells the helper that the credentials were accepted.
+// Only the credentials that differ from the ones stored last are stored,
+// i.e. the fresh credentials returned by the helper.
+func (h *credentialHelper) store(ctx context.Context, auth *http.BasicAuth) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stored == *auth {
+		return nil
+	}
+	if _, err := h.run(ctx, credentialStore, auth.Username, auth.Password); err != nil {
+		return err
+	}
+	h.stored = *auth
+	return nil
+}
+
+// erase tells the helper that the credentials were rejected.
+func (h *credentialHelper) erase(ctx context.Context, auth *http.BasicAuth) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stored == *auth {
+		h.stored = http.BasicAuth{}
+	}
+	_, err := h.run(ctx, credentialErase, auth.Username, auth.Password)
+	return err
+}
+
+func (h *credentialHelper) run(
+	ctx context.Context,
+	action string,
+	username string,
+	password string,
+) ([]byte, error) {
+	var input strings.Builder
+	writeAttr := func(key, value string) {
+		if value != "" {
+			input.WriteString(key + "=" + value + "\n")
+		}
+	}
+	writeAttr("protocol", h.protocol)
+	writeAttr("host", h.host)
+	writeAttr("path", h.path)
+	writeAttr("username", username)
+	writeAttr("password", password)
+	input.WriteString("\n")
+
+	args := append(append([]string{}, h.args...), action)
+	cmd := exec.CommandContext(ctx, h.command, args...)
+	cmd.Stdin = strings.NewReader(input.String())
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	h.logStderr(ctx, &stderr, password, out)
+	if err != nil {
+		// The standard error may contain the credentials, so it's only logged.
+		return nil, fmt.Errorf("credential helper '%s' failed to %s credentials: %w", h.command, action, err)
+	}
+	return out, nil
+}
+
+// logStderr writes the lines of the helper's standard error to the log
+// with the passwords given to and returned by the helper redacted.
+func (h *credentialHelper) logStderr(
+	ctx context.Context,
+	stderr *bytes.Buffer,
+	password string,
+	out []byte,
+) {
+	secrets := []string{password}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "password="); ok {
+			secrets = append(secrets, value)
+		}
+	}
+
+	log := logging.FromContext(ctx)
+	scanner = bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		log.InfoContext(
+			ctx, "credential helper output",
+			slog.String("command", h.command),
+			slog.String("stderr", redact(line, secrets)),
+		)
+	}
+}
@@ -0,0 +1,110 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/logging"
+	"go.lepovirta.org/otk/internal/osenv"
+)
+
+const credentialHelperScript = `#!/bin/sh
+cat > "$LOG_DIR/$1"
+echo "$1" >> "$LOG_DIR/actions"
+if [ "$1" = get ]; then
+	echo username=bot
+	echo password=secret
+fi
+`
+
+func writeCredentialHelper(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "helper.sh")
+	require.NoError(t, os.WriteFile(script, []byte(credentialHelperScript), 0o700))
+	t.Setenv("LOG_DIR", dir)
+	return script, dir
+}
+
+func TestCredentialHelper(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	ctx := context.Background()
+	script, dir := writeCredentialHelper(t)
+	repoConfig := config.Repository{
+		URL: "https://gitlab.com/jpallari/otk.git",
+		Credentials: config.Credentials{
+			CredentialHelper: config.CredentialHelper{Command: script},
+		},
+	}
+
+	var provider authProvider
+	require.NoError(provider.init(&osenv.OsEnv{Fs: memfs.New()}, "otk-gitlab", &repoConfig, slog.Default()))
+	auth, err := provider.get(ctx)
+	require.NoError(err)
+	assert.Equal(&http.BasicAuth{Username: "bot", Password: "secret"}, auth)
+
+	getInput, err := os.ReadFile(filepath.Join(dir, "get"))
+	require.NoError(err)
+	assert.Equal("protocol=https\nhost=gitlab.com\npath=jpallari/otk.git\n\n", string(getInput))
+
+	provider.reject(ctx, auth, errors.New("network down"))
+	provider.reject(ctx, auth, transport.ErrAuthorizationFailed)
+	provider.approve(ctx, auth)
+	auth, err = provider.get(ctx)
+	require.NoError(err)
+	provider.approve(ctx, auth)
+
+	actions, err := os.ReadFile(filepath.Join(dir, "actions"))
+	require.NoError(err)
+	assert.Equal(
+		"get\nerase\nstore\nget\n",
+		string(actions),
+		"only auth failures erase credentials, and only fresh credentials are stored",
+	)
+	eraseInput, err := os.ReadFile(filepath.Join(dir, "erase"))
+	require.NoError(err)
+	assert.Equal(
+		"protocol=https\nhost=gitlab.com\npath=jpallari/otk.git\nusername=bot\npassword=secret\n\n",
+		string(eraseInput),
+	)
+}
+
+func TestCredentialHelperFailure(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	var logs bytes.Buffer
+	ctx := logging.AddToContext(context.Background(), slog.New(slog.NewTextHandler(&logs, nil)))
+	repoConfig := config.Repository{
+		URL: "https://gitlab.com/jpallari/otk.git",
+		Credentials: config.Credentials{
+			CredentialHelper: config.CredentialHelper{
+				Command: "/bin/sh",
+				Args:    []string{"-c", "echo 'no credentials'; cat >&2; exit 1", "helper"},
+			},
+		},
+	}
+
+	var provider authProvider
+	require.NoError(provider.init(&osenv.OsEnv{Fs: memfs.New()}, "otk-gitlab", &repoConfig, slog.Default()))
+	_, err := provider.get(ctx)
+	var credentialsErr *CredentialsError
+	require.ErrorAs(err, &credentialsErr)
+	assert.ErrorContains(err, "credential helper '/bin/sh' failed to get credentials: exit status 1")
+
+	provider.reject(ctx, &http.BasicAuth{Username: "bot", Password: "secret"}, transport.ErrAuthorizationFailed)
+	assert.Contains(logs.String(), "host=gitlab.com")
+	assert.Contains(logs.String(), "password=[REDACTED]")
+	assert.NotContains(logs.String(), "secret", "stderr is redacted")
+}
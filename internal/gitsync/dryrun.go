@@ -1,11 +1,21 @@
 package gitsync
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"strings"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
 	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/logging"
+	"go.lepovirta.org/otk/internal/osenv"
 )
 
 const (
@@ -14,17 +24,21 @@ const (
 )
 
 func dryRun(
+	ctx context.Context,
 	out io.Writer,
+	osEnv *osenv.OsEnv,
 	cfg *config.Config,
 ) error {
-	if err := dryRun_(out, cfg); err != nil {
+	if err := dryRun_(ctx, out, osEnv, cfg); err != nil {
 		return fmt.Errorf("failed to write dry run info: %w", err)
 	}
 	return nil
 }
 
 func dryRun_(
+	ctx context.Context,
 	out io.Writer,
+	osEnv *osenv.OsEnv,
 	cfg *config.Config,
 ) (err error) {
 	_, err = fmt.Fprintln(out, "!! DRY RUN !! Use flag -run to sync the following Git repos")
@@ -62,6 +76,34 @@ func dryRun_(
 				return
 			}
 		}
+		_, err = fmt.Fprintf(
+			out, "%s schedule = %s\n",
+			syncSubHeader,
+			m.ScheduleString(),
+		)
+		if err != nil {
+			return
+		}
+		if len(m.Windows) > 0 {
+			_, err = fmt.Fprintf(
+				out, "%s windows = %s\n",
+				syncSubHeader,
+				timeWindowsString(m.Windows),
+			)
+			if err != nil {
+				return
+			}
+		}
+		if len(m.Blackouts) > 0 {
+			_, err = fmt.Fprintf(
+				out, "%s blackouts = %s\n",
+				syncSubHeader,
+				timeWindowsString(m.Blackouts),
+			)
+			if err != nil {
+				return
+			}
+		}
 		if len(m.Branches) > 0 {
 			branches := make([]string, 0, len(m.Branches))
 			for _, branch := range m.Branches {
@@ -90,10 +132,203 @@ func dryRun_(
 				return
 			}
 		}
+		if len(m.Refs) > 0 {
+			refs := make([]string, 0, len(m.Refs))
+			for _, ref := range m.Refs {
+				refs = append(refs, ref.String())
+			}
+			_, err = fmt.Fprintf(
+				out, "%s refs = %s\n",
+				syncSubHeader,
+				strings.Join(refs, ","),
+			)
+			if err != nil {
+				return
+			}
+		}
+		if m.Prune {
+			err = dryRunPrune(ctx, out, osEnv, cfg.Repositories, &m)
+			if err != nil {
+				return
+			}
+		}
+		if m.UpdatePolicy != config.UpdatePolicyUndefined {
+			_, err = fmt.Fprintf(
+				out, "%s updatePolicy = %s\n",
+				syncSubHeader,
+				m.UpdatePolicy,
+			)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// dryRunPrune prints the refs that would be deleted from the targets.
+// The refs are listed from the source and the target repositories,
+// but nothing is fetched or pushed.
+func dryRunPrune(
+	ctx context.Context,
+	out io.Writer,
+	osEnv *osenv.OsEnv,
+	repoConfigs map[string]config.Repository,
+	mapping *config.SyncMapping,
+) (err error) {
+	_, err = fmt.Fprintf(out, "%s prune = true\n", syncSubHeader)
+	if err != nil {
+		return
+	}
+	prunedRefs, pruneErrs, listErr := listPrunableRefs(ctx, osEnv, repoConfigs, mapping)
+	if listErr != nil {
+		_, err = fmt.Fprintf(
+			out, "%s pruned refs are unknown: %s\n",
+			syncSubHeader, listErr,
+		)
+		return
+	}
+	for _, target := range mapping.Targets {
+		var refs string
+		switch {
+		case pruneErrs[target] != nil:
+			refs = fmt.Sprintf("unknown: %s", pruneErrs[target])
+		case len(prunedRefs[target]) == 0:
+			refs = "none"
+		default:
+			refs = strings.Join(prunedRefs[target], ", ")
+		}
+		_, err = fmt.Fprintf(
+			out, "%s pruned from %s = %s\n",
+			syncSubHeader, target, refs,
+		)
+		if err != nil {
+			return
+		}
 	}
 	return
 }
 
+// listPrunableRefs lists the refs that the sync would delete from each
+// target of the mapping. The refs that failed to be listed from a target
+// are reported in the target errors. The error is returned when
+// the source refs can't be listed or mapped.
+func listPrunableRefs(
+	ctx context.Context,
+	osEnv *osenv.OsEnv,
+	repoConfigs map[string]config.Repository,
+	mapping *config.SyncMapping,
+) (prunedRefs map[string][]string, targetErrs map[string]error, err error) {
+	gs := GitSync{repoConfigs: repoConfigs, mapping: mapping}
+
+	// The remotes are set up in a throwaway repo,
+	// so that the dry run doesn't modify any local repos.
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sourceConfig := repoConfigs[mapping.Source]
+	var refs []*plumbing.Reference
+	if sourceConfig.URL != "" {
+		refs, err = dryRunListRefs(ctx, osEnv, repo, mapping.Source, &sourceConfig)
+	} else {
+		refs, err = dryRunLocalRefs(osEnv, &sourceConfig)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list source refs: %w", err)
+	}
+	var selected sourceRefs
+	for _, ref := range refs {
+		gs.selectRef(&selected, ref)
+	}
+	updates, err := gs.mapRefs(&selected)
+	if err != nil {
+		// The sync skips pruning when the ref mapping fails
+		return nil, nil, fmt.Errorf("pruning is skipped: %w", err)
+	}
+
+	prunedRefs = make(map[string][]string, len(mapping.Targets))
+	targetErrs = make(map[string]error, len(mapping.Targets))
+	for _, targetId := range mapping.Targets {
+		targetConfig := repoConfigs[targetId]
+		refs, err := dryRunListRefs(ctx, osEnv, repo, targetId, &targetConfig)
+		if err != nil {
+			targetErrs[targetId] = err
+			continue
+		}
+		targetRefs := make(map[string]plumbing.Hash, len(refs))
+		for _, ref := range refs {
+			if ref.Type() == plumbing.HashReference {
+				targetRefs[ref.Name().String()] = ref.Hash()
+			}
+		}
+		prunedRefs[targetId] = gs.getPrunableRefs(targetRefs, updates)
+	}
+	return prunedRefs, targetErrs, nil
+}
+
+// dryRunListRefs lists the refs in the remote repository.
+func dryRunListRefs(
+	ctx context.Context,
+	osEnv *osenv.OsEnv,
+	repo *git.Repository,
+	repoId string,
+	repoConfig *config.Repository,
+) ([]*plumbing.Reference, error) {
+	log := logging.FromContext(ctx).With(slog.String("repoId", repoId))
+
+	var auth authProvider
+	if err := auth.init(osEnv, repoId, repoConfig, log); err != nil {
+		return nil, fmt.Errorf("failed to configure auth: %w", err)
+	}
+	authMethod, err := auth.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := prepareRemote(ctx, repo, repoId, repoConfig, log); err != nil {
+		return nil, err
+	}
+	remote, err := repo.Remote(repoId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote '%s': %w", repoId, err)
+	}
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: authMethod})
+	if err == transport.ErrEmptyRemoteRepository {
+		return nil, nil
+	}
+	return refs, err
+}
+
+// dryRunLocalRefs lists the refs in the local source repository
+// without initializing it.
+func dryRunLocalRefs(
+	osEnv *osenv.OsEnv,
+	repoConfig *config.Repository,
+) ([]*plumbing.Reference, error) {
+	if repoConfig.LocalPath == "" || repoConfig.IsInMemory() {
+		return nil, nil
+	}
+	pathFs, err := osEnv.Fs.Chroot(repoConfig.LocalPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to chroot path '%s': %w", repoConfig.LocalPath, err)
+	}
+	repo, err := git.Open(filesystem.NewStorage(pathFs, cache.NewObjectLRUDefault()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open path %s: %w", repoConfig.LocalPath, err)
+	}
+	refIter, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("local ref iterator error: %w", err)
+	}
+	refs := make([]*plumbing.Reference, 0, 10)
+	_ = refIter.ForEach(func(ref *plumbing.Reference) error {
+		refs = append(refs, ref)
+		return nil
+	})
+	return refs, nil
+}
+
 func authMethodString(authMethod config.AuthMethod) string {
 	s := authMethod.String()
 	if s == "" {
@@ -101,3 +336,11 @@ func authMethodString(authMethod config.AuthMethod) string {
 	}
 	return s
 }
+
+func timeWindowsString(windows []config.TimeWindow) string {
+	strs := make([]string, len(windows))
+	for i := range windows {
+		strs[i] = windows[i].String()
+	}
+	return strings.Join(strs, "; ")
+}
@@ -2,14 +2,22 @@ package gitsync
 
 import (
 	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/cron"
 	"go.lepovirta.org/otk/internal/duration"
 	"go.lepovirta.org/otk/internal/gitsync/config"
 	"go.lepovirta.org/otk/internal/matcher"
+	"go.lepovirta.org/otk/internal/osenv"
 )
 
 var testConfig = config.Config{
@@ -17,11 +25,11 @@ var testConfig = config.Config{
 		"otk-github": {
 			Credentials: config.Credentials{
 				SshCredentials: config.SshCredentials{
-					UseAgent: true,
+					UseAgent: new(true),
 				},
 			},
 			URL:      "ssh://github.com:jpallari/otk.git",
-			InMemory: true,
+			InMemory: new(true),
 		},
 		"keruu-github": {
 			Credentials: config.Credentials{
@@ -58,7 +66,7 @@ var testConfig = config.Config{
 				SshCredentials: config.SshCredentials{
 					KeyPassword:   "ssh_key_password",
 					KeyPath:       "/home/testuser/.ssh/ssh-key.ed25519",
-					IgnoreHostKey: true,
+					IgnoreHostKey: new(true),
 				},
 			},
 			URL: "ssh://192.168.100.69/srv/git/keruu.git",
@@ -96,6 +104,10 @@ var testConfig = config.Config{
 					matcher.FromStringOrPanic(`/main.*/`),
 				},
 				Tags: []matcher.M{},
+				Refs: []matcher.M{
+					matcher.FromStringOrPanic("refs/notes/commits"),
+					matcher.FromStringOrPanic(`/^refs/pull/.*/`),
+				},
 			},
 		},
 		{
@@ -109,6 +121,26 @@ var testConfig = config.Config{
 				Tags: []matcher.M{
 					matcher.FromStringOrPanic(`/release-.*/`),
 				},
+				UpdatePolicy: config.UpdatePolicyFastForwardOnly,
+			},
+		},
+		{
+			Id:      "yahe-nightly",
+			Source:  "yahe-github",
+			Targets: []string{"yahe-gitlab"},
+			SyncSpec: config.SyncSpec{
+				Schedule: cron.MustParse("0 2 * * 1-5"),
+				TimeZone: "UTC",
+				Jitter:   duration.New(5 * time.Minute),
+				Windows: []config.TimeWindow{
+					{Days: []string{"mon-fri"}, Start: "01:00", End: "05:00"},
+				},
+				Blackouts: []config.TimeWindow{
+					{From: "2026-12-20", Until: "2027-01-06"},
+				},
+				Branches: []matcher.M{
+					matcher.FromStringOrPanic("develop"),
+				},
 			},
 		},
 	},
@@ -119,6 +151,7 @@ const testConfigDryRunText = `!! DRY RUN !! Use flag -run to sync the following
 sync: otk-github --> otk-gitlab
       otk-github = ssh://github.com:jpallari/otk.git (auth: ssh-agent)
       otk-gitlab = ssh://gitlab.com:gitlabuser/otk.git (auth: ssh)
+      schedule = every 1h0m0s
       branches = main
       tags = /v.*/
 
@@ -126,13 +159,25 @@ sync: keruu-github --> keruu-gitlab, keruu-ssh
       keruu-github = https://github.com/jpallari/keruu.git (auth: http)
       keruu-gitlab = https://gitlab.com/gitlabuser/keruu.git (auth: http-token)
       keruu-ssh = ssh://192.168.100.69/srv/git/keruu.git (auth: ssh)
+      schedule = every 6h0m0s
       branches = /main.*/
+      refs = refs/notes/commits,/^refs/pull/.*/
 
 sync: yahe-github --> yahe-gitlab
       yahe-github = https://github.com/jpallari/yahe.git (auth: none)
       yahe-gitlab = https://gitlab.com/gitlabuser/yahe.git (auth: http)
+      schedule = every 48h0m0s
       branches = main
       tags = /release-.*/
+      updatePolicy = fast-forward-only
+
+sync: yahe-github --> yahe-gitlab
+      yahe-github = https://github.com/jpallari/yahe.git (auth: none)
+      yahe-gitlab = https://gitlab.com/gitlabuser/yahe.git (auth: http)
+      schedule = 0 2 * * 1-5 (UTC) with jitter up to 5m0s
+      windows = mon-fri 01:00-05:00
+      blackouts = from 2026-12-20 until 2027-01-06
+      branches = develop
 `
 
 func TestDryRun(t *testing.T) {
@@ -142,7 +187,64 @@ func TestDryRun(t *testing.T) {
 	var out bytes.Buffer
 	out.Grow(2 * 1024)
 
-	require.NoError(dryRun(&out, &testConfig), "dry run")
+	require.NoError(dryRun(context.Background(), &out, &osenv.OsEnv{Fs: memfs.New()}, &testConfig), "dry run")
 
 	assert.Equal(testConfigDryRunText, out.String())
 }
+
+func TestDryRunPrune(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	sourceURL := t.TempDir()
+	sourceRepo, err := git.PlainInit(sourceURL, true)
+	require.NoError(err, "git init source")
+	first := commitTo(t, sourceRepo, "first")
+	require.NoError(sourceRepo.Storer.SetReference(
+		plumbing.NewHashReference("refs/heads/main", first),
+	))
+
+	targetURL := t.TempDir()
+	targetRepo, err := git.PlainInit(targetURL, true)
+	require.NoError(err, "git init target")
+	for _, branch := range []string{"main", "old", "feature"} {
+		require.NoError(targetRepo.Storer.SetReference(
+			plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), first),
+		))
+	}
+	require.NoError(targetRepo.Storer.SetReference(
+		plumbing.NewHashReference("refs/tags/v1.0", first),
+	))
+
+	cfg := config.Config{
+		Repositories: map[string]config.Repository{
+			"source":  {URL: sourceURL},
+			"target":  {URL: targetURL},
+			"missing": {URL: filepath.Join(t.TempDir(), "missing")},
+		},
+		Mappings: []config.SyncMapping{
+			{
+				Source:  "source",
+				Targets: []string{"target", "missing"},
+				SyncSpec: config.SyncSpec{
+					Branches: []matcher.M{
+						matcher.FromStringOrPanic("main"),
+						matcher.FromStringOrPanic("old"),
+					},
+					Tags: []matcher.M{
+						matcher.FromStringOrPanic(`/^v/`),
+					},
+					Prune: true,
+				},
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	require.NoError(dryRun(context.Background(), &out, &osenv.OsEnv{Fs: memfs.New()}, &cfg), "dry run")
+
+	lines := strings.Split(out.String(), "\n")
+	assert.Contains(lines, "      prune = true")
+	assert.Contains(lines, "      pruned from target = refs/heads/old, refs/tags/v1.0")
+	assert.Contains(out.String(), "      pruned from missing = unknown: ")
+}
@@ -0,0 +1,190 @@
+package gitsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+)
+
+const (
+	// execCredentialsExpiryMargin is how long before the expiry
+	// the cached credentials are refreshed.
+	execCredentialsExpiryMargin = time.Minute
+
+	redactedText = "[REDACTED]"
+)
+
+// execOutput is the JSON object printed by the credentials command.
+type execOutput struct {
+	Token         string    `json:"token"`
+	Username      string    `json:"username"`
+	Password      string    `json:"password"`
+	SshPrivateKey string    `json:"sshPrivateKey"`
+	ExpiresAt     time.Time `json:"expiresAt"`
+}
+
+// execCredentials runs a command for acquiring the credentials of a repository.
+// The credentials are cached until shortly before they expire.
+type execCredentials struct {
+	command        string
+	args           []string
+	env            []string
+	envSecrets     []string
+	sshCredentials config.SshCredentials
+
+	mu        sync.Mutex
+	auth      transport.AuthMethod
+	expiresAt time.Time
+}
+
+// init configures the command. The command is run with the given
+// environment variables and the variables from the config.
+func (e *execCredentials) init(
+	execConfig *config.ExecCredentials,
+	sshCredentials config.SshCredentials,
+	env []string,
+) {
+	e.command = execConfig.Command
+	e.args = execConfig.Args
+	e.sshCredentials = sshCredentials
+	e.env = slices.Clone(env)
+	for key, value := range execConfig.Env {
+		e.env = append(e.env, key+"="+value)
+		e.envSecrets = append(e.envSecrets, value)
+	}
+}
+
+// get returns the cached credentials, or runs the command when
+// there are no cached credentials or they are about to expire.
+func (e *execCredentials) get(ctx context.Context, log *slog.Logger) (transport.AuthMethod, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.auth != nil && time.Now().Add(execCredentialsExpiryMargin).Before(e.expiresAt) {
+		return e.auth, nil
+	}
+	e.auth = nil
+
+	out, err := e.run(ctx, log)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := e.outputToAuth(out, log)
+	if err != nil {
+		return nil, err
+	}
+	log.DebugContext(
+		ctx, "credentials resolved",
+		slog.String("authMethod", config.AuthMethodExec.String()),
+		slog.Time("expiresAt", out.ExpiresAt),
+	)
+	if !out.ExpiresAt.IsZero() {
+		e.auth = auth
+		e.expiresAt = out.ExpiresAt
+	}
+	return auth, nil
+}
+
+// invalidate removes the cached credentials.
+func (e *execCredentials) invalidate() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.auth = nil
+}
+
+func (e *execCredentials) run(ctx context.Context, log *slog.Logger) (*execOutput, error) {
+	cmd := exec.CommandContext(ctx, e.command, e.args...)
+	cmd.Env = e.env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, runErr := cmd.Output()
+
+	var out execOutput
+	var parseErr error
+	if runErr == nil {
+		parseErr = json.Unmarshal(stdout, &out)
+	}
+	e.logStderr(ctx, log, &stderr, &out)
+
+	if runErr != nil {
+		return nil, fmt.Errorf("credentials command '%s' failed: %w", e.command, runErr)
+	}
+	if parseErr != nil {
+		// The parse error may contain parts of the output, so it's left out.
+		return nil, fmt.Errorf("failed to parse output of credentials command '%s' as JSON", e.command)
+	}
+	return &out, nil
+}
+
+// logStderr writes the lines of the command's standard error to the log
+// with the known secrets redacted.
+func (e *execCredentials) logStderr(
+	ctx context.Context,
+	log *slog.Logger,
+	stderr *bytes.Buffer,
+	out *execOutput,
+) {
+	secrets := append(
+		slices.Clone(e.envSecrets),
+		out.Token, out.Password, out.SshPrivateKey,
+	)
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		log.InfoContext(
+			ctx, "credentials command output",
+			slog.String("command", e.command),
+			slog.String("stderr", redact(line, secrets)),
+		)
+	}
+}
+
+func (e *execCredentials) outputToAuth(out *execOutput, log *slog.Logger) (transport.AuthMethod, error) {
+	switch {
+	case out.SshPrivateKey != "":
+		sshCredentials := e.sshCredentials
+		if out.Username != "" {
+			sshCredentials.Username = out.Username
+		}
+		return sshPublicKeysAuth(sshCredentials, []byte(out.SshPrivateKey), out.Password, log)
+	case out.Token != "" && out.Username != "":
+		return &http.BasicAuth{Username: out.Username, Password: out.Token}, nil
+	case out.Token != "":
+		return &http.TokenAuth{Token: out.Token}, nil
+	case out.Password != "":
+		return &http.BasicAuth{Username: out.Username, Password: out.Password}, nil
+	default:
+		return nil, errors.New("no credentials in the output of the credentials command")
+	}
+}
+
+// redact replaces the secrets in the text. The text is logged one line
+// at a time, so the secrets spanning many lines (e.g. SSH keys) are
+// redacted line by line.
+func redact(text string, secrets []string) string {
+	for _, secret := range secrets {
+		for line := range strings.Lines(secret) {
+			line = strings.TrimSpace(line)
+			if line != "" {
+				text = strings.ReplaceAll(text, line, redactedText)
+			}
+		}
+	}
+	return text
+}
@@ -0,0 +1,148 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/envvar"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/logging"
+	"go.lepovirta.org/otk/internal/osenv"
+)
+
+const execCredentialsScript = `#!/bin/sh
+echo run >> "$LOG_DIR/runs"
+echo "issuing token $TOKEN for $1 with key $BROKER_KEY" >&2
+printf '{"token": "%s", "username": "x-access-token", "expiresAt": "%s"}' "$TOKEN" "$EXPIRES_AT"
+`
+
+func execCredentialsProvider(t *testing.T, expiresAt string) (*authProvider, string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "broker.sh")
+	require.NoError(t, os.WriteFile(script, []byte(execCredentialsScript), 0o700))
+	var envVars envvar.Vars
+	envVars.FromMap(map[string]string{"LOG_DIR": dir})
+	repoConfig := config.Repository{
+		URL: "https://gitlab.com/jpallari/otk.git",
+		Credentials: config.Credentials{
+			Exec: config.ExecCredentials{
+				Command: script,
+				Args:    []string{"otk"},
+				Env: map[string]string{
+					"TOKEN":      "short_lived_token",
+					"BROKER_KEY": "broker_key",
+					"EXPIRES_AT": expiresAt,
+				},
+			},
+		},
+	}
+	var provider authProvider
+	osEnv := osenv.OsEnv{Fs: memfs.New(), EnvVars: envVars}
+	require.NoError(t, provider.init(&osEnv, "otk-gitlab", &repoConfig, slog.Default()))
+	return &provider, dir
+}
+
+func execCredentialsRuns(t *testing.T, dir string) int {
+	t.Helper()
+	runs, err := os.ReadFile(filepath.Join(dir, "runs"))
+	require.NoError(t, err)
+	return strings.Count(string(runs), "run")
+}
+
+func TestExecCredentialsCached(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	var logs bytes.Buffer
+	ctx := logging.AddToContext(context.Background(), slog.New(slog.NewTextHandler(&logs, nil)))
+	provider, dir := execCredentialsProvider(t, time.Now().Add(time.Hour).Format(time.RFC3339))
+
+	auth, err := provider.get(ctx)
+	require.NoError(err)
+	assert.Equal(&http.BasicAuth{Username: "x-access-token", Password: "short_lived_token"}, auth)
+	_, err = provider.get(ctx)
+	require.NoError(err)
+	assert.Equal(1, execCredentialsRuns(t, dir), "credentials are cached")
+
+	assert.Contains(logs.String(), "issuing token [REDACTED] for otk with key [REDACTED]")
+	assert.NotContains(logs.String(), "short_lived_token")
+	assert.NotContains(logs.String(), "broker_key")
+
+	provider.reject(ctx, auth, transport.ErrAuthorizationFailed)
+	_, err = provider.get(ctx)
+	require.NoError(err)
+	assert.Equal(2, execCredentialsRuns(t, dir), "rejected credentials are not cached")
+}
+
+func TestExecCredentialsExpiring(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+	provider, dir := execCredentialsProvider(t, time.Now().Add(30*time.Second).Format(time.RFC3339))
+
+	_, err := provider.get(ctx)
+	require.NoError(err)
+	_, err = provider.get(ctx)
+	require.NoError(err)
+	assert.Equal(t, 2, execCredentialsRuns(t, dir), "credentials about to expire are refreshed")
+}
+
+func TestExecCredentialsRedactsMultiLineSecrets(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	var logs bytes.Buffer
+	ctx := logging.AddToContext(context.Background(), slog.New(slog.NewTextHandler(&logs, nil)))
+	repoConfig := config.Repository{
+		URL: "ssh://git@gitlab.com/jpallari/otk.git",
+		Credentials: config.Credentials{
+			Exec: config.ExecCredentials{
+				Command: "/bin/sh",
+				Args: []string{"-c", `
+printf 'issuing key\nkey_line_one\nkey_line_two\n' >&2
+printf '{"sshPrivateKey": "key_line_one\\nkey_line_two\\n"}'
+`},
+			},
+		},
+	}
+
+	var provider authProvider
+	require.NoError(provider.init(&osenv.OsEnv{Fs: memfs.New()}, "otk-gitlab", &repoConfig, slog.Default()))
+	_, err := provider.get(ctx)
+	require.Error(err, "key is not a valid SSH key")
+
+	assert.Contains(logs.String(), "stderr=\"issuing key\"")
+	assert.Contains(logs.String(), "stderr=[REDACTED]")
+	assert.NotContains(logs.String(), "key_line_one")
+	assert.NotContains(logs.String(), "key_line_two")
+}
+
+func TestExecCredentialsFailure(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	repoConfig := config.Repository{
+		URL: "https://gitlab.com/jpallari/otk.git",
+		Credentials: config.Credentials{
+			Exec: config.ExecCredentials{
+				Command: "/bin/sh",
+				Args:    []string{"-c", "echo not json"},
+			},
+		},
+	}
+
+	var provider authProvider
+	require.NoError(provider.init(&osenv.OsEnv{Fs: memfs.New()}, "otk-gitlab", &repoConfig, slog.Default()))
+	_, err := provider.get(context.Background())
+	var credentialsErr *CredentialsError
+	require.ErrorAs(err, &credentialsErr)
+	assert.ErrorContains(err, "failed to parse output of credentials command '/bin/sh' as JSON")
+}
@@ -0,0 +1,203 @@
+package gitsync
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	nethttp "net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+)
+
+const (
+	githubAppUsername   = "x-access-token"
+	githubApiVersion    = "2022-11-28"
+	githubApiTimeout    = 30 * time.Second
+	githubAppJwtExpiry  = 9 * time.Minute
+	githubAppClockSkew  = time.Minute
+	githubErrorBodySize = 1024
+
+	// githubAppExpiryMargin is how long before the expiry
+	// the cached installation access token is refreshed.
+	githubAppExpiryMargin = 5 * time.Minute
+)
+
+// githubApp acquires installation access tokens for a GitHub App installation.
+// The tokens are cached until shortly before they expire.
+type githubApp struct {
+	fs             billy.Filesystem
+	client         *nethttp.Client
+	appId          int64
+	installationId int64
+	privateKeyPath string
+	apiURL         string
+
+	mu        sync.Mutex
+	auth      *http.BasicAuth
+	expiresAt time.Time
+}
+
+func (g *githubApp) init(
+	fs billy.Filesystem,
+	httpTransport nethttp.RoundTripper,
+	appConfig *config.GitHubApp,
+) {
+	g.fs = fs
+	g.client = &nethttp.Client{
+		Transport: httpTransport,
+		Timeout:   githubApiTimeout,
+	}
+	g.appId = appConfig.AppId
+	g.installationId = appConfig.InstallationId
+	g.privateKeyPath = appConfig.PrivateKeyPath
+	g.apiURL = appConfig.APIBaseURL()
+}
+
+// get returns the cached installation access token, or requests a new one when
+// there's no cached token or it's about to expire.
+func (g *githubApp) get(ctx context.Context, log *slog.Logger) (transport.AuthMethod, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.auth != nil && time.Now().Add(githubAppExpiryMargin).Before(g.expiresAt) {
+		return g.auth, nil
+	}
+	g.auth = nil
+
+	jwt, err := g.signJwt(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	token, expiresAt, err := g.requestToken(ctx, jwt)
+	if err != nil {
+		return nil, err
+	}
+	log.DebugContext(
+		ctx, "credentials resolved",
+		slog.String("authMethod", config.AuthMethodGitHubApp.String()),
+		slog.Time("expiresAt", expiresAt),
+	)
+	g.auth = &http.BasicAuth{Username: githubAppUsername, Password: token}
+	g.expiresAt = expiresAt
+	return g.auth, nil
+}
+
+// invalidate removes the cached installation access token.
+func (g *githubApp) invalidate() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.auth = nil
+}
+
+// signJwt creates a JWT for authenticating as the GitHub App.
+// The private key is read on every call, so that rotated keys are picked up.
+func (g *githubApp) signJwt(now time.Time) (string, error) {
+	keyBytes, err := util.ReadFile(g.fs, g.privateKeyPath)
+	if err != nil {
+		return "", fmt.Errorf(
+			"failed to read GitHub App private key from path '%s': %w",
+			g.privateKeyPath,
+			err,
+		)
+	}
+	key, err := parseRsaPrivateKey(keyBytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse GitHub App private key: %w", err)
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		// Issued in the past to allow for clock drift
+		"iat": now.Add(-githubAppClockSkew).Unix(),
+		"exp": now.Add(githubAppJwtExpiry).Unix(),
+		"iss": strconv.FormatInt(g.appId, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign GitHub App JWT: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// requestToken exchanges the JWT for an installation access token.
+func (g *githubApp) requestToken(ctx context.Context, jwt string) (string, time.Time, error) {
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", g.apiURL, g.installationId)
+	req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodPost, url, nil)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("X-GitHub-Api-Version", githubApiVersion)
+
+	res, err := g.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to request installation access token: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, githubErrorBodySize))
+		return "", time.Time{}, fmt.Errorf(
+			"unexpected status code %d from '%s': %s",
+			res.StatusCode, url, body,
+		)
+	}
+
+	var tokenRes struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokenRes); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to parse installation access token response: %w", err)
+	}
+	if tokenRes.Token == "" {
+		return "", time.Time{}, errors.New("no token in installation access token response")
+	}
+	return tokenRes.Token, tokenRes.ExpiresAt, nil
+}
+
+// parseRsaPrivateKey parses a RSA private key in PKCS #1 or PKCS #8 PEM format.
+func parseRsaPrivateKey(keyBytes []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyBytes)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("expected a RSA private key")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,154 @@
+package gitsync
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	fsutil "github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/osenv"
+)
+
+// githubApiStandIn serves installation access tokens that expire after the given duration.
+// The JWT in the requests is verified using the given public key.
+func githubApiStandIn(t *testing.T, publicKey *rsa.PublicKey, expiresIn time.Duration) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		n := requests.Add(1)
+		if r.Method != nethttp.MethodPost || r.URL.Path != "/api/v3/app/installations/42/access_tokens" {
+			w.WriteHeader(nethttp.StatusNotFound)
+			return
+		}
+		jwt, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		parts := strings.Split(jwt, ".")
+		if !ok || len(parts) != 3 {
+			w.WriteHeader(nethttp.StatusUnauthorized)
+			return
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) != nil {
+			w.WriteHeader(nethttp.StatusUnauthorized)
+			return
+		}
+		claimsJson, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var claims struct {
+			Iss string `json:"iss"`
+			Iat int64  `json:"iat"`
+			Exp int64  `json:"exp"`
+		}
+		_ = json.Unmarshal(claimsJson, &claims)
+		now := time.Now().Unix()
+		if claims.Iss != "1234" || claims.Iat > now || claims.Exp < now {
+			w.WriteHeader(nethttp.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(nethttp.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"token":      fmt.Sprintf("ghs_token%d", n),
+			"expires_at": time.Now().Add(expiresIn).UTC().Format(time.RFC3339),
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func githubAppKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func githubAppProvider(t *testing.T, key *rsa.PrivateKey, apiURL string) *authProvider {
+	t.Helper()
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	fs := memfs.New()
+	require.NoError(t, fsutil.WriteFile(fs, "/run/secrets/github-app.pem", keyPem, 0o600))
+	repoConfig := config.Repository{
+		URL: "https://github.com/jpallari/otk.git",
+		Credentials: config.Credentials{
+			GitHubApp: config.GitHubApp{
+				AppId:          1234,
+				InstallationId: 42,
+				PrivateKeyPath: "/run/secrets/github-app.pem",
+				ApiURL:         apiURL,
+			},
+		},
+	}
+	var provider authProvider
+	osEnv := osenv.OsEnv{Fs: fs, HttpTransport: nethttp.DefaultTransport}
+	require.NoError(t, provider.init(&osEnv, "otk-github", &repoConfig, slog.Default()))
+	return &provider
+}
+
+func TestGitHubApp(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	ctx := context.Background()
+	key := githubAppKey(t)
+	server, requests := githubApiStandIn(t, &key.PublicKey, time.Hour)
+	provider := githubAppProvider(t, key, server.URL+"/api/v3/")
+
+	auth, err := provider.get(ctx)
+	require.NoError(err)
+	assert.Equal(&http.BasicAuth{Username: "x-access-token", Password: "ghs_token1"}, auth)
+	auth, err = provider.get(ctx)
+	require.NoError(err)
+	assert.Equal("ghs_token1", auth.(*http.BasicAuth).Password, "token is cached")
+	assert.Equal(int32(1), requests.Load())
+
+	provider.reject(ctx, auth, transport.ErrAuthenticationRequired)
+	auth, err = provider.get(ctx)
+	require.NoError(err)
+	assert.Equal("ghs_token2", auth.(*http.BasicAuth).Password, "rejected token is not cached")
+}
+
+func TestGitHubAppRefresh(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	ctx := context.Background()
+	key := githubAppKey(t)
+	server, _ := githubApiStandIn(t, &key.PublicKey, 2*time.Minute)
+	provider := githubAppProvider(t, key, server.URL+"/api/v3")
+
+	auth, err := provider.get(ctx)
+	require.NoError(err)
+	assert.Equal("ghs_token1", auth.(*http.BasicAuth).Password)
+	auth, err = provider.get(ctx)
+	require.NoError(err)
+	assert.Equal("ghs_token2", auth.(*http.BasicAuth).Password, "token about to expire is refreshed")
+}
+
+func TestGitHubAppFailure(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	server, _ := githubApiStandIn(t, &githubAppKey(t).PublicKey, time.Hour)
+	provider := githubAppProvider(t, githubAppKey(t), server.URL+"/api/v3")
+
+	_, err := provider.get(context.Background())
+	var credentialsErr *CredentialsError
+	require.ErrorAs(err, &credentialsErr)
+	assert.ErrorContains(err, "unexpected status code 401")
+}
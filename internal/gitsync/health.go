@@ -0,0 +1,157 @@
+package gitsync
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.lepovirta.org/otk/internal/gitsync/config"
+)
+
+const (
+	healthPath              = "/healthz"
+	readinessPath           = "/readyz"
+	defaultLivenessDeadline = time.Hour
+	healthStatusOk          = "ok"
+	healthStatusFail        = "fail"
+)
+
+// loopStatus tracks the progress of a sync running in a loop.
+type loopStatus struct {
+	mu             sync.Mutex
+	initialized    bool
+	attempts       int
+	iterationStart time.Time
+	lastAttemptEnd time.Time
+	deferredUntil  time.Time
+}
+
+func (s *loopStatus) setInitialized() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.initialized = true
+}
+
+func (s *loopStatus) startIteration(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.iterationStart = now
+}
+
+// setDeferred marks the sync deferred until the given time
+// because it's outside of the sync windows.
+func (s *loopStatus) setDeferred(until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deferredUntil = until
+}
+
+func (s *loopStatus) endIteration(now time.Time, attempted bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.iterationStart = time.Time{}
+	s.deferredUntil = time.Time{}
+	if attempted {
+		s.attempts++
+		s.lastAttemptEnd = now
+	}
+}
+
+// mappingHealth describes the health of a single mapping.
+type mappingHealth struct {
+	Id             string     `json:"id"`
+	Source         string     `json:"source"`
+	Targets        []string   `json:"targets"`
+	Initialized    bool       `json:"initialized"`
+	Attempts       int        `json:"attempts"`
+	LastAttemptEnd *time.Time `json:"lastAttemptEnd,omitempty"`
+	IterationStart *time.Time `json:"iterationStart,omitempty"`
+	DeferredUntil  *time.Time `json:"deferredUntil,omitempty"`
+	Live           bool       `json:"live"`
+	Ready          bool       `json:"ready"`
+}
+
+type healthResponse struct {
+	Status   string          `json:"status"`
+	Mappings []mappingHealth `json:"mappings"`
+}
+
+// healthHandler reports the liveness or the readiness of the syncs.
+// A sync is live unless it has been stuck in a single iteration for longer
+// than the deadline. A sync is ready after it has been initialized and
+// it has attempted to sync at least once or it has been deferred
+// because it's outside of the sync windows.
+type healthHandler struct {
+	mappings  []config.SyncMapping
+	gitSyncs  []*GitSync
+	deadline  time.Duration
+	readiness bool
+}
+
+func newHealthHandlers(cfg *config.Config, gitSyncs []*GitSync) (liveness, readiness *healthHandler) {
+	deadline := cfg.Server.LivenessDeadline.Duration
+	if deadline <= 0 {
+		deadline = defaultLivenessDeadline
+	}
+	liveness = &healthHandler{
+		mappings: cfg.Mappings,
+		gitSyncs: gitSyncs,
+		deadline: deadline,
+	}
+	readiness = &healthHandler{
+		mappings:  cfg.Mappings,
+		gitSyncs:  gitSyncs,
+		deadline:  deadline,
+		readiness: true,
+	}
+	return
+}
+
+func (h *healthHandler) check(now time.Time) healthResponse {
+	res := healthResponse{
+		Status:   healthStatusOk,
+		Mappings: make([]mappingHealth, len(h.mappings)),
+	}
+	for i, mapping := range h.mappings {
+		mh := &res.Mappings[i]
+		mh.Id = mapping.Id
+		mh.Source = mapping.Source
+		mh.Targets = mapping.Targets
+
+		status := &h.gitSyncs[i].status
+		status.mu.Lock()
+		mh.Initialized = status.initialized
+		mh.Attempts = status.attempts
+		if !status.lastAttemptEnd.IsZero() {
+			lastAttemptEnd := status.lastAttemptEnd
+			mh.LastAttemptEnd = &lastAttemptEnd
+		}
+		if !status.iterationStart.IsZero() {
+			iterationStart := status.iterationStart
+			mh.IterationStart = &iterationStart
+		}
+		if !status.deferredUntil.IsZero() {
+			deferredUntil := status.deferredUntil
+			mh.DeferredUntil = &deferredUntil
+		}
+		status.mu.Unlock()
+
+		mh.Live = mh.IterationStart == nil || now.Sub(*mh.IterationStart) <= h.deadline
+		mh.Ready = mh.Initialized && (mh.Attempts > 0 || mh.DeferredUntil != nil)
+
+		if (h.readiness && !mh.Ready) || (!h.readiness && !mh.Live) {
+			res.Status = healthStatusFail
+		}
+	}
+	return res
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	res := h.check(time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	if res.Status != healthStatusOk {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(&res)
+}
@@ -0,0 +1,68 @@
+package gitsync
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/duration"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	cfg := config.Config{
+		Mappings: []config.SyncMapping{
+			{Id: "otk-github->otk-gitlab", Source: "otk-github", Targets: []string{"otk-gitlab"}},
+			{Id: "keruu", Source: "keruu-github", Targets: []string{"keruu-gitlab"}},
+		},
+		Server: config.Server{
+			LivenessDeadline: duration.New(time.Minute),
+		},
+	}
+	gitSyncs := []*GitSync{{}, {}}
+	liveness, readiness := newHealthHandlers(&cfg, gitSyncs)
+	now := time.Now()
+
+	// Not initialized
+	assert.Equal(healthStatusOk, liveness.check(now).Status)
+	assert.Equal(healthStatusFail, readiness.check(now).Status)
+
+	// Only one mapping has attempted to sync
+	gitSyncs[0].status.setInitialized()
+	gitSyncs[1].status.setInitialized()
+	gitSyncs[0].status.startIteration(now.Add(-time.Second))
+	gitSyncs[0].status.endIteration(now, true)
+	gitSyncs[1].status.startIteration(now.Add(-time.Second))
+	assert.Equal(healthStatusOk, liveness.check(now).Status)
+	assert.Equal(healthStatusFail, readiness.check(now).Status)
+
+	// Second mapping is stuck
+	res := liveness.check(now.Add(2 * time.Minute))
+	assert.Equal(healthStatusFail, res.Status)
+	assert.True(res.Mappings[0].Live)
+	assert.False(res.Mappings[1].Live)
+
+	// All mappings have attempted to sync
+	gitSyncs[1].status.endIteration(now, true)
+	assert.Equal(healthStatusOk, liveness.check(now.Add(2*time.Minute)).Status)
+	assert.Equal(healthStatusOk, readiness.check(now).Status)
+
+	// JSON response
+	rec := httptest.NewRecorder()
+	readiness.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, readinessPath, nil))
+	assert.Equal(http.StatusOK, rec.Code)
+	var body healthResponse
+	require.NoError(json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(healthStatusOk, body.Status)
+	require.Len(body.Mappings, 2)
+	assert.Equal("keruu", body.Mappings[1].Id)
+	assert.Equal("keruu-github", body.Mappings[1].Source)
+	assert.Equal(1, body.Mappings[1].Attempts)
+	assert.True(body.Mappings[1].Ready)
+}
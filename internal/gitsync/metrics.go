@@ -0,0 +1,124 @@
+package gitsync
+
+import (
+	"net/http"
+	"time"
+
+	"go.lepovirta.org/otk/internal/metrics"
+)
+
+const (
+	labelMapping = "mapping"
+	labelSource  = "source"
+	labelTarget  = "target"
+	labelRefType = "type"
+)
+
+// syncMetrics contains the metrics of all of the syncs in the process.
+// The metrics are labeled with the mapping and repository IDs only, so that
+// URLs or credentials are never exposed in the metrics.
+var syncMetrics = newGitSyncMetrics(&metrics.Registry{})
+
+type gitSyncMetrics struct {
+	registry      *metrics.Registry
+	attempts      *metrics.CounterVec
+	successes     *metrics.CounterVec
+	failures      *metrics.CounterVec
+	lastSuccess   *metrics.GaugeVec
+	fetchDuration *metrics.HistogramVec
+	pushDuration  *metrics.HistogramVec
+	pushedRefs    *metrics.GaugeVec
+	matchedRefs   *metrics.GaugeVec
+}
+
+func newGitSyncMetrics(registry *metrics.Registry) *gitSyncMetrics {
+	return &gitSyncMetrics{
+		registry: registry,
+		attempts: registry.NewCounterVec(
+			"gitsync_sync_attempts_total",
+			"Number of sync attempts from the source to the target.",
+			labelMapping, labelSource, labelTarget,
+		),
+		successes: registry.NewCounterVec(
+			"gitsync_sync_successes_total",
+			"Number of successful syncs from the source to the target.",
+			labelMapping, labelSource, labelTarget,
+		),
+		failures: registry.NewCounterVec(
+			"gitsync_sync_failures_total",
+			"Number of failed syncs from the source to the target.",
+			labelMapping, labelSource, labelTarget,
+		),
+		lastSuccess: registry.NewGaugeVec(
+			"gitsync_last_success_timestamp_seconds",
+			"Unix timestamp of the last successful sync from the source to the target.",
+			labelMapping, labelSource, labelTarget,
+		),
+		fetchDuration: registry.NewHistogramVec(
+			"gitsync_fetch_duration_seconds",
+			"Duration of the fetches from the source including retries.",
+			metrics.DefaultBuckets,
+			labelSource,
+		),
+		pushDuration: registry.NewHistogramVec(
+			"gitsync_push_duration_seconds",
+			"Duration of the pushes to the target including retries.",
+			metrics.DefaultBuckets,
+			labelMapping, labelSource, labelTarget,
+		),
+		pushedRefs: registry.NewGaugeVec(
+			"gitsync_pushed_refs",
+			"Number of refs pushed to the target in the latest sync.",
+			labelMapping, labelSource, labelTarget,
+		),
+		matchedRefs: registry.NewGaugeVec(
+			"gitsync_matched_refs",
+			"Number of refs in the source matched by the mapping in the latest sync.",
+			labelMapping, labelSource, labelTarget, labelRefType,
+		),
+	}
+}
+
+func (m *gitSyncMetrics) handler() http.Handler {
+	return m.registry.Handler()
+}
+
+// recordResults records the sync outcome for each target of the sync.
+// All of the targets are considered failed when there's a source error.
+func (m *gitSyncMetrics) recordResults(
+	gs *GitSync,
+	targetIds []string,
+	sourceErr error,
+	targetErrs map[string][]error,
+) {
+	now := float64(time.Now().Unix())
+	for _, targetId := range targetIds {
+		m.attempts.Inc(gs.mapping.Id, gs.mapping.Source, targetId)
+		if sourceErr != nil || len(targetErrs[targetId]) > 0 {
+			m.failures.Inc(gs.mapping.Id, gs.mapping.Source, targetId)
+			continue
+		}
+		m.successes.Inc(gs.mapping.Id, gs.mapping.Source, targetId)
+		m.lastSuccess.Set(now, gs.mapping.Id, gs.mapping.Source, targetId)
+	}
+}
+
+func (m *gitSyncMetrics) recordMatchedRefs(gs *GitSync, targetIds []string, refs *sourceRefs) {
+	for _, targetId := range targetIds {
+		m.matchedRefs.Set(float64(len(refs.branches)), gs.mapping.Id, gs.mapping.Source, targetId, "branch")
+		m.matchedRefs.Set(float64(len(refs.tags)), gs.mapping.Id, gs.mapping.Source, targetId, "tag")
+		m.matchedRefs.Set(float64(len(refs.others)), gs.mapping.Id, gs.mapping.Source, targetId, "other")
+	}
+}
+
+func (m *gitSyncMetrics) recordFetchDuration(sourceId string, duration time.Duration) {
+	m.fetchDuration.Observe(duration.Seconds(), sourceId)
+}
+
+func (m *gitSyncMetrics) recordPushDuration(gs *GitSync, targetId string, duration time.Duration) {
+	m.pushDuration.Observe(duration.Seconds(), gs.mapping.Id, gs.mapping.Source, targetId)
+}
+
+func (m *gitSyncMetrics) recordPushedRefs(gs *GitSync, targetId string, count int) {
+	m.pushedRefs.Set(float64(count), gs.mapping.Id, gs.mapping.Source, targetId)
+}
@@ -0,0 +1,72 @@
+package gitsync
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/metrics"
+)
+
+func TestGitSyncMetrics(t *testing.T) {
+	registry := &metrics.Registry{}
+	m := newGitSyncMetrics(registry)
+	gs := GitSync{
+		mapping: &config.SyncMapping{Id: "otk", Source: "otk-github"},
+		pushOptions: map[string]git.PushOptions{
+			"otk-gitlab": {},
+			"otk-ssh":    {},
+		},
+	}
+
+	m.recordResults(&gs, gs.targetIds(), nil, nil)
+	m.recordResults(&gs, gs.targetIds(), nil, map[string][]error{"otk-ssh": {errors.New("push failed")}})
+	m.recordResults(&gs, gs.targetIds(), errors.New("fetch failed"), nil)
+	m.recordMatchedRefs(&gs, gs.targetIds(), &sourceRefs{branches: []string{"main"}, tags: []string{"v1", "v2"}})
+	m.recordPushedRefs(&gs, "otk-gitlab", 3)
+
+	var buf bytes.Buffer
+	require.NoError(t, registry.Write(&buf))
+	out := buf.String()
+	assert.Contains(t, out, `gitsync_sync_attempts_total{mapping="otk",source="otk-github",target="otk-gitlab"} 3`)
+	assert.Contains(t, out, `gitsync_sync_attempts_total{mapping="otk",source="otk-github",target="otk-ssh"} 3`)
+	assert.Contains(t, out, `gitsync_sync_successes_total{mapping="otk",source="otk-github",target="otk-gitlab"} 2`)
+	assert.Contains(t, out, `gitsync_sync_successes_total{mapping="otk",source="otk-github",target="otk-ssh"} 1`)
+	assert.Contains(t, out, `gitsync_sync_failures_total{mapping="otk",source="otk-github",target="otk-gitlab"} 1`)
+	assert.Contains(t, out, `gitsync_sync_failures_total{mapping="otk",source="otk-github",target="otk-ssh"} 2`)
+	assert.Contains(t, out, `gitsync_matched_refs{mapping="otk",source="otk-github",target="otk-ssh",type="branch"} 1`)
+	assert.Contains(t, out, `gitsync_matched_refs{mapping="otk",source="otk-github",target="otk-ssh",type="tag"} 2`)
+	assert.Contains(t, out, `gitsync_pushed_refs{mapping="otk",source="otk-github",target="otk-gitlab"} 3`)
+}
+
+func TestGitSyncMetricsMappingsSharingTarget(t *testing.T) {
+	registry := &metrics.Registry{}
+	m := newGitSyncMetrics(registry)
+	pushOptions := map[string]git.PushOptions{"otk-gitlab": {}}
+	branches := GitSync{
+		mapping:     &config.SyncMapping{Id: "branches", Source: "otk-github"},
+		pushOptions: pushOptions,
+	}
+	tags := GitSync{
+		mapping:     &config.SyncMapping{Id: "tags", Source: "otk-github"},
+		pushOptions: pushOptions,
+	}
+
+	m.recordResults(&branches, branches.targetIds(), nil, nil)
+	m.recordResults(&tags, tags.targetIds(), errors.New("fetch failed"), nil)
+	m.recordPushedRefs(&branches, "otk-gitlab", 3)
+	m.recordPushedRefs(&tags, "otk-gitlab", 1)
+
+	var buf bytes.Buffer
+	require.NoError(t, registry.Write(&buf))
+	out := buf.String()
+	assert.Contains(t, out, `gitsync_sync_successes_total{mapping="branches",source="otk-github",target="otk-gitlab"} 1`)
+	assert.Contains(t, out, `gitsync_sync_failures_total{mapping="tags",source="otk-github",target="otk-gitlab"} 1`)
+	assert.NotContains(t, out, `gitsync_sync_failures_total{mapping="branches"`)
+	assert.Contains(t, out, `gitsync_pushed_refs{mapping="branches",source="otk-github",target="otk-gitlab"} 3`)
+	assert.Contains(t, out, `gitsync_pushed_refs{mapping="tags",source="otk-github",target="otk-gitlab"} 1`)
+}
@@ -3,8 +3,11 @@ package gitsync
 import (
 	"bufio"
 	"fmt"
-	"io"
+	"path/filepath"
+	"slices"
+	"strings"
 
+	"github.com/go-git/go-billy/v5"
 	"go.lepovirta.org/otk/internal/file"
 	"go.lepovirta.org/otk/internal/gitsync/config"
 	"go.lepovirta.org/otk/internal/osenv"
@@ -15,37 +18,53 @@ func parseConfig(
 	cliFlags *config.CliFlags,
 	cfg *config.Config,
 ) error {
-	if cliFlags.ConfigPath == config.StdinPath {
-		return cfg.Parse(
+	if slices.Contains(cliFlags.ConfigPaths, config.StdinPath) {
+		return cfg.ParseFiles(
 			osEnv.EnvVars,
-			osEnv.Stdin,
+			osEnv.Fs,
+			[]config.File{{
+				Path:   config.StdinPath,
+				Reader: osEnv.Stdin,
+				Format: cliFlags.ConfigFormat,
+			}},
 			nil,
 		)
 	}
 
+	configPaths, err := configFiles(osEnv.Fs, cliFlags.ConfigPaths)
+	if err != nil {
+		return err
+	}
+
 	var fileReader file.Reader
-	var configReader io.Reader
-	var credentialsReader io.Reader
+	var credentials *config.File
+	configs := make([]config.File, 0, len(configPaths))
 
-	fileReader.Init(osEnv.Fs, 2)
+	fileReader.Init(osEnv.Fs, len(configPaths)+1)
 
-	if cliFlags.ConfigPath != "" {
-		file, err := fileReader.Open(cliFlags.ConfigPath)
+	for _, path := range configPaths {
+		file, err := fileReader.Open(path)
 		if err != nil {
 			_ = fileReader.Close()
 			return fmt.Errorf(
 				"failed to open config in path '%s': %w",
-				cliFlags.ConfigPath,
+				path,
 				err,
 			)
 		}
-		configReader = bufio.NewReader(file)
-	} else {
-		panic("unexpected config empty path")
+		configs = append(configs, config.File{
+			Path:   path,
+			Reader: bufio.NewReader(file),
+			Format: cliFlags.ConfigFormat,
+		})
 	}
 
 	if cliFlags.CredentialsPath == config.StdinPath {
-		credentialsReader = osEnv.Stdin
+		credentials = &config.File{
+			Path:   config.StdinPath,
+			Reader: osEnv.Stdin,
+			Format: cliFlags.ConfigFormat,
+		}
 	} else if cliFlags.CredentialsPath != "" {
 		file, err := fileReader.Open(cliFlags.CredentialsPath)
 		if err != nil {
@@ -56,17 +75,51 @@ func parseConfig(
 				err,
 			)
 		}
-		credentialsReader = bufio.NewReader(file)
+		credentials = &config.File{
+			Path:   cliFlags.CredentialsPath,
+			Reader: bufio.NewReader(file),
+			Format: cliFlags.ConfigFormat,
+		}
 	}
 
-	if err := cfg.Parse(
-		osEnv.EnvVars,
-		configReader,
-		credentialsReader,
-	); err != nil {
+	if err := cfg.ParseFiles(osEnv.EnvVars, osEnv.Fs, configs, credentials); err != nil {
 		_ = fileReader.Close()
 		return err
 	}
 
 	return fileReader.Close()
 }
+
+// configFiles lists the config files in the given paths. The directories are
+// expanded to the files in them that have a known config file extension.
+// Hidden files are skipped, and the files are listed in the order of their names.
+func configFiles(fs billy.Filesystem, paths []string) ([]string, error) {
+	files := make([]string, 0, len(paths))
+	for _, path := range paths {
+		info, err := fs.Stat(filepath.Clean(path))
+		if err != nil || !info.IsDir() {
+			// Errors are reported when the file is opened
+			files = append(files, path)
+			continue
+		}
+		entries, err := fs.ReadDir(filepath.Clean(path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config directory '%s': %w", path, err)
+		}
+		dirFiles := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || strings.HasPrefix(name, ".") ||
+				config.FormatFromPath(name) == config.FormatUndefined {
+				continue
+			}
+			dirFiles = append(dirFiles, filepath.Join(path, name))
+		}
+		if len(dirFiles) == 0 {
+			return nil, fmt.Errorf("no config files found in directory '%s'", path)
+		}
+		slices.Sort(dirFiles)
+		files = append(files, dirFiles...)
+	}
+	return files, nil
+}
@@ -0,0 +1,59 @@
+package gitsync
+
+import (
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	fsutil "github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/osenv"
+)
+
+func TestParseConfigDirectory(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	fs := memfs.New()
+	files := map[string]string{
+		"/conf.d/20-keruu.yaml": `
+repositories:
+  keruu-github: { url: "https://github.com/jpallari/keruu.git" }
+mappings:
+  - { source: keruu-github, targets: [gitlab], branches: [main] }
+`,
+		"/conf.d/10-otk.json": `{
+  "repositories": { "otk-github": { "url": "https://github.com/jpallari/otk.git" } },
+  "mappings": [ { "source": "otk-github", "targets": [ "gitlab" ], "branches": [ "main" ] } ]
+}`,
+		"/conf.d/.hidden.json": `{ "concurrency": -1 }`,
+		"/conf.d/README.md":    `Not a config file`,
+		"/gitlab.toml": `
+[repositories.gitlab]
+url = "https://gitlab.com/jpallari/otk.git"
+`,
+	}
+	for path, content := range files {
+		require.NoError(fsutil.WriteFile(fs, path, []byte(content), 0o644))
+	}
+
+	paths, err := configFiles(fs, []string{"/conf.d", "/gitlab.toml"})
+	require.NoError(err)
+	assert.Equal([]string{"/conf.d/10-otk.json", "/conf.d/20-keruu.yaml", "/gitlab.toml"}, paths)
+
+	var cfg config.Config
+	cliFlags := config.CliFlags{ConfigPaths: []string{"/conf.d", "/gitlab.toml"}}
+	require.NoError(parseConfig(osenv.OsEnv{Fs: fs}, &cliFlags, &cfg))
+	assert.Len(cfg.Repositories, 3)
+	require.Len(cfg.Mappings, 2)
+	assert.Equal("otk-github", cfg.Mappings[0].Source)
+	assert.Equal("keruu-github", cfg.Mappings[1].Source)
+}
+
+func TestParseConfigEmptyDirectory(t *testing.T) {
+	fs := memfs.New()
+	require.NoError(t, fs.MkdirAll("/conf.d", 0o755))
+
+	_, err := configFiles(fs, []string{"/conf.d"})
+	assert.ErrorContains(t, err, "no config files found in directory '/conf.d'")
+}
@@ -0,0 +1,104 @@
+package gitsync
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"go.lepovirta.org/otk/internal/logging"
+)
+
+// changePoller tracks the source refs seen during the last sync to each target,
+// so that the sync can be skipped when nothing has changed in the source,
+// and limited to the targets that haven't received the latest changes.
+type changePoller struct {
+	syncedRefs map[string]map[string]plumbing.Hash
+	polledRefs map[string]plumbing.Hash
+}
+
+// changedTargets polls the source for changes and returns the targets
+// that should be synced. All of the targets are synced when the next sync
+// is due. Otherwise, only the targets that have not been synced with
+// the polled refs are returned.
+func (p *changePoller) changedTargets(
+	ctx context.Context,
+	gs *GitSync,
+	now time.Time,
+	due bool,
+) []string {
+	log := logging.FromContext(ctx)
+	targetIds := gs.targetIds()
+
+	refs, err := gs.pollRefs(ctx, now)
+	if err != nil {
+		log.WarnContext(ctx, "failed to poll source for changes", slog.Any("error", err))
+		p.polledRefs = nil
+		if due {
+			return targetIds
+		}
+		return nil
+	}
+	p.polledRefs = refs
+
+	if due {
+		return targetIds
+	}
+	changed := make([]string, 0, len(targetIds))
+	for _, targetId := range targetIds {
+		if !maps.Equal(p.syncedRefs[targetId], p.polledRefs) {
+			changed = append(changed, targetId)
+		}
+	}
+	if len(changed) == 0 {
+		log.DebugContext(ctx, "no changes found from source")
+		return nil
+	}
+	log.InfoContext(ctx, "changes found from source", slog.Any("targetIds", changed))
+	return changed
+}
+
+// synced records the refs seen in the latest poll as synced
+// to the given targets that didn't fail during the sync.
+// Nothing is recorded when the sync failed in the source.
+func (p *changePoller) synced(targetIds []string, res *syncResult) {
+	if res.sourceErr != nil {
+		return
+	}
+	if p.syncedRefs == nil {
+		p.syncedRefs = make(map[string]map[string]plumbing.Hash, len(targetIds))
+	}
+	for _, targetId := range targetIds {
+		if _, failed := res.targetErrs[targetId]; !failed {
+			p.syncedRefs[targetId] = p.polledRefs
+		}
+	}
+}
+
+// pollRefs lists the hashes of the source refs that match the mapping.
+// Only the refs are listed from the source remote; nothing is fetched.
+func (gs *GitSync) pollRefs(
+	ctx context.Context,
+	since time.Time,
+) (map[string]plumbing.Hash, error) {
+	var refs []*plumbing.Reference
+	var err error
+	if gs.source.isRemote() {
+		refs, err = gs.source.listRefs(ctx, since)
+	} else {
+		refs, err = gs.source.localRefs()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var selected sourceRefs
+	hashes := make(map[string]plumbing.Hash, len(refs))
+	for _, ref := range refs {
+		if gs.selectRef(&selected, ref) {
+			hashes[ref.Name().String()] = ref.Hash()
+		}
+	}
+	return hashes, nil
+}
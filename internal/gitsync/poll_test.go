@@ -0,0 +1,194 @@
+package gitsync
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/matcher"
+)
+
+func TestChangePoller(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	repo, err := git.Init(memory.NewStorage(), nil)
+	require.NoError(err, "git init")
+	first := commitTo(t, repo, "first")
+	second := commitTo(t, repo, "second", first)
+	setRef := func(refName string, hash plumbing.Hash) {
+		require.NoError(repo.Storer.SetReference(
+			plumbing.NewHashReference(plumbing.ReferenceName(refName), hash),
+		))
+	}
+	setRef("refs/heads/main", first)
+	setRef("refs/heads/other", first)
+
+	gs := GitSync{
+		mapping: &config.SyncMapping{
+			SyncSpec: config.SyncSpec{
+				Branches: []matcher.M{matcher.FromStringOrPanic("main")},
+			},
+		},
+		source: &sourceRepo{
+			config: &config.Repository{},
+			repo:   repo,
+		},
+		pushOptions: map[string]git.PushOptions{"target": {}},
+	}
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	var poller changePoller
+	synced := func(targetIds []string) {
+		poller.synced(targetIds, &syncResult{targetIds: targetIds})
+	}
+
+	assert.Equal([]string{"target"}, poller.changedTargets(ctx, &gs, now, true), "first sync")
+	synced([]string{"target"})
+
+	now = now.Add(time.Minute)
+	assert.Empty(poller.changedTargets(ctx, &gs, now, false), "no changes")
+
+	setRef("refs/heads/other", second)
+	assert.Empty(poller.changedTargets(ctx, &gs, now, false), "unmatched ref changed")
+
+	setRef("refs/heads/main", second)
+	assert.Equal([]string{"target"}, poller.changedTargets(ctx, &gs, now, false), "matched ref changed")
+	synced([]string{"target"})
+
+	now = now.Add(time.Hour)
+	assert.Equal([]string{"target"}, poller.changedTargets(ctx, &gs, now, true), "next sync due")
+}
+
+func TestSyncIfChangedRetriesFailedSync(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	repo, err := git.Init(memory.NewStorage(), nil)
+	require.NoError(err, "git init")
+	first := commitTo(t, repo, "first")
+	require.NoError(repo.Storer.SetReference(
+		plumbing.NewHashReference("refs/heads/main", first),
+	))
+
+	// The target doesn't exist yet, so the first sync fails
+	targetConfig := config.Repository{URL: filepath.Join(t.TempDir(), "target")}
+	gs := GitSync{
+		repoConfigs: map[string]config.Repository{"target": targetConfig},
+		mapping: &config.SyncMapping{
+			Id:      "source->target",
+			Source:  "source",
+			Targets: []string{"target"},
+			SyncSpec: config.SyncSpec{
+				Branches: []matcher.M{matcher.FromStringOrPanic("main")},
+			},
+		},
+		source: &sourceRepo{
+			id:     "source",
+			config: &config.Repository{},
+			repo:   repo,
+		},
+		sourceRepoConfig: &config.Repository{},
+		pushOptions: map[string]git.PushOptions{
+			"target": {RemoteName: "target", RemoteURL: targetConfig.URL},
+		},
+		options: SyncOptions{Concurrency: 1},
+	}
+	require.NoError(gs.source.prepareTarget(ctx, "target", &targetConfig, slog.Default()))
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	var poller changePoller
+
+	assert.True(gs.syncIfChanged(ctx, &poller, now, true, false), "first sync")
+	assert.NotContains(poller.syncedRefs, "target", "failed sync is not recorded")
+
+	_, err = git.PlainInit(targetConfig.URL, true)
+	require.NoError(err, "git init target")
+	now = now.Add(time.Minute)
+	assert.True(gs.syncIfChanged(ctx, &poller, now, false, false), "failed sync retried")
+	assert.Equal(map[string]plumbing.Hash{"refs/heads/main": first}, poller.syncedRefs["target"])
+
+	now = now.Add(time.Minute)
+	assert.False(gs.syncIfChanged(ctx, &poller, now, false, false), "no changes")
+	assert.True(gs.syncIfChanged(ctx, &poller, now, false, true), "sync requested")
+}
+
+func TestSyncIfChangedRetriesOnlyFailedTargets(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	repo, err := git.Init(memory.NewStorage(), nil)
+	require.NoError(err, "git init")
+	first := commitTo(t, repo, "first")
+	require.NoError(repo.Storer.SetReference(
+		plumbing.NewHashReference("refs/heads/main", first),
+	))
+
+	// The broken target never exists, so the pushes to it keep failing
+	goodConfig := config.Repository{URL: t.TempDir()}
+	goodRepo, err := git.PlainInit(goodConfig.URL, true)
+	require.NoError(err, "git init target")
+	brokenConfig := config.Repository{URL: filepath.Join(t.TempDir(), "broken")}
+	gs := GitSync{
+		repoConfigs: map[string]config.Repository{"good": goodConfig, "broken": brokenConfig},
+		mapping: &config.SyncMapping{
+			Id:      "source->broken,good",
+			Source:  "source",
+			Targets: []string{"broken", "good"},
+			SyncSpec: config.SyncSpec{
+				Branches: []matcher.M{matcher.FromStringOrPanic("main")},
+			},
+		},
+		source: &sourceRepo{
+			id:     "source",
+			config: &config.Repository{},
+			repo:   repo,
+		},
+		sourceRepoConfig: &config.Repository{},
+		pushOptions: map[string]git.PushOptions{
+			"good":   {RemoteName: "good", RemoteURL: goodConfig.URL},
+			"broken": {RemoteName: "broken", RemoteURL: brokenConfig.URL},
+		},
+		options: SyncOptions{Concurrency: 1, StatusHistory: 10},
+	}
+	gs.history.limit = gs.options.StatusHistory
+	require.NoError(gs.source.prepareTarget(ctx, "good", &goodConfig, slog.Default()))
+	require.NoError(gs.source.prepareTarget(ctx, "broken", &brokenConfig, slog.Default()))
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	var poller changePoller
+
+	assert.True(gs.syncIfChanged(ctx, &poller, now, true, false), "first sync")
+	assert.Equal(map[string]plumbing.Hash{"refs/heads/main": first}, poller.syncedRefs["good"])
+	assert.NotContains(poller.syncedRefs, "broken")
+
+	// The good target is left out of the retries
+	now = now.Add(time.Minute)
+	assert.True(gs.syncIfChanged(ctx, &poller, now, false, false), "failed target retried")
+	runs := gs.history.list()
+	require.Len(runs, 2)
+	require.Len(runs[0].Targets, 1)
+	assert.Equal("broken", runs[0].Targets[0].Target)
+	assert.Equal(targetOutcomeFailure, runs[0].Targets[0].Outcome)
+
+	// Changes in the source are synced to both targets
+	second := commitTo(t, repo, "second", first)
+	require.NoError(repo.Storer.SetReference(
+		plumbing.NewHashReference("refs/heads/main", second),
+	))
+	now = now.Add(time.Minute)
+	assert.True(gs.syncIfChanged(ctx, &poller, now, false, false), "source changed")
+	ref, err := goodRepo.Reference("refs/heads/main", true)
+	require.NoError(err, "target ref")
+	assert.Equal(second, ref.Hash())
+	assert.Equal(map[string]plumbing.Hash{"refs/heads/main": second}, poller.syncedRefs["good"])
+	assert.NotContains(poller.syncedRefs, "broken")
+}
@@ -0,0 +1,333 @@
+package gitsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/logging"
+)
+
+const configWatchInterval = 5 * time.Second
+
+// syncLoop is a sync running in a loop in the background.
+type syncLoop struct {
+	// key identifies the config of the sync, so that
+	// the unchanged syncs can be kept running on reload.
+	key     string
+	gitSync *GitSync
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// stop stops the sync loop and waits for it to finish.
+func (l *syncLoop) stop() {
+	l.cancel()
+	<-l.done
+}
+
+func stopLoops(loops []*syncLoop) {
+	for _, l := range loops {
+		l.cancel()
+	}
+	for _, l := range loops {
+		<-l.done
+	}
+}
+
+func loopGitSyncs(loops []*syncLoop) []*GitSync {
+	gitSyncs := make([]*GitSync, len(loops))
+	for i, l := range loops {
+		gitSyncs[i] = l.gitSync
+	}
+	return gitSyncs
+}
+
+// startLoop initializes the sync for the mapping and starts running it
+// in a loop in the background. The loop is returned also when the init
+// fails, so that the failure is visible in the health checks. Failed loops
+// have no key, so that they are started again on the next reload.
+func (c *Core) startLoop(
+	ctx context.Context,
+	cfg *config.Config,
+	mapping *config.SyncMapping,
+	options SyncOptions,
+) (*syncLoop, error) {
+	l := &syncLoop{
+		key:     syncKey(cfg, mapping, options),
+		gitSync: &GitSync{},
+		cancel:  func() {},
+		done:    make(chan struct{}),
+	}
+	if err := l.gitSync.Init(ctx, &c.osEnv, &c.sources, cfg.Repositories, mapping, options); err != nil {
+		l.key = ""
+		close(l.done)
+		return l, err
+	}
+
+	ctx, l.cancel = context.WithCancel(ctx)
+	go func() {
+		defer close(l.done)
+		if err := l.gitSync.RunInLoop(ctx); err != nil {
+			logging.FromContext(ctx).ErrorContext(ctx, "sync loop failed", slog.Any("error", err))
+		}
+	}()
+	return l, nil
+}
+
+// syncKey identifies the config of the sync for the mapping.
+// The key changes when the mapping, the repositories used in the mapping,
+// or the sync options change.
+func syncKey(cfg *config.Config, mapping *config.SyncMapping, options SyncOptions) string {
+	repos := make(map[string]config.Repository, len(mapping.Targets)+1)
+	repos[mapping.Source] = cfg.Repositories[mapping.Source]
+	for _, targetId := range mapping.Targets {
+		repos[targetId] = cfg.Repositories[targetId]
+	}
+	return configKey(struct {
+		Mapping      *config.SyncMapping
+		Repositories map[string]config.Repository
+		Options      SyncOptions
+	}{mapping, repos, options})
+}
+
+// configKey encodes the config for comparison.
+func configKey(v any) string {
+	key, err := json.Marshal(v)
+	if err != nil {
+		// Config is parsed from JSON, so it can always be encoded
+		panic(err)
+	}
+	return string(key)
+}
+
+// planReload matches the running loops to the keys of the reloaded config.
+// The loops whose config hasn't changed are kept in place of the matching keys.
+// The rest of the loops need to be stopped.
+func planReload(loops []*syncLoop, keys []string) (kept []*syncLoop, stopped []*syncLoop) {
+	loopsByKey := make(map[string][]*syncLoop, len(loops))
+	for _, l := range loops {
+		loopsByKey[l.key] = append(loopsByKey[l.key], l)
+	}
+	kept = make([]*syncLoop, len(keys))
+	for i, key := range keys {
+		if key == "" {
+			continue
+		}
+		if matches := loopsByKey[key]; len(matches) > 0 {
+			kept[i] = matches[0]
+			loopsByKey[key] = matches[1:]
+		}
+	}
+	for _, l := range loops {
+		if !slices.Contains(kept, l) {
+			stopped = append(stopped, l)
+		}
+	}
+	return
+}
+
+// handleReloads reloads the config on SIGHUP, and when the config files
+// change if watching is enabled. Runs until the context is cancelled.
+func (c *Core) handleReloads(ctx context.Context) {
+	log := logging.FromContext(ctx)
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	var changes <-chan struct{}
+	if c.cliFlags.WatchConfig {
+		changes = watchFiles(ctx, c.osEnv.Fs, c.configPaths, configWatchInterval)
+	}
+
+	for {
+		select {
+		case <-sighup:
+			log.InfoContext(ctx, "SIGHUP received, reloading config")
+		case <-changes:
+			log.InfoContext(ctx, "config changed, reloading config")
+		case <-ctx.Done():
+			return
+		}
+		c.reload(ctx)
+	}
+}
+
+// configPaths lists the paths of the config files that can be reloaded.
+// The config directories are listed along with the files in them,
+// so that the added and the removed files are noticed too.
+func (c *Core) configPaths() []string {
+	paths := slices.Clone(c.cliFlags.ConfigPaths)
+	if files, err := configFiles(c.osEnv.Fs, c.cliFlags.ConfigPaths); err == nil {
+		paths = append(paths, files...)
+	}
+	if c.cliFlags.CredentialsPath != "" {
+		paths = append(paths, c.cliFlags.CredentialsPath)
+	}
+	return slices.DeleteFunc(paths, func(path string) bool {
+		return path == config.StdinPath
+	})
+}
+
+// reload parses and validates the config again, and restarts the syncs
+// whose config has changed. The current config is kept when the new config
+// can't be parsed or it's invalid.
+func (c *Core) reload(ctx context.Context) {
+	log := logging.FromContext(ctx)
+	if slices.Contains(c.cliFlags.ConfigPaths, config.StdinPath) || c.cliFlags.CredentialsPath == config.StdinPath {
+		log.WarnContext(ctx, "config read from STDIN can't be reloaded")
+		return
+	}
+
+	var cfg config.Config
+	if err := parseConfig(c.osEnv, &c.cliFlags, &cfg); err != nil {
+		log.ErrorContext(ctx, "config reload failed, keeping the current config", slog.Any("error", err))
+		return
+	}
+	c.keepStartupSettings(ctx, &cfg)
+
+	c.cfg = cfg
+	options := c.syncOptions()
+	keys := make([]string, len(c.cfg.Mappings))
+	for i := range c.cfg.Mappings {
+		keys[i] = syncKey(&c.cfg, &c.cfg.Mappings[i], options)
+	}
+	kept, stopped := planReload(c.loops, keys)
+	for _, l := range stopped {
+		l.stop()
+	}
+
+	// The local copies of the unchanged sources are reused by the new syncs
+	errs := make([]error, 0, len(keys))
+	if err := c.sources.retain(c.osEnv.Fs, c.cfg.Repositories, c.cfg.Mappings); err != nil {
+		errs = append(errs, err)
+	}
+
+	loops := make([]*syncLoop, len(keys))
+	started := 0
+	for i := range c.cfg.Mappings {
+		if kept[i] != nil {
+			loops[i] = kept[i]
+			continue
+		}
+		l, err := c.startLoop(ctx, &c.cfg, &c.cfg.Mappings[i], options)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		loops[i] = l
+		started++
+	}
+	c.loops = loops
+	c.handler.set(newServeMux(&c.cfg, loopGitSyncs(loops)))
+
+	logArgs := []any{
+		slog.Int("kept", len(loops)-started),
+		slog.Int("stopped", len(stopped)),
+		slog.Int("started", started),
+	}
+	if err := errors.Join(errs...); err != nil {
+		log.ErrorContext(ctx, "config reloaded with errors", append(logArgs, slog.Any("error", err))...)
+		return
+	}
+	log.InfoContext(ctx, "config reloaded", logArgs...)
+}
+
+// keepStartupSettings replaces the settings that are only applied
+// on startup with the current ones, and warns about the changes.
+func (c *Core) keepStartupSettings(ctx context.Context, cfg *config.Config) {
+	log := logging.FromContext(ctx)
+	warn := func(setting string) {
+		log.WarnContext(ctx, "config change requires a restart", slog.String("setting", setting))
+	}
+	if cfg.Server.Address != c.cfg.Server.Address {
+		warn("server.address")
+		cfg.Server.Address = c.cfg.Server.Address
+	}
+	if cfg.StateDir != c.cfg.StateDir {
+		warn("stateDir")
+		cfg.StateDir = c.cfg.StateDir
+	}
+	if configKey(cfg.Tracing) != configKey(c.cfg.Tracing) {
+		warn("tracing")
+		cfg.Tracing = c.cfg.Tracing
+	}
+}
+
+// reloadableHandler serves the requests using the latest handler,
+// so that the routes can be updated when the config is reloaded.
+type reloadableHandler struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+func (h *reloadableHandler) set(handler http.Handler) {
+	h.handler.Store(&handler)
+}
+
+func (h *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler := h.handler.Load()
+	if handler == nil {
+		http.NotFound(w, r)
+		return
+	}
+	(*handler).ServeHTTP(w, r)
+}
+
+// fileStamp is used for detecting changes in a file.
+type fileStamp struct {
+	modTime int64
+	size    int64
+}
+
+func statFiles(fs billy.Filesystem, paths []string) []fileStamp {
+	stamps := make([]fileStamp, len(paths))
+	for i, path := range paths {
+		if info, err := fs.Stat(path); err == nil {
+			stamps[i] = fileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}
+		}
+	}
+	return stamps
+}
+
+// watchFiles polls the files for changes until the context is cancelled.
+// The paths are listed again on every poll. A change is signaled through
+// the returned channel.
+func watchFiles(
+	ctx context.Context,
+	fs billy.Filesystem,
+	paths func() []string,
+	interval time.Duration,
+) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	stamps := statFiles(fs, paths())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			current := statFiles(fs, paths())
+			if slices.Equal(current, stamps) {
+				continue
+			}
+			stamps = current
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes
+}
@@ -0,0 +1,139 @@
+package gitsync
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	fsutil "github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/osenv"
+)
+
+func TestPlanReload(t *testing.T) {
+	assert := assert.New(t)
+	a := &syncLoop{key: "a"}
+	b := &syncLoop{key: "b"}
+	b2 := &syncLoop{key: "b"}
+	failed := &syncLoop{key: ""}
+
+	kept, stopped := planReload([]*syncLoop{a, b, b2, failed}, []string{"c", "b", "a", ""})
+	assert.Equal([]*syncLoop{nil, b, a, nil}, kept)
+	assert.Equal([]*syncLoop{b2, failed}, stopped)
+}
+
+func TestSyncKey(t *testing.T) {
+	assert := assert.New(t)
+	cfg := config.Config{
+		Repositories: map[string]config.Repository{
+			"otk-github":   {URL: "https://github.com/jpallari/otk.git"},
+			"otk-gitlab":   {URL: "https://gitlab.com/jpallari/otk.git"},
+			"keruu-github": {URL: "https://github.com/jpallari/keruu.git"},
+		},
+		Mappings: []config.SyncMapping{
+			{Source: "otk-github", Targets: []string{"otk-gitlab"}},
+			{Source: "keruu-github", Targets: []string{"otk-gitlab"}},
+		},
+	}
+	options := SyncOptions{Concurrency: 1}
+	otkKey := syncKey(&cfg, &cfg.Mappings[0], options)
+	keruuKey := syncKey(&cfg, &cfg.Mappings[1], options)
+	assert.NotEqual(otkKey, keruuKey)
+
+	cfg.Repositories["keruu-github"] = config.Repository{URL: "https://github.com/jpallari/keruu2.git"}
+	assert.Equal(otkKey, syncKey(&cfg, &cfg.Mappings[0], options), "unrelated repo changed")
+	assert.NotEqual(keruuKey, syncKey(&cfg, &cfg.Mappings[1], options), "source changed")
+
+	cfg.Mappings[0].Prune = true
+	assert.NotEqual(otkKey, syncKey(&cfg, &cfg.Mappings[0], options), "mapping changed")
+	cfg.Mappings[0].Prune = false
+	assert.NotEqual(otkKey, syncKey(&cfg, &cfg.Mappings[0], SyncOptions{Concurrency: 2}), "options changed")
+}
+
+func TestWatchFiles(t *testing.T) {
+	require := require.New(t)
+	fs := osfs.New(t.TempDir())
+	require.NoError(fsutil.WriteFile(fs, "config.json", []byte("{}"), 0o644))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	paths := func() []string { return []string{"config.json", "credentials.json"} }
+	changes := watchFiles(ctx, fs, paths, time.Millisecond)
+	require.NoError(fsutil.WriteFile(fs, "credentials.json", []byte("{}"), 0o644))
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		require.Fail("no change detected")
+	}
+}
+
+const reloadTestConfig = `{
+  "repositories": {
+    "otk-source": { "localPath": "/otk", "inMemory": true },
+    "keruu-source": { "localPath": "/keruu", "inMemory": true },
+    "target": { "url": "/target.git" }
+  },
+  "mappings": [
+    {
+      "source": "otk-source",
+      "targets": [ "target" ],
+      "branches": [ "main" ],
+      "blackouts": [ {} ]
+    },
+    {
+      "source": "keruu-source",
+      "targets": [ "target" ],
+      "branches": [ "%s" ],
+      "blackouts": [ {} ]
+    }
+  ]
+}`
+
+func TestCoreReload(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	ctx := context.Background()
+	fs := memfs.New()
+	writeConfig := func(content string) {
+		require.NoError(fsutil.WriteFile(fs, "/config.json", []byte(content), 0o644))
+	}
+	writeConfig(fmt.Sprintf(reloadTestConfig, "main"))
+
+	c := Core{
+		osEnv:    osenv.OsEnv{Fs: fs},
+		cliFlags: config.CliFlags{ConfigPaths: []string{"/config.json"}},
+	}
+	require.NoError(parseConfig(c.osEnv, &c.cliFlags, &c.cfg))
+	for i := range c.cfg.Mappings {
+		loop, err := c.startLoop(ctx, &c.cfg, &c.cfg.Mappings[i], c.syncOptions())
+		require.NoError(err)
+		c.loops = append(c.loops, loop)
+	}
+	defer func() { stopLoops(c.loops) }()
+	otkLoop, keruuLoop := c.loops[0], c.loops[1]
+	otkSource := c.sources.sources["otk-source"]
+
+	// Invalid config is not applied
+	writeConfig(`{ "repositories": {}, "mappings": [ { "source": "otk-source" } ] }`)
+	c.reload(ctx)
+	assert.Equal([]*syncLoop{otkLoop, keruuLoop}, c.loops)
+
+	// Only the changed mapping is restarted
+	writeConfig(fmt.Sprintf(reloadTestConfig, "develop"))
+	c.reload(ctx)
+	require.Len(c.loops, 2)
+	assert.Same(otkLoop, c.loops[0])
+	assert.NotSame(keruuLoop, c.loops[1])
+	assert.Equal("develop", c.loops[1].gitSync.mapping.Branches[0].String())
+	assert.Same(otkSource, c.sources.sources["otk-source"], "unchanged source is reused")
+	select {
+	case <-keruuLoop.done:
+	default:
+		assert.Fail("changed loop not stopped")
+	}
+}
@@ -0,0 +1,142 @@
+package gitsync
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/retry"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultRetryMinDelay = time.Second
+	defaultRetryMaxDelay = time.Minute
+)
+
+type retryPolicy struct {
+	maxRetries int
+	delayFunc  retry.DelayFunc
+}
+
+func (p *retryPolicy) fromConfig(cfg *config.Retry) {
+	p.maxRetries = max(cfg.MaxAttempts-1, 0)
+
+	minDelay := cfg.MinDelay.Duration
+	if minDelay <= 0 {
+		minDelay = defaultRetryMinDelay
+	}
+	maxDelay := cfg.MaxDelay.Duration
+	if maxDelay <= 0 {
+		maxDelay = max(defaultRetryMaxDelay, minDelay)
+	}
+
+	switch cfg.Backoff {
+	case config.BackoffConstant:
+		p.delayFunc = retry.ConstantBackoff(minDelay)
+	case config.BackoffLinear:
+		linear := retry.LinearBackoff(minDelay, minDelay)
+		p.delayFunc = func(attemptNr int) time.Duration {
+			return min(linear(attemptNr), maxDelay)
+		}
+	default:
+		p.delayFunc = retry.ExponentialBackoff(minDelay, maxDelay)
+	}
+
+	if cfg.Jitter.Duration > 0 {
+		rng := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+		p.delayFunc = p.delayFunc.WithJitter(rng, cfg.Jitter.Duration)
+	}
+}
+
+// run calls f until it succeeds, the attempts run out, or f fails
+// with an error that is not worth retrying.
+func (p *retryPolicy) run(ctx context.Context, f retry.Retryable) error {
+	if p.delayFunc == nil {
+		return f(ctx)
+	}
+	return retry.Retry(ctx, p.maxRetries, p.delayFunc, func(ctx context.Context) error {
+		err := f(ctx)
+		if err != nil && isPermanentError(err) {
+			return retry.Cancel(err)
+		}
+		return err
+	})
+}
+
+// isPermanentError reports whether the error is caused by something
+// that retrying will not fix such as failed authentication or
+// a push rejected by the remote. The errors are classified by the error
+// values and types of go-git and the SSH client where they exist, and
+// by narrow message matches where they don't.
+func isPermanentError(err error) bool {
+	return isAuthError(err) ||
+		errors.Is(err, transport.ErrInvalidAuthMethod) ||
+		isRepoNotFoundError(err) ||
+		isRejectedPushError(err) ||
+		isSshHostError(err) ||
+		isHttpClientError(err) ||
+		isSshAuthError(err)
+}
+
+func isRepoNotFoundError(err error) bool {
+	return errors.Is(err, transport.ErrRepositoryNotFound)
+}
+
+// isSshAuthError reports whether the SSH handshake failed because
+// none of the authentication methods were accepted.
+// The SSH client reports this only as a plain error message.
+func isSshAuthError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "ssh: handshake failed") &&
+		strings.Contains(msg, "ssh: unable to authenticate")
+}
+
+// isRejectedPushError reports whether the push was rejected either by
+// go-git before sending it or by the remote in its report status.
+// go-git reports the remote rejections only as plain error messages.
+func isRejectedPushError(err error) bool {
+	var noMatchingRefSpecErr git.NoMatchingRefSpecError
+	return errors.Is(err, git.ErrForceNeeded) ||
+		errors.Is(err, git.ErrDeleteRefNotSupported) ||
+		errors.Is(err, git.ErrExactSHA1NotSupported) ||
+		errors.As(err, &noMatchingRefSpecErr) ||
+		strings.Contains(err.Error(), "command error on ")
+}
+
+func isSshHostError(err error) bool {
+	var keyErr *knownhosts.KeyError
+	var revokedErr *knownhosts.RevokedError
+	var algorithmErr *ssh.AlgorithmNegotiationError
+	var passphraseErr *ssh.PassphraseMissingError
+	return errors.As(err, &keyErr) ||
+		errors.As(err, &revokedErr) ||
+		errors.As(err, &algorithmErr) ||
+		errors.As(err, &passphraseErr)
+}
+
+// isHttpClientError reports whether the remote responded with
+// a 4xx status code other than request timeout or too many requests.
+func isHttpClientError(err error) bool {
+	var unexpectedErr *plumbing.UnexpectedError
+	if !errors.As(err, &unexpectedErr) {
+		return false
+	}
+	var httpErr *githttp.Err
+	if !errors.As(unexpectedErr.Err, &httpErr) || httpErr.Response == nil {
+		return false
+	}
+	status := httpErr.StatusCode()
+	return status >= 400 && status < 500 &&
+		status != http.StatusRequestTimeout &&
+		status != http.StatusTooManyRequests
+}
@@ -0,0 +1,114 @@
+package gitsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"go.lepovirta.org/otk/internal/duration"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	retryConfig := config.Retry{
+		MaxAttempts: 3,
+		Backoff:     config.BackoffConstant,
+		MinDelay:    duration.New(time.Millisecond),
+	}
+
+	t.Run("retries transient errors", func(t *testing.T) {
+		assert := assert.New(t)
+		var policy retryPolicy
+		policy.fromConfig(&retryConfig)
+
+		calls := 0
+		err := policy.run(context.Background(), func(ctx context.Context) error {
+			calls += 1
+			return fmt.Errorf("transient error %d", calls)
+		})
+
+		assert.Error(err)
+		assert.Equal(3, calls)
+	})
+
+	t.Run("does not retry auth errors", func(t *testing.T) {
+		assert := assert.New(t)
+		var policy retryPolicy
+		policy.fromConfig(&retryConfig)
+
+		calls := 0
+		err := policy.run(context.Background(), func(ctx context.Context) error {
+			calls += 1
+			return fmt.Errorf("push failed: %w", transport.ErrAuthenticationRequired)
+		})
+
+		assert.ErrorIs(err, transport.ErrAuthenticationRequired)
+		assert.Equal(1, calls)
+	})
+
+	t.Run("single attempt by default", func(t *testing.T) {
+		assert := assert.New(t)
+		var policy retryPolicy
+		policy.fromConfig(&config.Retry{})
+
+		calls := 0
+		err := policy.run(context.Background(), func(ctx context.Context) error {
+			calls += 1
+			return fmt.Errorf("transient error %d", calls)
+		})
+
+		assert.Error(err)
+		assert.Equal(1, calls)
+	})
+}
+
+func TestIsPermanentError(t *testing.T) {
+	httpErr := func(status int) error {
+		return plumbing.NewUnexpectedError(&githttp.Err{
+			Response: &http.Response{
+				StatusCode: status,
+				Request:    httptest.NewRequest(http.MethodGet, "https://example.com/repo.git", nil),
+			},
+		})
+	}
+	tests := []struct {
+		name      string
+		err       error
+		permanent bool
+	}{
+		{"authentication", fmt.Errorf("%w: bad token", transport.ErrAuthenticationRequired), true},
+		{"authorization", fmt.Errorf("%w: no access", transport.ErrAuthorizationFailed), true},
+		{"invalid auth method", transport.ErrInvalidAuthMethod, true},
+		{"repository not found", fmt.Errorf("fetch: %w", transport.ErrRepositoryNotFound), true},
+		{"force needed", git.ErrForceNeeded, true},
+		{"delete not supported", git.ErrDeleteRefNotSupported, true},
+		{"no matching refspec", git.NoMatchingRefSpecError{}, true},
+		{"push rejected by server", errors.New("command error on refs/heads/main: pre-receive hook declined"), true},
+		{"ssh host key mismatch", fmt.Errorf("ssh: handshake failed: %w", &knownhosts.KeyError{}), true},
+		{"ssh algorithm negotiation", &ssh.AlgorithmNegotiationError{}, true},
+		{"ssh key passphrase missing", &ssh.PassphraseMissingError{}, true},
+		{"ssh unable to authenticate", errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain"), true},
+		{"http client error", httpErr(http.StatusUnprocessableEntity), true},
+		{"http rate limit", httpErr(http.StatusTooManyRequests), false},
+		{"http server error", httpErr(http.StatusBadGateway), false},
+		{"network error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.permanent, isPermanentError(test.err))
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package gitsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/logging"
+)
+
+const (
+	serverReadHeaderTimeout = 10 * time.Second
+	serverShutdownTimeout   = 5 * time.Second
+	metricsPath             = "/metrics"
+)
+
+// newServeMux sets up the HTTP routes. The syncs are expected
+// to be in the same order as the mappings in the config.
+func newServeMux(cfg *config.Config, gitSyncs []*GitSync) *http.ServeMux {
+	webhookPath := cfg.Server.WebhookPath
+	if webhookPath == "" {
+		webhookPath = defaultWebhookPath
+	}
+
+	liveness, readiness := newHealthHandlers(cfg, gitSyncs)
+
+	mux := http.NewServeMux()
+	mux.Handle("POST "+webhookPath, newWebhookHandler(cfg.Repositories, gitSyncs))
+	mux.Handle("GET "+healthPath, liveness)
+	mux.Handle("GET "+readinessPath, readiness)
+	mux.Handle("GET "+statusPath, newStatusHandler(cfg, gitSyncs))
+	if cfg.Server.Metrics {
+		mux.Handle("GET "+metricsPath, syncMetrics.handler())
+	}
+	return mux
+}
+
+// serve runs the HTTP server until the context is cancelled.
+func serve(ctx context.Context, address string, handler http.Handler) error {
+	log := logging.FromContext(ctx)
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on '%s': %w", address, err)
+	}
+	server := http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), serverShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.ErrorContext(ctx, "HTTP server shutdown failed", slog.Any("error", err))
+		}
+	}()
+
+	log.InfoContext(ctx, "HTTP server listening", slog.String("address", listener.Addr().String()))
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("HTTP server failed: %w", err)
+	}
+	return nil
+}
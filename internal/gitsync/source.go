@@ -0,0 +1,462 @@
+package gitsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	fsutil "github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	gitconf "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/logging"
+	"go.lepovirta.org/otk/internal/osenv"
+	"go.lepovirta.org/otk/internal/retry"
+)
+
+// SourceCache contains the local copies of the source repositories.
+// The mappings that use the same source repository share the local copy
+// and the fetches made to the source.
+type SourceCache struct {
+	mu       sync.Mutex
+	sources  map[string]*sourceRepo
+	stateDir string
+}
+
+func (sc *SourceCache) get(
+	ctx context.Context,
+	osEnv *osenv.OsEnv,
+	repoId string,
+	repoConfig *config.Repository,
+) (*sourceRepo, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if source, ok := sc.sources[repoId]; ok {
+		return source, nil
+	}
+	if sc.sources == nil {
+		sc.sources = make(map[string]*sourceRepo, 10)
+	}
+
+	source := &sourceRepo{}
+	if err := source.init(ctx, osEnv, repoId, repoConfig, sc.stateDir); err != nil {
+		if cleanErr := source.clean(osEnv.Fs); cleanErr != nil {
+			err = errors.Join(err, cleanErr)
+		}
+		return nil, err
+	}
+	sc.sources[repoId] = source
+	return source, nil
+}
+
+// Clean removes all of the temporary directories created for
+// the source repositories.
+func (sc *SourceCache) Clean(fs billy.Filesystem) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	errs := make([]error, 0, len(sc.sources))
+	for repoId, source := range sc.sources {
+		if err := source.clean(fs); err != nil {
+			errs = append(errs, err)
+		}
+		delete(sc.sources, repoId)
+	}
+	return errors.Join(errs...)
+}
+
+// retain removes the sources that are no longer used in the mappings,
+// or whose config has changed. The syncs using the removed sources
+// must be stopped before calling this.
+func (sc *SourceCache) retain(
+	fs billy.Filesystem,
+	repoConfigs map[string]config.Repository,
+	mappings []config.SyncMapping,
+) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	errs := make([]error, 0, len(sc.sources))
+	for repoId, source := range sc.sources {
+		repoConfig, ok := repoConfigs[repoId]
+		used := slices.ContainsFunc(mappings, func(m config.SyncMapping) bool {
+			return m.Source == repoId
+		})
+		if ok && used && configKey(repoConfig) == configKey(*source.config) {
+			continue
+		}
+		if err := source.clean(fs); err != nil {
+			errs = append(errs, err)
+		}
+		delete(sc.sources, repoId)
+	}
+	return errors.Join(errs...)
+}
+
+// sourceRepo is a local copy of a source repository.
+type sourceRepo struct {
+	id           string
+	config       *config.Repository
+	repo         *git.Repository
+	pathFs       billy.Filesystem
+	tempDirPath  string
+	fetchOptions git.FetchOptions
+	auth         authProvider
+	retry        retryPolicy
+	state        syncState
+
+	// mu provides exclusive access to the local repository during fetches
+	// and shared access during reads and pushes.
+	mu sync.RWMutex
+
+	// updateMu serializes listing and fetching refs from the source,
+	// so that concurrent syncs can share the results.
+	updateMu    sync.Mutex
+	listedAt    time.Time
+	refs        []*plumbing.Reference
+	fetchedAt   time.Time
+	fetchedRefs []string
+}
+
+func (s *sourceRepo) error(reason string, cause error) *GitRepoError {
+	return &GitRepoError{
+		RepoId:  s.id,
+		RepoURL: s.config.URL,
+		Reason:  reason,
+		Cause:   cause,
+	}
+}
+
+func (s *sourceRepo) isRemote() bool {
+	return s.config.URL != ""
+}
+
+func (s *sourceRepo) init(
+	ctx context.Context,
+	osEnv *osenv.OsEnv,
+	repoId string,
+	repoConfig *config.Repository,
+	stateDir string,
+) (err error) {
+	s.id = repoId
+	s.config = repoConfig
+	log := logging.FromContext(ctx)
+
+	// Source authentication
+	if err = s.auth.init(osEnv, s.id, s.config, log); err != nil {
+		return s.error("failed to configure auth", err)
+	}
+	s.fetchOptions = git.FetchOptions{
+		RemoteURL:  s.config.URL,
+		RemoteName: s.id,
+		Force:      true,
+		RefSpecs: []gitconf.RefSpec{
+			gitconf.RefSpec(refSpecFetchBranches),
+			gitconf.RefSpec(refSpecFetchTags),
+		},
+	}
+	s.retry.fromConfig(&s.config.Retry)
+
+	// Repo storage
+	var storer storage.Storer
+	var path string
+	if s.config.IsInMemory() {
+		path = ""
+		storer = &lockedStorer{Storer: memory.NewStorage()}
+	} else {
+		path = s.config.LocalPath
+		if path == "" {
+			log.DebugContext(ctx, "Preparing temp directory", slog.String("url", s.config.URL))
+			path, err = fsutil.TempDir(osEnv.Fs, "", fmt.Sprintf("%s-%s", config.AppName, s.id))
+			if err != nil {
+				return s.error("failed to create temporary directory", err)
+			}
+			s.tempDirPath = path // stored for clean-up later
+		}
+
+		s.pathFs, err = osEnv.Fs.Chroot(path)
+		if err != nil {
+			return s.error(fmt.Sprintf("failed to chroot path '%s'", path), err)
+		}
+		storer = filesystem.NewStorage(s.pathFs, cache.NewObjectLRUDefault())
+	}
+
+	// Initialize repo
+	log.DebugContext(ctx, "initializing repo", slog.String("gitPath", path))
+	s.repo, err = git.Init(storer, nil)
+	if err != nil && err != git.ErrRepositoryAlreadyExists {
+		return s.error("failed to initialize repo", err)
+	}
+	if err == git.ErrRepositoryAlreadyExists {
+		log.DebugContext(ctx, "opening repo", slog.String("path", path))
+		s.repo, err = git.Open(storer, nil)
+		if err != nil {
+			return s.error(fmt.Sprintf("failed to open path %s", path), err)
+		}
+	}
+
+	// Sync state
+	localPath := s.config.LocalPath
+	if s.config.IsInMemory() {
+		localPath = ""
+	}
+	statePath := stateFilePath(stateDir, s.id, localPath)
+	if stateErr := s.state.load(osEnv.Fs, statePath); stateErr != nil {
+		log.WarnContext(ctx, "failed to load sync state, pushing all refs", slog.Any("error", stateErr))
+	}
+
+	// Prepare source remote
+	if !s.isRemote() {
+		log.InfoContext(ctx, "no remote specified, fetch will be skipped", slog.String("source", s.id))
+		return nil
+	}
+	if err = prepareRemote(ctx, s.repo, s.id, s.config, log); err != nil {
+		return s.error("failed to prepare remote", err)
+	}
+	return nil
+}
+
+func (s *sourceRepo) clean(fs billy.Filesystem) error {
+	if s.tempDirPath != "" {
+		err := fsutil.RemoveAll(fs, s.tempDirPath)
+		if err != nil {
+			return fmt.Errorf(
+				"failed to clean up temp directory '%s': %w",
+				s.tempDirPath, err,
+			)
+		}
+	}
+	return nil
+}
+
+// prepareTarget sets up a remote for the target repository.
+func (s *sourceRepo) prepareTarget(
+	ctx context.Context,
+	targetId string,
+	targetRepoConfig *config.Repository,
+	log *slog.Logger,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return prepareRemote(ctx, s.repo, targetId, targetRepoConfig, log)
+}
+
+// open provides shared access to the local repository.
+// The returned function must be called to release the access.
+func (s *sourceRepo) open() (*git.Repository, func(), error) {
+	s.mu.RLock()
+	if s.pathFs == nil {
+		return s.repo, s.mu.RUnlock, nil
+	}
+
+	// Filesystem storage caches the object index, so a fresh storage is
+	// needed to see the latest fetch. It also allows concurrent readers
+	// to use their own caches.
+	repo, err := git.Open(filesystem.NewStorage(s.pathFs, cache.NewObjectLRUDefault()), nil)
+	if err != nil {
+		s.mu.RUnlock()
+		return nil, nil, err
+	}
+	return repo, s.mu.RUnlock, nil
+}
+
+// localRefs lists the refs in the local repository.
+func (s *sourceRepo) localRefs() ([]*plumbing.Reference, error) {
+	repo, release, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	refIter, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("local ref iterator error: %w", err)
+	}
+	refs := make([]*plumbing.Reference, 0, 10)
+	_ = refIter.ForEach(func(ref *plumbing.Reference) error {
+		refs = append(refs, ref)
+		return nil
+	})
+	return refs, nil
+}
+
+// listRefs lists the refs in the source remote. The refs listed by another
+// sync are reused when they were listed after the given time.
+func (s *sourceRepo) listRefs(
+	ctx context.Context,
+	since time.Time,
+) ([]*plumbing.Reference, error) {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+	log := logging.FromContext(ctx)
+
+	if s.listedAt.After(since) {
+		log.DebugContext(ctx, "reusing listed refs", slog.Time("listedAt", s.listedAt))
+		return s.refs, nil
+	}
+
+	remote, err := s.repo.Remote(s.id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote '%s': %w", s.id, err)
+	}
+	auth, err := s.auth.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	log.DebugContext(ctx, "listing refs")
+	var refs []*plumbing.Reference
+	err = s.retry.run(ctx, func(ctx context.Context) (err error) {
+		refs, err = remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+		if err == transport.ErrEmptyRemoteRepository {
+			return retry.Cancel(err)
+		}
+		return
+	})
+	if err == transport.ErrEmptyRemoteRepository {
+		log.DebugContext(ctx, "remote is empty")
+		refs, err = nil, nil
+	}
+	if err != nil {
+		s.auth.reject(ctx, auth, err)
+		return nil, fmt.Errorf("failed to list refs for remote '%s': %w", s.id, err)
+	}
+
+	s.refs = refs
+	s.listedAt = time.Now()
+	return refs, nil
+}
+
+// fetch fetches all of the branches and tags, and the given other refs
+// from the source remote. The fetch is skipped when another sync has
+// fetched the same refs after the given time.
+func (s *sourceRepo) fetch(
+	ctx context.Context,
+	since time.Time,
+	otherRefs []string,
+) error {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+	log := logging.FromContext(ctx)
+
+	fetchedRecently := s.fetchedAt.After(since)
+	if fetchedRecently && isSubset(otherRefs, s.fetchedRefs) {
+		log.DebugContext(ctx, "reusing fetched refs", slog.Time("fetchedAt", s.fetchedAt))
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remote, err := s.repo.Remote(s.id)
+	if err != nil {
+		return fmt.Errorf("failed to get remote '%s': %w", s.id, err)
+	}
+
+	auth, err := s.auth.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	fetchOptions := s.fetchOptions
+	fetchOptions.Auth = auth
+	fetchOptions.RefSpecs = slices.Clone(s.fetchOptions.RefSpecs)
+	for _, refName := range otherRefs {
+		fetchOptions.RefSpecs = append(fetchOptions.RefSpecs, refSpecForFetch(refName))
+	}
+
+	log.DebugContext(ctx, "fetch latest commits for source remote")
+	fetchStart := time.Now()
+	err = s.retry.run(ctx, func(ctx context.Context) error {
+		err := remote.FetchContext(ctx, &fetchOptions)
+		if err == git.NoErrAlreadyUpToDate {
+			return nil
+		}
+		return err
+	})
+	syncMetrics.recordFetchDuration(s.id, time.Since(fetchStart))
+	if err != nil {
+		s.auth.reject(ctx, auth, err)
+		return err
+	}
+
+	if fetchedRecently {
+		s.fetchedRefs = append(s.fetchedRefs, otherRefs...)
+	} else {
+		s.fetchedRefs = slices.Clone(otherRefs)
+	}
+	s.fetchedAt = time.Now()
+	return nil
+}
+
+func isSubset(items []string, set []string) bool {
+	for _, item := range items {
+		if !slices.Contains(set, item) {
+			return false
+		}
+	}
+	return true
+}
+
+// lockedStorer guards the refs in a storage that doesn't support
+// concurrent access. Pushes update the remote refs while other
+// pushes may be reading them.
+type lockedStorer struct {
+	storage.Storer
+	refMu sync.RWMutex
+}
+
+func (s *lockedStorer) SetReference(ref *plumbing.Reference) error {
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
+	return s.Storer.SetReference(ref)
+}
+
+func (s *lockedStorer) CheckAndSetReference(new, old *plumbing.Reference) error {
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
+	return s.Storer.CheckAndSetReference(new, old)
+}
+
+func (s *lockedStorer) RemoveReference(name plumbing.ReferenceName) error {
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
+	return s.Storer.RemoveReference(name)
+}
+
+func (s *lockedStorer) PackRefs() error {
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
+	return s.Storer.PackRefs()
+}
+
+func (s *lockedStorer) Reference(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	s.refMu.RLock()
+	defer s.refMu.RUnlock()
+	return s.Storer.Reference(name)
+}
+
+func (s *lockedStorer) IterReferences() (storer.ReferenceIter, error) {
+	s.refMu.RLock()
+	defer s.refMu.RUnlock()
+	return s.Storer.IterReferences()
+}
+
+func (s *lockedStorer) CountLooseRefs() (int, error) {
+	s.refMu.RLock()
+	defer s.refMu.RUnlock()
+	return s.Storer.CountLooseRefs()
+}
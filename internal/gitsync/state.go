@@ -0,0 +1,160 @@
+package gitsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	fsutil "github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const stateFileSuffix = ".gitsync-state.json"
+
+// syncState records the objects that were last pushed from a source
+// repository to its targets by each mapping. The refs that haven't changed
+// since the last push can be left out from the next push.
+type syncState struct {
+	mu   sync.Mutex
+	fs   billy.Filesystem
+	path string
+	data syncStateData
+}
+
+type syncStateData struct {
+	// Mappings contains the pushed refs for each mapping. The key is the ID
+	// of the mapping, so that the mappings sharing the source and the targets
+	// don't overwrite each other's state.
+	Mappings map[string]mappingState `json:"mappings"`
+}
+
+type mappingState struct {
+	// Targets contains the pushed refs for each target. The key is the ID
+	// of the target repository.
+	Targets map[string]targetState `json:"targets"`
+}
+
+type targetState struct {
+	// Refs contains the object IDs of the pushed refs.
+	// The key is the full name of the ref in the target.
+	Refs map[string]string `json:"refs"`
+}
+
+// stateFilePath determines where the sync state for the given repository
+// is stored. The state is stored in the state directory when it's set, and
+// next to the local path otherwise. An empty path is returned when the state
+// can't be stored.
+func stateFilePath(stateDir string, repoId string, localPath string) string {
+	if stateDir != "" {
+		return filepath.Join(stateDir, repoId+stateFileSuffix)
+	}
+	if localPath != "" {
+		return filepath.Clean(localPath) + stateFileSuffix
+	}
+	return ""
+}
+
+// load reads the state from the given path. When the path is empty,
+// the state is only kept in memory.
+func (s *syncState) load(fs billy.Filesystem, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fs = fs
+	s.path = path
+	s.data = syncStateData{}
+	if s.path == "" {
+		return nil
+	}
+
+	bs, err := fsutil.ReadFile(s.fs, s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read sync state from '%s': %w", s.path, err)
+	}
+	if err := json.Unmarshal(bs, &s.data); err != nil {
+		return fmt.Errorf("failed to parse sync state from '%s': %w", s.path, err)
+	}
+	return nil
+}
+
+// isPushed reports whether the given object was the last one pushed
+// to the ref in the target by the mapping.
+func (s *syncState) isPushed(mappingId string, targetId string, refName string, hash plumbing.Hash) bool {
+	pushedHash, ok := s.pushedHash(mappingId, targetId, refName)
+	return ok && pushedHash == hash.String()
+}
+
+// pushedHash returns the hash of the object last pushed to the ref in the target
+// by the mapping.
+func (s *syncState) pushedHash(mappingId string, targetId string, refName string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pushedHash, ok := s.data.Mappings[mappingId].Targets[targetId].Refs[refName]
+	return pushedHash, ok
+}
+
+// update records the refs pushed to and deleted from the target by the mapping,
+// and stores the state when persistence is enabled.
+func (s *syncState) update(
+	mappingId string,
+	targetId string,
+	pushedRefs map[string]plumbing.Hash,
+	deletedRefs []string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data.Mappings == nil {
+		s.data.Mappings = make(map[string]mappingState, 10)
+	}
+	mapping, ok := s.data.Mappings[mappingId]
+	if !ok {
+		mapping.Targets = make(map[string]targetState, 10)
+		s.data.Mappings[mappingId] = mapping
+	}
+	target, ok := mapping.Targets[targetId]
+	if !ok {
+		target.Refs = make(map[string]string, len(pushedRefs))
+		mapping.Targets[targetId] = target
+	}
+	for refName, hash := range pushedRefs {
+		target.Refs[refName] = hash.String()
+	}
+	for _, refName := range deletedRefs {
+		delete(target.Refs, refName)
+	}
+
+	return s.save()
+}
+
+// save writes the state to a temporary file first, so that an interrupted
+// write doesn't leave a partial state behind.
+func (s *syncState) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	bs, err := json.Marshal(&s.data)
+	if err != nil {
+		return fmt.Errorf("failed to serialize sync state: %w", err)
+	}
+	if err := s.fs.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for sync state '%s': %w", s.path, err)
+	}
+	tempPath := s.path + ".tmp"
+	if err := fsutil.WriteFile(s.fs, tempPath, bs, 0o644); err != nil {
+		return fmt.Errorf("failed to write sync state to '%s': %w", tempPath, err)
+	}
+	if err := s.fs.Rename(tempPath, s.path); err != nil {
+		return fmt.Errorf("failed to write sync state to '%s': %w", s.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package gitsync
+
+import (
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateFilePath(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("/var/lib/gitsync/otk"+stateFileSuffix, stateFilePath("/var/lib/gitsync", "otk", "/srv/git/otk"))
+	assert.Equal("/srv/git/otk"+stateFileSuffix, stateFilePath("", "otk", "/srv/git/otk/"))
+	assert.Equal("", stateFilePath("", "otk", ""))
+}
+
+func TestSyncState(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	fs := memfs.New()
+	path := "/state/otk" + stateFileSuffix
+	hash1 := plumbing.NewHash("1111111111111111111111111111111111111111")
+	hash2 := plumbing.NewHash("2222222222222222222222222222222222222222")
+
+	var state syncState
+	require.NoError(state.load(fs, path), "load missing state")
+	assert.False(state.isPushed("mapping", "target", "refs/heads/main", hash1))
+
+	require.NoError(state.update("mapping", "target", map[string]plumbing.Hash{
+		"refs/heads/main": hash1,
+		"refs/heads/old":  hash1,
+	}, nil))
+	require.NoError(state.update("mapping", "target", map[string]plumbing.Hash{
+		"refs/heads/main": hash2,
+	}, []string{"refs/heads/old"}))
+
+	var loaded syncState
+	require.NoError(loaded.load(fs, path), "load stored state")
+	assert.True(loaded.isPushed("mapping", "target", "refs/heads/main", hash2))
+	assert.False(loaded.isPushed("mapping", "target", "refs/heads/main", hash1))
+	assert.False(loaded.isPushed("mapping", "target", "refs/heads/old", hash1))
+	assert.False(loaded.isPushed("mapping", "other", "refs/heads/main", hash2))
+	assert.False(loaded.isPushed("other", "target", "refs/heads/main", hash2), "other mapping")
+}
+
+func TestSyncStateMappingsSharingTarget(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	hash1 := plumbing.NewHash("1111111111111111111111111111111111111111")
+	hash2 := plumbing.NewHash("2222222222222222222222222222222222222222")
+
+	var state syncState
+	require.NoError(state.update("first", "target", map[string]plumbing.Hash{
+		"refs/heads/main": hash1,
+	}, nil))
+	require.NoError(state.update("second", "target", map[string]plumbing.Hash{
+		"refs/heads/main": hash2,
+	}, nil))
+
+	assert.True(state.isPushed("first", "target", "refs/heads/main", hash1))
+	assert.True(state.isPushed("second", "target", "refs/heads/main", hash2))
+
+	require.NoError(state.update("second", "target", nil, []string{"refs/heads/main"}))
+	assert.True(state.isPushed("first", "target", "refs/heads/main", hash1))
+	assert.False(state.isPushed("second", "target", "refs/heads/main", hash2))
+}
@@ -0,0 +1,189 @@
+package gitsync
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"go.lepovirta.org/otk/internal/gitsync/config"
+)
+
+const (
+	statusPath           = "/status"
+	defaultStatusHistory = 10
+	targetOutcomeSuccess = "success"
+	targetOutcomeFailure = "failure"
+	targetOutcomeSkipped = "skipped"
+)
+
+// runStatus describes the result of a single sync run.
+type runStatus struct {
+	// RunId is the ID found from the logs and the trace of the run
+	RunId string    `json:"runId"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Errors contains the errors that occurred in the source
+	Errors []errorStatus `json:"errors,omitempty"`
+
+	Targets []targetStatus `json:"targets"`
+}
+
+// targetStatus describes the result of a sync run for a single target.
+type targetStatus struct {
+	Target  string        `json:"target"`
+	Outcome string        `json:"outcome"`
+	Refs    []refChange   `json:"refs,omitempty"`
+	Errors  []errorStatus `json:"errors,omitempty"`
+}
+
+// refChange describes a ref updated in the target.
+// The old hash is empty for new refs, and the new hash is empty for deleted refs.
+type refChange struct {
+	Ref     string `json:"ref"`
+	OldHash string `json:"oldHash,omitempty"`
+	NewHash string `json:"newHash,omitempty"`
+}
+
+// errorStatus describes an error that occurred during a sync run.
+// The repository URL is left out, because it may contain credentials.
+type errorStatus struct {
+	RepoId string `json:"repoId,omitempty"`
+	Reason string `json:"reason"`
+	Cause  string `json:"cause,omitempty"`
+}
+
+func newErrorStatus(err error) errorStatus {
+	var repoErr *GitRepoError
+	if !errors.As(err, &repoErr) {
+		return errorStatus{Reason: err.Error()}
+	}
+	status := errorStatus{
+		RepoId: repoErr.RepoId,
+		Reason: repoErr.Reason,
+	}
+	if repoErr.Cause != nil {
+		status.Cause = repoErr.Cause.Error()
+	}
+	return status
+}
+
+func newErrorStatuses(errs []error) []errorStatus {
+	statuses := make([]errorStatus, 0, len(errs))
+	for _, err := range errs {
+		statuses = append(statuses, newErrorStatus(err))
+	}
+	return statuses
+}
+
+// runStatus describes the sync result for the status API.
+// The targets are considered skipped when the pushes were not attempted
+// due to an error in the source.
+func (r *syncResult) runStatus(gs *GitSync, start time.Time, end time.Time) runStatus {
+	run := runStatus{
+		Start:   start,
+		End:     end,
+		Targets: make([]targetStatus, 0, len(r.targetIds)),
+	}
+	if r.sourceErr != nil {
+		run.Errors = []errorStatus{newErrorStatus(r.sourceErr)}
+	}
+	for _, targetId := range r.targetIds {
+		target := targetStatus{
+			Target:  targetId,
+			Outcome: targetOutcomeSuccess,
+			Refs:    r.targetRefs[targetId],
+		}
+		if errs := r.targetErrs[targetId]; len(errs) > 0 {
+			target.Outcome = targetOutcomeFailure
+			target.Errors = newErrorStatuses(errs)
+		} else if r.sourceErr != nil && !r.pushed {
+			target.Outcome = targetOutcomeSkipped
+		}
+		run.Targets = append(run.Targets, target)
+	}
+	return run
+}
+
+// runHistory contains the latest runs of a sync.
+type runHistory struct {
+	mu sync.Mutex
+
+	// limit is the maximum number of runs kept
+	limit int
+
+	// runs contains the runs from the oldest to the latest
+	runs []runStatus
+}
+
+func (h *runHistory) add(run runStatus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	limit := h.limit
+	if limit <= 0 {
+		limit = defaultStatusHistory
+	}
+	h.runs = append(h.runs, run)
+	if len(h.runs) > limit {
+		h.runs = slices.Delete(h.runs, 0, len(h.runs)-limit)
+	}
+}
+
+// list returns the runs from the latest to the oldest.
+func (h *runHistory) list() []runStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	runs := make([]runStatus, len(h.runs))
+	copy(runs, h.runs)
+	slices.Reverse(runs)
+	return runs
+}
+
+// mappingStatus describes the latest runs of a single mapping.
+type mappingStatus struct {
+	Id      string      `json:"id"`
+	Source  string      `json:"source"`
+	Targets []string    `json:"targets"`
+	Runs    []runStatus `json:"runs"`
+}
+
+type statusResponse struct {
+	Mappings []mappingStatus `json:"mappings"`
+}
+
+// statusHandler reports the latest runs of each mapping.
+type statusHandler struct {
+	mappings []config.SyncMapping
+	gitSyncs []*GitSync
+}
+
+func newStatusHandler(cfg *config.Config, gitSyncs []*GitSync) *statusHandler {
+	return &statusHandler{
+		mappings: cfg.Mappings,
+		gitSyncs: gitSyncs,
+	}
+}
+
+func (h *statusHandler) status() statusResponse {
+	res := statusResponse{
+		Mappings: make([]mappingStatus, len(h.mappings)),
+	}
+	for i, mapping := range h.mappings {
+		res.Mappings[i] = mappingStatus{
+			Id:      mapping.Id,
+			Source:  mapping.Source,
+			Targets: mapping.Targets,
+			Runs:    h.gitSyncs[i].history.list(),
+		}
+	}
+	return res
+}
+
+func (h *statusHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	res := h.status()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&res)
+}
@@ -0,0 +1,118 @@
+package gitsync
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+)
+
+func TestSyncResultRunStatus(t *testing.T) {
+	assert := assert.New(t)
+	gs := GitSync{
+		pushOptions: map[string]git.PushOptions{
+			"otk-gitlab": {},
+			"otk-ssh":    {},
+		},
+	}
+	start := time.Now()
+	end := start.Add(time.Second)
+
+	// Push to one of the targets failed
+	res := syncResult{
+		targetIds: gs.targetIds(),
+		pushed:    true,
+		targetRefs: map[string][]refChange{
+			"otk-gitlab": {{Ref: "refs/heads/main", OldHash: "a", NewHash: "b"}},
+		},
+		targetErrs: map[string][]error{
+			"otk-ssh": {&GitRepoError{
+				RepoId: "otk-ssh",
+				Reason: "failed to push to remote",
+				Cause:  errors.New("connection refused"),
+			}},
+		},
+	}
+	run := res.runStatus(&gs, start, end)
+	assert.Equal(start, run.Start)
+	assert.Equal(end, run.End)
+	assert.Empty(run.Errors)
+	assert.Equal([]targetStatus{
+		{
+			Target:  "otk-gitlab",
+			Outcome: targetOutcomeSuccess,
+			Refs:    []refChange{{Ref: "refs/heads/main", OldHash: "a", NewHash: "b"}},
+		},
+		{
+			Target:  "otk-ssh",
+			Outcome: targetOutcomeFailure,
+			Errors: []errorStatus{{
+				RepoId: "otk-ssh",
+				Reason: "failed to push to remote",
+				Cause:  "connection refused",
+			}},
+		},
+	}, run.Targets)
+
+	// Source failed before pushing
+	res = syncResult{
+		targetIds: gs.targetIds(),
+		sourceErr: &GitRepoError{RepoId: "otk-github", Reason: "failed to fetch from remote"},
+	}
+	run = res.runStatus(&gs, start, end)
+	assert.Equal([]errorStatus{{RepoId: "otk-github", Reason: "failed to fetch from remote"}}, run.Errors)
+	for _, target := range run.Targets {
+		assert.Equal(targetOutcomeSkipped, target.Outcome, target.Target)
+	}
+}
+
+func TestRunHistory(t *testing.T) {
+	assert := assert.New(t)
+	history := runHistory{limit: 2}
+	assert.Empty(history.list())
+
+	start := time.Now()
+	for i := range 3 {
+		history.add(runStatus{Start: start.Add(time.Duration(i) * time.Second)})
+	}
+	runs := history.list()
+	assert.Len(runs, 2)
+	assert.Equal(start.Add(2*time.Second), runs[0].Start)
+	assert.Equal(start.Add(time.Second), runs[1].Start)
+}
+
+func TestStatusHandler(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	cfg := config.Config{
+		Mappings: []config.SyncMapping{
+			{Id: "otk-github->otk-gitlab", Source: "otk-github", Targets: []string{"otk-gitlab"}},
+			{Id: "keruu", Source: "keruu-github", Targets: []string{"keruu-gitlab"}},
+		},
+	}
+	gitSyncs := []*GitSync{{}, {}}
+	gitSyncs[1].history.add(runStatus{
+		Targets: []targetStatus{{Target: "keruu-gitlab", Outcome: targetOutcomeSuccess}},
+	})
+
+	rec := httptest.NewRecorder()
+	newStatusHandler(&cfg, gitSyncs).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, statusPath, nil))
+	assert.Equal(http.StatusOK, rec.Code)
+
+	var body statusResponse
+	require.NoError(json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Len(body.Mappings, 2)
+	assert.Equal("otk-github->otk-gitlab", body.Mappings[0].Id)
+	assert.Equal("otk-github", body.Mappings[0].Source)
+	assert.Empty(body.Mappings[0].Runs)
+	assert.Equal([]string{"keruu-gitlab"}, body.Mappings[1].Targets)
+	require.Len(body.Mappings[1].Runs, 1)
+	assert.Equal(targetOutcomeSuccess, body.Mappings[1].Runs[0].Targets[0].Outcome)
+}
@@ -5,45 +5,67 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"math/rand/v2"
 	"net/http"
 	"slices"
 	"strings"
 	"time"
 
-	"github.com/go-git/go-billy/v5"
-	fsutil "github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5"
 	gitconf "github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/cache"
-	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/client"
 	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
-	"github.com/go-git/go-git/v5/storage"
-	"github.com/go-git/go-git/v5/storage/filesystem"
-	"github.com/go-git/go-git/v5/storage/memory"
 	"go.lepovirta.org/otk/internal/gitsync/config"
 	"go.lepovirta.org/otk/internal/logging"
 	"go.lepovirta.org/otk/internal/matcher"
 	"go.lepovirta.org/otk/internal/osenv"
+	"go.lepovirta.org/otk/internal/retry"
+	"go.lepovirta.org/otk/internal/tracing"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
-	refPrefixBranch = "refs/heads/"
-	refPrefixTag    = "refs/tags/"
-	refSpecFetch    = "refs/heads/*:refs/heads/*"
+	refPrefixBranch      = "refs/heads/"
+	refPrefixTag         = "refs/tags/"
+	refSpecFetchBranches = "+refs/heads/*:refs/heads/*"
+	refSpecFetchTags     = "+refs/tags/*:refs/tags/*"
+
+	// maxDeferral is how long to wait when no allowed sync time is found.
+	maxDeferral = 24 * time.Hour
 )
 
+// SyncOptions specifies how the syncs are run.
+type SyncOptions struct {
+	// Concurrency is the maximum number of targets pushed to at the same time.
+	Concurrency int
+
+	// ForceFullSync pushes all of the matching refs to the targets
+	// even when they haven't changed since the last push.
+	ForceFullSync bool
+
+	// StatusHistory is the number of the latest runs kept for the status API.
+	StatusHistory int
+
+	// IgnoreWindows runs the syncs even when they are outside of
+	// the sync windows or during blackouts.
+	IgnoreWindows bool
+}
+
 type GitSync struct {
 	repoConfigs      map[string]config.Repository
 	mapping          *config.SyncMapping
-	repo             *git.Repository
-	fetchOptions     git.FetchOptions
-	listOptions      git.ListOptions
+	source           *sourceRepo
 	pushOptions      map[string]git.PushOptions
+	targetAuths      map[string]authProvider
+	targetRetries    map[string]retryPolicy
 	sourceRepoConfig *config.Repository
-	tempDirPath      string
+	options          SyncOptions
+	wake             chan struct{}
+	status           loopStatus
+	history          runHistory
 }
 
 func (gs *GitSync) sourceRepoError(reason string, cause error) *GitRepoError {
@@ -69,26 +91,44 @@ func (gs *GitSync) getLogger(ctx context.Context) *slog.Logger {
 func (gs *GitSync) Init(
 	ctx context.Context,
 	osEnv *osenv.OsEnv,
+	sources *SourceCache,
 	repoConfigs map[string]config.Repository,
 	mapping *config.SyncMapping,
+	options SyncOptions,
 ) (err error) {
-	err = gs.init(ctx, osEnv, repoConfigs, mapping)
+	ctx, span := tracing.Start(
+		ctx,
+		spanInit,
+		tracing.String(attrSourceId, mapping.Source),
+		tracing.Strings(attrTargetIds, mapping.Targets),
+	)
+	defer func() { endSpan(span, err) }()
+
+	err = gs.init(ctx, osEnv, sources, repoConfigs, mapping, options)
 	if err != nil {
 		log := gs.getLogger(ctx)
 		log.ErrorContext(ctx, "init failed", slog.Any("error", err))
+		return
 	}
+	gs.status.setInitialized()
 	return
 }
 
 func (gs *GitSync) init(
 	ctx context.Context,
 	osEnv *osenv.OsEnv,
+	sources *SourceCache,
 	repoConfigs map[string]config.Repository,
 	mapping *config.SyncMapping,
+	options SyncOptions,
 ) (err error) {
 	var ok bool
 	gs.repoConfigs = repoConfigs
 	gs.mapping = mapping
+	gs.options = options
+	gs.options.Concurrency = max(options.Concurrency, 1)
+	gs.wake = make(chan struct{}, 1)
+	gs.history.limit = options.StatusHistory
 
 	// Use custom HTTP client
 	httpClient := http.Client{
@@ -108,80 +148,21 @@ func (gs *GitSync) init(
 	// getLogger depends on the above fields, so we can't call it earlier
 	log := gs.getLogger(ctx)
 
-	// Source authentication
-	var sourceAuth transport.AuthMethod
-	sourceAuth, err = configToAuth(osEnv.Fs, gs.sourceRepoConfig, log)
+	// Source repo is shared between the mappings
+	gs.source, err = sources.get(
+		logging.AddToContext(ctx, log),
+		osEnv,
+		gs.mapping.Source,
+		gs.sourceRepoConfig,
+	)
 	if err != nil {
-		err = gs.sourceRepoError("failed to configure auth", err)
-		return
-	}
-	gs.fetchOptions = git.FetchOptions{
-		RemoteURL:  gs.sourceRepoConfig.URL,
-		RemoteName: gs.mapping.Source,
-		Auth:       sourceAuth,
-		Force:      true,
-		RefSpecs:   []gitconf.RefSpec{gitconf.RefSpec(refSpecFetch)},
-	}
-	gs.listOptions = git.ListOptions{
-		Auth: sourceAuth,
-	}
-
-	// Repo storage
-	var storer storage.Storer
-	var path string
-	if gs.sourceRepoConfig.InMemory {
-		path = ""
-		storer = memory.NewStorage()
-	} else {
-		path = gs.sourceRepoConfig.LocalPath
-		if path == "" {
-			log.DebugContext(ctx, "Preparing temp directory", slog.String("url", gs.sourceRepoConfig.URL))
-			path, err = fsutil.TempDir(osEnv.Fs, "", fmt.Sprintf("%s-%s", config.AppName, gs.mapping.Source))
-			if err != nil {
-				err = gs.sourceRepoError("failed to create temporary directory", err)
-				return
-			}
-			gs.tempDirPath = path // stored for clean-up later
-		}
-
-		var pathFs billy.Filesystem
-		pathFs, err = osEnv.Fs.Chroot(path)
-		if err != nil {
-			err = gs.sourceRepoError(fmt.Sprintf("failed to chroot path '%s'", path), err)
-			return
-		}
-		storer = filesystem.NewStorage(pathFs, cache.NewObjectLRUDefault())
-	}
-
-	// Initialize repo
-	log.DebugContext(ctx, "initializing repo", slog.String("gitPath", path))
-	gs.repo, err = git.Init(storer, nil)
-	if err != nil && err != git.ErrRepositoryAlreadyExists {
-		err = gs.sourceRepoError("failed to initialize repo", err)
 		return
 	}
-	if err == git.ErrRepositoryAlreadyExists {
-		log.DebugContext(ctx, "opening repo", slog.String("path", path))
-		gs.repo, err = git.Open(storer, nil)
-		if err != nil {
-			err = gs.sourceRepoError(fmt.Sprintf("failed to open path %s", path), err)
-			return
-		}
-	}
-
-	// Prepare source remote
-	if gs.sourceRepoConfig.URL == "" {
-		log.InfoContext(ctx, "no remote specified, fetch will be skipped", slog.String("source", gs.mapping.Source))
-	} else {
-		err = prepareRemote(ctx, gs.repo, gs.mapping.Source, gs.sourceRepoConfig, log)
-		if err != nil {
-			err = gs.sourceRepoError("failed to prepare remote", err)
-			return
-		}
-	}
 
 	// Configure targets
 	gs.pushOptions = make(map[string]git.PushOptions, len(mapping.Targets))
+	gs.targetRetries = make(map[string]retryPolicy, len(mapping.Targets))
+	gs.targetAuths = make(map[string]authProvider, len(mapping.Targets))
 	for _, targetId := range mapping.Targets {
 		targetRepoConfig, ok := gs.repoConfigs[targetId]
 		if !ok {
@@ -193,8 +174,8 @@ func (gs *GitSync) init(
 			slog.String("targetUrl", targetRepoConfig.URL),
 		)
 
-		var authMethod transport.AuthMethod
-		authMethod, err = configToAuth(osEnv.Fs, &targetRepoConfig, log)
+		var targetAuth authProvider
+		err = targetAuth.init(osEnv, targetId, &targetRepoConfig, log)
 		if err != nil {
 			err = &GitRepoError{
 				RepoId:  targetId,
@@ -207,11 +188,14 @@ func (gs *GitSync) init(
 		gs.pushOptions[targetId] = git.PushOptions{
 			RemoteName: targetId,
 			RemoteURL:  targetRepoConfig.URL,
-			Auth:       authMethod,
 			Force:      true,
 			Atomic:     false,
 		}
-		err = prepareRemote(ctx, gs.repo, targetId, &targetRepoConfig, log)
+		gs.targetAuths[targetId] = targetAuth
+		var targetRetry retryPolicy
+		targetRetry.fromConfig(&targetRepoConfig.Retry)
+		gs.targetRetries[targetId] = targetRetry
+		err = gs.source.prepareTarget(ctx, targetId, &targetRepoConfig, log)
 		if err != nil {
 			err = &GitRepoError{
 				RepoId:  targetId,
@@ -226,208 +210,770 @@ func (gs *GitSync) init(
 	return nil
 }
 
-func (gs *GitSync) Clean(fs billy.Filesystem) error {
-	if gs.tempDirPath != "" {
-		err := fsutil.RemoveAll(fs, gs.tempDirPath)
-		if err != nil {
-			return fmt.Errorf(
-				"failed to clean up temp directory '%s': %w",
-				gs.tempDirPath, err,
-			)
-		}
-	}
-	return nil
-}
-
 func (gs *GitSync) RunInLoop(ctx context.Context) error {
 	log := gs.getLogger(ctx)
 	ctx = logging.AddToContext(ctx, log)
+	pollInterval := gs.mapping.PollInterval.Duration
 	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var poller changePoller
+
+	// The first sync is run immediately
+	var nextSync time.Time
+
+	// Requests received while the sync is deferred are run once it's allowed
+	wakePending := false
 
 	for {
+		woken := false
 		select {
 		case <-timer.C:
-			if err := gs.RunOnce(ctx); err != nil {
-				log.ErrorContext(ctx, "sync failed", slog.Any("error", err))
-			}
-			interval := gs.mapping.Interval.Duration
-			if interval <= 0 {
-				interval = time.Hour
-			}
-			log.DebugContext(ctx, "next sync", slog.Duration("interval", interval))
-			timer.Reset(gs.mapping.Interval.Duration)
+		case <-gs.wake:
+			log.InfoContext(ctx, "sync requested")
+			woken = true
 		case <-ctx.Done():
-			if !timer.Stop() {
-				<-timer.C
-			}
 			return nil
 		}
+
+		now := time.Now()
+		if wait, deferred := gs.deferUntilAllowed(ctx, now); deferred {
+			wakePending = wakePending || woken
+			timer.Reset(wait)
+			continue
+		}
+		woken = woken || wakePending
+		wakePending = false
+
+		gs.status.startIteration(now)
+		attempted := true
+		if pollInterval > 0 {
+			// Polling also records the refs for the requested syncs,
+			// so that the following polls can detect the changes.
+			due := !now.Before(nextSync)
+			attempted = gs.syncIfChanged(ctx, &poller, now, due, woken)
+		} else {
+			_ = gs.runInLoop(ctx, now, gs.targetIds())
+		}
+		if attempted {
+			nextSync = gs.nextSync(now)
+		}
+		gs.status.endIteration(time.Now(), attempted)
+
+		wait := time.Until(nextSync)
+		if pollInterval > 0 {
+			wait = min(wait, pollInterval)
+		}
+		wait = max(wait, 0)
+		log.DebugContext(
+			ctx,
+			"next sync",
+			slog.String("schedule", gs.mapping.ScheduleString()),
+			slog.Time("nextSync", nextSync),
+			slog.Duration("wait", wait),
+		)
+		timer.Reset(wait)
+	}
+}
+
+// syncIfChanged polls the source and runs the sync when the source has changed,
+// the sync is due, or the sync was requested. Reports whether the sync was run.
+// When only the source has changed, the sync is limited to the targets that
+// haven't received the changes. The polled refs are recorded as synced only for
+// the targets that succeed, so that a failed target is retried on the next poll
+// without syncing the rest of the targets again.
+func (gs *GitSync) syncIfChanged(
+	ctx context.Context,
+	poller *changePoller,
+	now time.Time,
+	due bool,
+	woken bool,
+) bool {
+	targetIds := poller.changedTargets(ctx, gs, now, due || woken)
+	if len(targetIds) == 0 {
+		return false
+	}
+	res := gs.runInLoop(ctx, now, targetIds)
+	poller.synced(targetIds, &res)
+	return true
+}
+
+// nextSync returns the time of the sync following the one started
+// at the given time. A random jitter is added when configured.
+func (gs *GitSync) nextSync(last time.Time) time.Time {
+	next := gs.mapping.NextSync(last)
+	if jitter := gs.mapping.Jitter.Duration; jitter > 0 {
+		next = next.Add(rand.N(jitter))
 	}
+	return next
+}
+
+// deferUntilAllowed checks whether the sync is allowed at the given time
+// based on the sync windows and the blackouts. When it's not allowed,
+// the duration to wait until it's allowed again is returned.
+func (gs *GitSync) deferUntilAllowed(ctx context.Context, now time.Time) (time.Duration, bool) {
+	if gs.options.IgnoreWindows || gs.mapping.SyncAllowed(now) {
+		return 0, false
+	}
+	log := logging.FromContext(ctx)
+	allowedAt, ok := gs.mapping.NextAllowedSync(now)
+	if ok {
+		log.InfoContext(ctx, "sync deferred until the next sync window", slog.Time("allowedAt", allowedAt))
+	} else {
+		allowedAt = now.Add(maxDeferral)
+		log.WarnContext(ctx, "no sync window found within a year, sync deferred", slog.Time("allowedAt", allowedAt))
+	}
+	gs.status.setDeferred(allowedAt)
+	return allowedAt.Sub(now), true
+}
+
+// Wake requests the sync running in a loop to run immediately.
+// The request is ignored when the sync is not running in a loop.
+func (gs *GitSync) Wake() {
+	select {
+	case gs.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runInLoop runs the sync once and logs the error when it fails.
+// The error is returned, so that the loop can tell whether the sync succeeded.
+func (gs *GitSync) runInLoop(ctx context.Context, since time.Time, targetIds []string) syncResult {
+	res := gs.runTargets(ctx, since, targetIds)
+	if err := res.err(); err != nil {
+		logging.FromContext(ctx).ErrorContext(ctx, "sync failed", slog.Any("error", err))
+	}
+	return res
 }
 
 func (gs *GitSync) RunOnce(ctx context.Context) error {
-	var branches, tags []string
-	var err error
-	log := gs.getLogger(ctx)
+	return gs.runOnce(ctx, time.Now())
+}
+
+// runOnce syncs the source to all of the targets. The refs listed and fetched
+// from the source by other syncs after the given time are reused.
+func (gs *GitSync) runOnce(ctx context.Context, since time.Time) error {
+	res := gs.runTargets(ctx, since, gs.targetIds())
+	return res.err()
+}
+
+// runTargets syncs the source to the given targets.
+func (gs *GitSync) runTargets(ctx context.Context, since time.Time, targetIds []string) (res syncResult) {
+	ctx, span := tracing.Start(
+		ctx,
+		spanRun,
+		tracing.String(attrSourceId, gs.mapping.Source),
+		tracing.Strings(attrTargetIds, targetIds),
+	)
+	defer func() { endSpan(span, res.err()) }()
+
+	// All of the logs in the run are tagged with the same ID
+	runId := newRunId(span)
+	span.SetAttributes(tracing.String(attrRunId, runId))
+	log := gs.getLogger(ctx).With(slog.String("runId", runId))
 	ctx = logging.AddToContext(ctx, log)
 
-	if gs.fetchOptions.RemoteURL == "" {
-		// Local branches and tags
-		branches, tags, err = gs.getLocalBranchesAndTags()
-		if err != nil {
-			return gs.sourceRepoError("failed to query local", err)
+	start := time.Now()
+	res = gs.sync(ctx, since, targetIds)
+	syncMetrics.recordResults(gs, res.targetIds, res.sourceErr, res.targetErrs)
+	run := res.runStatus(gs, start, time.Now())
+	run.RunId = runId
+	gs.history.add(run)
+	return
+}
+
+// syncResult contains the outcome of a single sync run.
+type syncResult struct {
+	// targetIds contains the targets synced in the run
+	targetIds []string
+
+	// sourceErr is the error that occurred while getting or mapping the source refs
+	sourceErr error
+
+	// pushed is true when the pushes to the targets were attempted
+	pushed bool
+
+	// targetRefs contains the refs updated in each target
+	targetRefs map[string][]refChange
+
+	// targetErrs contains the errors for each target that failed
+	targetErrs map[string][]error
+}
+
+func (r *syncResult) err() error {
+	errs := make([]error, 0, len(r.targetErrs)+1)
+	errs = append(errs, r.sourceErr)
+	for _, targetId := range slices.Sorted(maps.Keys(r.targetErrs)) {
+		errs = append(errs, r.targetErrs[targetId]...)
+	}
+	return errors.Join(errs...)
+}
+
+func (gs *GitSync) sync(ctx context.Context, since time.Time, targetIds []string) (res syncResult) {
+	log := logging.FromContext(ctx)
+	res.targetIds = targetIds
+
+	refs, err := gs.getSourceRefs(ctx, since)
+	if err != nil {
+		res.sourceErr = err
+		return
+	}
+	syncMetrics.recordMatchedRefs(gs, targetIds, &refs)
+	tracing.SpanFromContext(ctx).SetAttributes(
+		tracing.Int(attrBranchCount, len(refs.branches)),
+		tracing.Int(attrTagCount, len(refs.tags)),
+		tracing.Int(attrOtherRefCount, len(refs.others)),
+	)
+
+	// Nothing to sync. This also guards pruning from wiping
+	// the targets when the source is empty.
+	if refs.isEmpty() {
+		log.DebugContext(ctx, "no matching refs found in source")
+		return
+	}
+
+	// The refs left out due to failed mapping would be pruned
+	// from the targets, so pruning is skipped until the mapping is fixed.
+	prune := gs.mapping.Prune
+	updates, err := gs.mapRefs(&refs)
+	if err != nil {
+		log.ErrorContext(ctx, "ref mapping failed", slog.Any("error", err))
+		res.sourceErr = gs.sourceRepoError("failed to map refs", err)
+		if prune {
+			log.WarnContext(ctx, "skipping pruning due to failed ref mapping")
+			prune = false
 		}
-	} else {
-		// Remote branches and tags
-		log.DebugContext(ctx, "get source remote")
-		sourceRemote, err := gs.repo.Remote(gs.mapping.Source)
+	}
+
+	res.pushed = true
+	res.targetRefs, res.targetErrs = gs.pushToTargets(ctx, targetIds, updates, prune)
+	return
+}
+
+// getSourceRefs finds the refs in the source that match the mapping.
+// The matching refs are fetched when the source is a remote.
+func (gs *GitSync) getSourceRefs(ctx context.Context, since time.Time) (refs sourceRefs, err error) {
+	log := logging.FromContext(ctx)
+
+	if !gs.source.isRemote() {
+		// Local refs
+		err = gs.getLocalRefs(&refs)
 		if err != nil {
-			return gs.sourceRepoError("failed to query remote", err)
+			return refs, gs.sourceRepoError("failed to query local", err)
 		}
+		return refs, nil
+	}
 
-		log.DebugContext(ctx, "fetch latest commits for source remote")
-		err = sourceRemote.FetchContext(ctx, &gs.fetchOptions)
-		if err != nil && err != git.NoErrAlreadyUpToDate {
-			return gs.sourceRepoError("failed to fetch from remote", err)
-		}
+	// Remote refs
+	log.DebugContext(ctx, "get refs for source remote")
+	listCtx, span := tracing.Start(ctx, spanListRefs, tracing.String(attrSourceId, gs.mapping.Source))
+	remoteRefs, err := gs.source.listRefs(listCtx, since)
+	if err != nil {
+		err = gs.sourceRepoError(failureReason(err, "failed to fetch branches and tags"), err)
+		endSpan(span, err)
+		return refs, err
+	}
+	span.SetAttributes(tracing.Int(attrRefCount, len(remoteRefs)))
+	endSpan(span, nil)
+	for _, ref := range remoteRefs {
+		gs.selectRef(&refs, ref)
+	}
+	gs.logFoundRefs(ctx, &refs)
 
-		log.DebugContext(ctx, "get branches and tags for source remote")
-		branches, tags, err = gs.getRemoteBranchesAndTags(ctx, sourceRemote)
+	if !refs.isEmpty() {
+		fetchCtx, span := tracing.Start(
+			ctx,
+			spanFetch,
+			tracing.String(attrSourceId, gs.mapping.Source),
+			tracing.Int(attrBranchCount, len(refs.branches)),
+			tracing.Int(attrTagCount, len(refs.tags)),
+			tracing.Int(attrOtherRefCount, len(refs.others)),
+		)
+		err = gs.source.fetch(fetchCtx, since, refs.others)
 		if err != nil {
-			return gs.sourceRepoError("failed to fetch branches and tags", err)
+			err = gs.sourceRepoError(failureReason(err, "failed to fetch from remote"), err)
 		}
-		if len(branches) == 0 && len(tags) == 0 {
-			return nil
+		endSpan(span, err)
+		if err != nil {
+			return refs, err
 		}
 	}
+	return refs, nil
+}
 
-	refSpecs := make([]gitconf.RefSpec, 0, len(branches)+len(tags))
-	for _, branch := range branches {
-		refSpecs = append(refSpecs, refSpecForBranchUpdate(branch))
+// targetIds returns the IDs of all of the targets in a stable order.
+func (gs *GitSync) targetIds() []string {
+	return slices.Sorted(maps.Keys(gs.pushOptions))
+}
+
+// pushToTargets pushes the updates to the given targets using
+// at most the configured number of concurrent pushes.
+// When prune is true, the managed refs missing from the updates are deleted.
+// The updated refs are returned for each target that had changes,
+// and the errors for each target that failed.
+func (gs *GitSync) pushToTargets(
+	ctx context.Context,
+	targetIds []string,
+	updates []refUpdate,
+	prune bool,
+) (map[string][]refChange, map[string][]error) {
+	targetChanges := make([][]refChange, len(targetIds))
+	targetErrs := make([][]error, len(targetIds))
+	var eg errgroup.Group
+	eg.SetLimit(gs.options.Concurrency)
+	for i, targetId := range targetIds {
+		eg.Go(func() error {
+			// Each push gets its own view of the repo,
+			// so that the pushes don't share caches.
+			repo, release, err := gs.source.open()
+			if err != nil {
+				targetErrs[i] = []error{gs.sourceRepoError("failed to open repo", err)}
+				return nil
+			}
+			defer release()
+			targetChanges[i], targetErrs[i] = gs.pushToTarget(ctx, repo, targetId, gs.pushOptions[targetId], updates, prune)
+			return nil
+		})
 	}
-	for _, tag := range tags {
-		refSpecs = append(refSpecs, refSpecForTagUpdate(tag))
+	_ = eg.Wait()
+
+	changesByTarget := make(map[string][]refChange, len(targetIds))
+	errsByTarget := make(map[string][]error, len(targetIds))
+	for i, targetId := range targetIds {
+		if len(targetChanges[i]) > 0 {
+			changesByTarget[targetId] = targetChanges[i]
+		}
+		if len(targetErrs[i]) > 0 {
+			errsByTarget[targetId] = targetErrs[i]
+		}
 	}
+	return changesByTarget, errsByTarget
+}
 
-	errs := make(
-		[]error,
-		0,
-		len(gs.pushOptions)*len(branches)+len(gs.pushOptions)*len(tags),
+func (gs *GitSync) pushToTarget(
+	ctx context.Context,
+	repo *git.Repository,
+	targetId string,
+	targetOptions git.PushOptions,
+	updates []refUpdate,
+	prune bool,
+) (changes []refChange, errs []error) {
+	ctx, span := tracing.Start(
+		ctx,
+		spanPush,
+		tracing.String(attrSourceId, gs.mapping.Source),
+		tracing.String(attrTargetId, targetId),
 	)
-	for targetId, targetOptions := range gs.pushOptions {
-		targetRepoConfig := gs.repoConfigs[targetId]
-		log := log.With(
-			slog.String("targetId", targetId),
-			slog.String("targetUrl", targetRepoConfig.URL),
-		)
-		targetOptions.RefSpecs = refSpecs
+	defer func() { endSpan(span, errors.Join(errs...)) }()
 
-		log.DebugContext(ctx, "push to remote target")
-		err = gs.repo.PushContext(ctx, &targetOptions)
-		if err != nil && err != git.NoErrAlreadyUpToDate {
-			log.ErrorContext(ctx, "failed to push to remote", slog.Any("error", err))
-			err = &GitRepoError{
-				RepoId:  targetId,
-				RepoURL: targetRepoConfig.URL,
-				Reason:  "failed to push to remote",
-				Cause:   err,
-			}
-			errs = append(errs, err)
-		} else if err == git.NoErrAlreadyUpToDate {
-			log.DebugContext(ctx, "remote already up-to-date")
-		} else {
-			log.InfoContext(ctx, "remote update succeeded")
+	targetRepoConfig := gs.repoConfigs[targetId]
+	log := logging.FromContext(ctx).With(
+		slog.String("targetId", targetId),
+		slog.String("targetUrl", targetRepoConfig.URL),
+	)
+	targetError := func(reason string, cause error) *GitRepoError {
+		return &GitRepoError{
+			RepoId:  targetId,
+			RepoURL: targetRepoConfig.URL,
+			Reason:  reason,
+			Cause:   cause,
 		}
 	}
+	updatePolicy := gs.mapping.UpdatePolicy
+	force := updatePolicy.AllowsForce()
 
-	return errors.Join(errs...)
-}
+	targetAuth := gs.targetAuths[targetId]
+	auth, authErr := targetAuth.get(ctx)
+	if authErr != nil {
+		log.ErrorContext(ctx, reasonCredentials, slog.Any("error", authErr))
+		return nil, []error{targetError(reasonCredentials, authErr)}
+	}
+	targetOptions.Auth = auth
 
-func (gs *GitSync) getLocalBranchesAndTags() (
-	branches []string,
-	tags []string,
-	err error,
-) {
-	branches = make([]string, 0, 1000)
-	tags = make([]string, 0, 1000)
-	var refIter storer.ReferenceIter
+	var targetRefs map[string]plumbing.Hash
+	if prune || !force {
+		log.DebugContext(ctx, "list refs for remote target")
+		listCtx, listSpan := tracing.Start(ctx, spanListRefs, tracing.String(attrTargetId, targetId))
+		var err error
+		targetRefs, err = gs.listTargetRefs(listCtx, repo, targetId, &targetOptions)
+		if err != nil {
+			targetAuth.reject(ctx, auth, err)
+			log.ErrorContext(ctx, "failed to list refs for remote target", slog.Any("error", err))
+			err = targetError("failed to list refs", err)
+			endSpan(listSpan, err)
+			return nil, []error{err}
+		}
+		listSpan.SetAttributes(tracing.Int(attrRefCount, len(targetRefs)))
+		endSpan(listSpan, nil)
+	}
 
-	refIter, err = gs.repo.Branches()
-	if err != nil {
-		err = fmt.Errorf("local branch iterator error: %w", err)
-		return
+	targetOptions.Force = force
+	targetOptions.RefSpecs = make([]gitconf.RefSpec, 0, len(updates))
+	pushedRefs := make(map[string]plumbing.Hash, len(updates))
+	for _, update := range updates {
+		sourceRef, err := repo.Reference(plumbing.ReferenceName(update.source), true)
+		if err != nil {
+			log.ErrorContext(ctx, "failed to resolve ref", slog.String("ref", update.source), slog.Any("error", err))
+			errs = append(errs, gs.sourceRepoError("failed to resolve ref", err))
+			continue
+		}
+		if !gs.options.ForceFullSync && gs.source.state.isPushed(gs.mapping.Id, targetId, update.target, sourceRef.Hash()) {
+			log.DebugContext(ctx, "ref unchanged since last push, skipping", slog.String("ref", update.target))
+			continue
+		}
+		if !force {
+			targetHash, ok := targetRefs[update.target]
+			if ok {
+				fastForward, err := isFastForward(repo, update.source, targetHash)
+				if err != nil {
+					log.ErrorContext(ctx, "failed to compare ref with remote target", slog.String("ref", update.target), slog.Any("error", err))
+					errs = append(errs, targetError("failed to compare ref", err))
+					continue
+				}
+				if !fastForward {
+					log.WarnContext(ctx, "ref has diverged from source, skipping", slog.String("ref", update.target))
+					if updatePolicy == config.UpdatePolicyFastForwardOnly {
+						errs = append(errs, targetError(
+							"diverged from source",
+							fmt.Errorf("%w: %s", ErrDiverged, update.target),
+						))
+					}
+					continue
+				}
+			}
+		}
+		targetOptions.RefSpecs = append(targetOptions.RefSpecs, update.refSpec(force))
+		pushedRefs[update.target] = sourceRef.Hash()
+	}
+
+	var deletedRefs []string
+	if prune {
+		deletedRefs = gs.getPrunableRefs(targetRefs, updates)
+		for _, refName := range deletedRefs {
+			log.InfoContext(ctx, "deleting ref from remote target", slog.String("ref", refName))
+			targetOptions.RefSpecs = append(targetOptions.RefSpecs, refSpecForDelete(refName))
+		}
+	}
+
+	span.SetAttributes(
+		tracing.Int(attrPushedRefCount, len(pushedRefs)),
+		tracing.Int(attrPrunedRefCount, len(deletedRefs)),
+	)
+	if len(targetOptions.RefSpecs) == 0 {
+		log.DebugContext(ctx, "nothing to push to remote target")
+		syncMetrics.recordPushedRefs(gs, targetId, 0)
+		return nil, errs
 	}
 
-	_ = refIter.ForEach(func(ref *plumbing.Reference) error {
-		branch := ref.Name().Short()
-		if matchAny(gs.mapping.Branches, branch) {
-			branches = append(branches, branch)
+	log.DebugContext(ctx, "push to remote target")
+	targetRetry := gs.targetRetries[targetId]
+	upToDate := false
+	pushStart := time.Now()
+	err := targetRetry.run(ctx, func(ctx context.Context) error {
+		err := repo.PushContext(ctx, &targetOptions)
+		if err == git.NoErrAlreadyUpToDate {
+			upToDate = true
+			return nil
 		}
-		return nil
+		return err
 	})
+	syncMetrics.recordPushDuration(gs, targetId, time.Since(pushStart))
 
-	refIter, err = gs.repo.Tags()
 	if err != nil {
-		err = fmt.Errorf("local tags iterator error: %w", err)
-		return
+		targetAuth.reject(ctx, auth, err)
+		log.ErrorContext(ctx, "failed to push to remote", slog.Any("error", err))
+		errs = append(errs, targetError("failed to push to remote", err))
+		return nil, errs
 	}
+	targetAuth.approve(ctx, auth)
+	if upToDate {
+		log.DebugContext(ctx, "remote already up-to-date")
+	} else {
+		log.InfoContext(ctx, "remote update succeeded")
+	}
+	syncMetrics.recordPushedRefs(gs, targetId, len(targetOptions.RefSpecs))
+
+	// Changes are resolved before the state is updated,
+	// because the state may contain the previous hashes.
+	changes = gs.refChanges(targetId, targetRefs, pushedRefs, deletedRefs)
+	if err := gs.source.state.update(gs.mapping.Id, targetId, pushedRefs, deletedRefs); err != nil {
+		log.WarnContext(ctx, "failed to store sync state", slog.Any("error", err))
+	}
+	return changes, errs
+}
 
-	_ = refIter.ForEach(func(ref *plumbing.Reference) error {
-		tag := ref.Name().Short()
-		if matchAny(gs.mapping.Tags, tag) {
-			tags = append(tags, tag)
+// refChanges lists the refs updated in the target. The previous hashes are
+// taken from the target refs when they were listed, and from the sync state otherwise.
+func (gs *GitSync) refChanges(
+	targetId string,
+	targetRefs map[string]plumbing.Hash,
+	pushedRefs map[string]plumbing.Hash,
+	deletedRefs []string,
+) []refChange {
+	oldHash := func(refName string) string {
+		if targetRefs != nil {
+			if hash, ok := targetRefs[refName]; ok {
+				return hash.String()
+			}
+			return ""
 		}
-		return nil
-	})
+		hash, _ := gs.source.state.pushedHash(gs.mapping.Id, targetId, refName)
+		return hash
+	}
 
-	return
+	changes := make([]refChange, 0, len(pushedRefs)+len(deletedRefs))
+	for _, refName := range slices.Sorted(maps.Keys(pushedRefs)) {
+		change := refChange{
+			Ref:     refName,
+			OldHash: oldHash(refName),
+			NewHash: pushedRefs[refName].String(),
+		}
+		if change.OldHash != change.NewHash {
+			changes = append(changes, change)
+		}
+	}
+	for _, refName := range deletedRefs {
+		changes = append(changes, refChange{
+			Ref:     refName,
+			OldHash: oldHash(refName),
+		})
+	}
+	return changes
+}
+
+// sourceRefs contains the refs in the source that match the mapping.
+type sourceRefs struct {
+	// branches contains the short names of the matching branches
+	branches []string
+
+	// tags contains the short names of the matching tags
+	tags []string
+
+	// others contains the full names of the matching refs
+	// that are not branches or tags
+	others []string
+}
+
+func (r *sourceRefs) isEmpty() bool {
+	return len(r.branches) == 0 && len(r.tags) == 0 && len(r.others) == 0
+}
+
+func (r *sourceRefs) len() int {
+	return len(r.branches) + len(r.tags) + len(r.others)
+}
+
+// selectRef adds the ref to the source refs when it matches the mapping.
+// It reports whether the ref was added.
+func (gs *GitSync) selectRef(refs *sourceRefs, ref *plumbing.Reference) bool {
+	if ref.Type() != plumbing.HashReference {
+		return false
+	}
+	refName := ref.Name().String()
+	if after, ok := strings.CutPrefix(refName, refPrefixBranch); ok && matchAny(gs.mapping.Branches, after) {
+		refs.branches = append(refs.branches, after)
+	} else if after, ok := strings.CutPrefix(refName, refPrefixTag); ok && matchAny(gs.mapping.Tags, after) {
+		refs.tags = append(refs.tags, after)
+	} else if matchAny(gs.mapping.Refs, refName) {
+		refs.others = append(refs.others, refName)
+	} else {
+		return false
+	}
+	return true
+}
+
+func (gs *GitSync) getLocalRefs(refs *sourceRefs) error {
+	localRefs, err := gs.source.localRefs()
+	if err != nil {
+		return err
+	}
+	for _, ref := range localRefs {
+		gs.selectRef(refs, ref)
+	}
+	return nil
 }
 
-func (gs *GitSync) getRemoteBranchesAndTags(
+func (gs *GitSync) logFoundRefs(ctx context.Context, refs *sourceRefs) {
+	logging.FromContext(ctx).DebugContext(
+		ctx,
+		"found refs",
+		slog.Int("branchCount", len(refs.branches)),
+		slog.Int("tagCount", len(refs.tags)),
+		slog.Int("otherRefCount", len(refs.others)),
+		slog.String("branches", strings.Join(refs.branches, ", ")),
+		slog.String("tags", strings.Join(refs.tags, ", ")),
+		slog.String("otherRefs", strings.Join(refs.others, ", ")),
+	)
+}
+
+func (gs *GitSync) listTargetRefs(
 	ctx context.Context,
-	remote *git.Remote,
-) (branches []string, tags []string, err error) {
-	var refs []*plumbing.Reference
+	repo *git.Repository,
+	targetId string,
+	targetOptions *git.PushOptions,
+) (map[string]plumbing.Hash, error) {
 	log := logging.FromContext(ctx)
 
-	log.DebugContext(ctx, "listing refs")
-	refs, err = remote.ListContext(ctx, &gs.listOptions)
+	targetRemote, err := repo.Remote(targetId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote '%s': %w", targetId, err)
+	}
+
+	var refs []*plumbing.Reference
+	targetRetry := gs.targetRetries[targetId]
+	err = targetRetry.run(ctx, func(ctx context.Context) (err error) {
+		refs, err = targetRemote.ListContext(ctx, &git.ListOptions{
+			Auth: targetOptions.Auth,
+		})
+		if err == transport.ErrEmptyRemoteRepository {
+			return retry.Cancel(err)
+		}
+		return
+	})
 	if err == transport.ErrEmptyRemoteRepository {
 		log.DebugContext(ctx, "remote is empty")
-		return nil, nil, nil
+		return nil, nil
 	}
 	if err != nil {
-		err = fmt.Errorf("failed to list refs for remote '%s': %w", gs.mapping.Source, err)
-		return
+		return nil, fmt.Errorf("failed to list refs for remote '%s': %w", targetId, err)
 	}
 
-	branches = make([]string, 0, 1000)
-	tags = make([]string, 0, 1000)
-
+	targetRefs := make(map[string]plumbing.Hash, len(refs))
 	for _, ref := range refs {
-		refName := ref.Name().String()
-		if after, ok := strings.CutPrefix(refName, refPrefixBranch); ok {
-			branch := after
-			if matchAny(gs.mapping.Branches, branch) {
-				branches = append(branches, branch)
-			}
-		} else if after, ok := strings.CutPrefix(refName, refPrefixTag); ok {
-			tag := after
-			if matchAny(gs.mapping.Tags, tag) {
-				tags = append(tags, tag)
+		if ref.Type() == plumbing.HashReference {
+			targetRefs[ref.Name().String()] = ref.Hash()
+		}
+	}
+	return targetRefs, nil
+}
+
+// isFastForward reports whether the target ref can be updated to
+// the source ref without losing any commits in the target.
+func isFastForward(repo *git.Repository, refName string, targetHash plumbing.Hash) (bool, error) {
+	sourceRef, err := repo.Reference(plumbing.ReferenceName(refName), true)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve ref '%s': %w", refName, err)
+	}
+	if sourceRef.Hash() == targetHash {
+		return true, nil
+	}
+	if strings.HasPrefix(refName, refPrefixTag) {
+		// Tags are expected to never move
+		return false, nil
+	}
+
+	// When the commit is not found from the source,
+	// the target must contain commits that the source doesn't.
+	targetCommit, err := repo.CommitObject(targetHash)
+	if err == plumbing.ErrObjectNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get commit '%s': %w", targetHash, err)
+	}
+	sourceCommit, err := repo.CommitObject(sourceRef.Hash())
+	if err != nil {
+		return false, fmt.Errorf("failed to get commit '%s': %w", sourceRef.Hash(), err)
+	}
+	return targetCommit.IsAncestor(sourceCommit)
+}
+
+// getPrunableRefs finds the refs in the target that are managed by the mapping
+// but are no longer found from the source. When ref mappings are used,
+// only the refs that the ref mappings can produce are considered managed.
+func (gs *GitSync) getPrunableRefs(
+	targetRefs map[string]plumbing.Hash,
+	updates []refUpdate,
+) []string {
+	syncedRefs := make(map[string]struct{}, len(updates))
+	for _, update := range updates {
+		syncedRefs[update.target] = struct{}{}
+	}
+
+	ns := gs.mapping.TargetNamespace()
+	prunedRefs := make([]string, 0, 10)
+	for refName := range targetRefs {
+		if _, ok := syncedRefs[refName]; ok {
+			continue
+		}
+		if gs.isPrunableRef(ns, refName) {
+			prunedRefs = append(prunedRefs, refName)
+		}
+	}
+	slices.Sort(prunedRefs)
+	return prunedRefs
+}
+
+// mapRefs determines the names of the source refs in the target.
+// Refs that would be mapped to the same name in the target
+// are left out and reported as errors.
+func (gs *GitSync) mapRefs(refs *sourceRefs) ([]refUpdate, error) {
+	updates := make([]refUpdate, 0, refs.len())
+	for _, branch := range refs.branches {
+		updates = append(updates, refUpdate{
+			source: refPrefixBranch + branch,
+			target: refPrefixBranch + gs.mapping.MapBranch(branch),
+		})
+	}
+	for _, tag := range refs.tags {
+		updates = append(updates, refUpdate{
+			source: refPrefixTag + tag,
+			target: refPrefixTag + gs.mapping.MapTag(tag),
+		})
+	}
+	for _, refName := range refs.others {
+		updates = append(updates, refUpdate{
+			source: refName,
+			target: refName,
+		})
+	}
+	if len(gs.mapping.RefMappings) == 0 {
+		return updates, nil
+	}
+
+	sourcesByTarget := make(map[string][]string, len(updates))
+	for _, update := range updates {
+		sourcesByTarget[update.target] = append(sourcesByTarget[update.target], update.source)
+	}
+
+	var errs []error
+	validUpdates := make([]refUpdate, 0, len(updates))
+	for _, update := range updates {
+		sources := sourcesByTarget[update.target]
+		if len(sources) > 1 {
+			if sources[0] == update.source {
+				errs = append(errs, fmt.Errorf(
+					"refs %s are all mapped to %s",
+					strings.Join(slices.Sorted(slices.Values(sources)), ", "), update.target,
+				))
 			}
+			continue
+		}
+		if err := plumbing.ReferenceName(update.target).Validate(); err != nil {
+			errs = append(errs, fmt.Errorf(
+				"ref %s is mapped to invalid name %s: %w",
+				update.source, update.target, err,
+			))
+			continue
 		}
+		validUpdates = append(validUpdates, update)
 	}
+	return validUpdates, errors.Join(errs...)
+}
 
-	log.DebugContext(
-		ctx,
-		"found branches and tags",
-		slog.Int("branchCount", len(branches)),
-		slog.Int("tagCount", len(tags)),
-		slog.String("branches", strings.Join(branches, ", ")),
-		slog.String("tags", strings.Join(tags, ", ")),
-	)
-	return
+// isPrunableRef reports whether the ref in the target is produced by
+// the mapping from a source ref that matches the branch, tag, or ref lists.
+func (gs *GitSync) isPrunableRef(ns *config.TargetNamespace, refName string) bool {
+	if after, ok := strings.CutPrefix(refName, refPrefixBranch); ok {
+		isBranch := func(name string) bool { return matchAny(gs.mapping.Branches, name) }
+		if ns.Contains(config.RefMappingBranches, after, isBranch) {
+			return true
+		}
+	} else if after, ok := strings.CutPrefix(refName, refPrefixTag); ok {
+		isTag := func(name string) bool { return matchAny(gs.mapping.Tags, name) }
+		if ns.Contains(config.RefMappingTags, after, isTag) {
+			return true
+		}
+	}
+	return matchAny(gs.mapping.Refs, refName)
 }
 
 func matchAny(matchers []matcher.M, s string) bool {
@@ -477,26 +1023,34 @@ func prepareRemote(
 	return nil
 }
 
-func refSpecForBranchUpdate(branch string) gitconf.RefSpec {
-	return gitconf.RefSpec(fmt.Sprintf(
-		"+%s%s:%s%s",
-		refPrefixBranch,
-		branch,
-		refPrefixBranch,
-		branch,
-	))
+// refUpdate describes a ref in the source that is pushed to the target.
+type refUpdate struct {
+	// source is the full name of the ref in the source
+	source string
+
+	// target is the full name of the ref in the target
+	target string
+}
+
+func (u *refUpdate) refSpec(force bool) gitconf.RefSpec {
+	if force {
+		return gitconf.RefSpec(fmt.Sprintf("+%s:%s", u.source, u.target))
+	}
+	return gitconf.RefSpec(fmt.Sprintf("%s:%s", u.source, u.target))
+}
+
+func refSpecForFetch(refName string) gitconf.RefSpec {
+	return gitconf.RefSpec(fmt.Sprintf("+%s:%s", refName, refName))
 }
 
-func refSpecForTagUpdate(tag string) gitconf.RefSpec {
-	return gitconf.RefSpec(fmt.Sprintf(
-		"+%s%s:%s%s",
-		refPrefixTag,
-		tag,
-		refPrefixTag,
-		tag,
-	))
+func refSpecForDelete(refName string) gitconf.RefSpec {
+	return gitconf.RefSpec(":" + refName)
 }
 
+// ErrDiverged is used when a target ref contains commits
+// that are not found from the source ref.
+var ErrDiverged = errors.New("ref has diverged from source")
+
 type GitRepoError struct {
 	RepoId  string
 	RepoURL string
@@ -0,0 +1,553 @@
+package gitsync
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/duration"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/matcher"
+)
+
+func commitTo(
+	t *testing.T,
+	repo *git.Repository,
+	message string,
+	parents ...plumbing.Hash,
+) plumbing.Hash {
+	t.Helper()
+	tree := &object.Tree{}
+	treeObj := repo.Storer.NewEncodedObject()
+	require.NoError(t, tree.Encode(treeObj), "tree encode")
+	treeHash, err := repo.Storer.SetEncodedObject(treeObj)
+	require.NoError(t, err, "tree store")
+
+	signature := object.Signature{
+		Name:  "Git Sync",
+		Email: "gitsync@example.org",
+		When:  time.Date(2025, 4, 27, 15, 46, 0, 0, time.UTC),
+	}
+	commit := &object.Commit{
+		Author:       signature,
+		Committer:    signature,
+		Message:      message,
+		TreeHash:     treeHash,
+		ParentHashes: parents,
+	}
+	commitObj := repo.Storer.NewEncodedObject()
+	require.NoError(t, commit.Encode(commitObj), "commit encode")
+	hash, err := repo.Storer.SetEncodedObject(commitObj)
+	require.NoError(t, err, "commit store")
+	return hash
+}
+
+func TestIsFastForward(t *testing.T) {
+	repo, err := git.Init(memory.NewStorage(), nil)
+	require.NoError(t, err, "git init")
+
+	first := commitTo(t, repo, "first")
+	second := commitTo(t, repo, "second", first)
+	diverged := commitTo(t, repo, "diverged", first)
+
+	require.NoError(t, repo.Storer.SetReference(
+		plumbing.NewHashReference("refs/heads/main", second),
+	))
+	require.NoError(t, repo.Storer.SetReference(
+		plumbing.NewHashReference("refs/tags/v1", second),
+	))
+
+	tests := []struct {
+		name       string
+		refName    string
+		targetHash plumbing.Hash
+		expected   bool
+	}{
+		{"same commit", "refs/heads/main", second, true},
+		{"target behind source", "refs/heads/main", first, true},
+		{"target diverged", "refs/heads/main", diverged, false},
+		{"target commit unknown", "refs/heads/main", plumbing.NewHash("1111111111111111111111111111111111111111"), false},
+		{"same tag", "refs/tags/v1", second, true},
+		{"moved tag", "refs/tags/v1", first, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+			assert := assert.New(t)
+			fastForward, err := isFastForward(repo, test.refName, test.targetHash)
+			require.NoError(err)
+			assert.Equal(test.expected, fastForward)
+		})
+	}
+}
+
+func TestGetPrunableRefs(t *testing.T) {
+	assert := assert.New(t)
+	gs := GitSync{
+		mapping: &config.SyncMapping{
+			SyncSpec: config.SyncSpec{
+				Branches: []matcher.M{matcher.FromStringOrPanic(`/release-.*/`)},
+				Tags:     []matcher.M{matcher.FromStringOrPanic(`/v.*/`)},
+			},
+		},
+	}
+	targetRefs := map[string]plumbing.Hash{
+		"refs/heads/main":      plumbing.ZeroHash,
+		"refs/heads/release-1": plumbing.ZeroHash,
+		"refs/heads/release-2": plumbing.ZeroHash,
+		"refs/tags/v1":         plumbing.ZeroHash,
+		"refs/tags/v2":         plumbing.ZeroHash,
+		"refs/tags/other":      plumbing.ZeroHash,
+	}
+	updates := []refUpdate{
+		{source: "refs/heads/release-2", target: "refs/heads/release-2"},
+		{source: "refs/tags/v2", target: "refs/tags/v2"},
+	}
+
+	assert.Equal(
+		[]string{"refs/heads/release-1", "refs/tags/v1"},
+		gs.getPrunableRefs(targetRefs, updates),
+	)
+}
+
+func TestGetPrunableRefsWithRefMappings(t *testing.T) {
+	assert := assert.New(t)
+	gs := GitSync{
+		mapping: &config.SyncMapping{
+			SyncSpec: config.SyncSpec{
+				Branches: []matcher.M{
+					matcher.FromStringOrPanic("main"),
+					matcher.FromStringOrPanic(`/^internal/.*/`),
+				},
+				Tags: []matcher.M{matcher.FromStringOrPanic(`/^v[0-9]+$/`)},
+				RefMappings: []config.RefMapping{
+					{
+						Refs:      config.RefMappingBranches,
+						Match:     matcher.FromStringOrPanic("main"),
+						AddPrefix: "upstream/",
+					},
+					{
+						Refs:        config.RefMappingBranches,
+						Match:       matcher.FromStringOrPanic(`/^internal/feature-/`),
+						StripPrefix: "internal/",
+					},
+					{
+						Refs:    config.RefMappingTags,
+						Match:   matcher.FromStringOrPanic(`/^v([0-9]+)$/`),
+						Replace: "vendor/acme/v$1",
+					},
+				},
+			},
+		},
+	}
+	targetRefs := map[string]plumbing.Hash{
+		// The target's own branch that matches the source branch list
+		"refs/heads/main": plumbing.ZeroHash,
+		// Produced by the add prefix mapping
+		"refs/heads/upstream/main": plumbing.ZeroHash,
+		// Other branches under the prefix are not produced by the mappings
+		"refs/heads/upstream/develop": plumbing.ZeroHash,
+		// Produced by the strip prefix mapping
+		"refs/heads/feature-1": plumbing.ZeroHash,
+		"refs/heads/feature-2": plumbing.ZeroHash,
+		// Produced by the replace mapping
+		"refs/tags/vendor/acme/v1": plumbing.ZeroHash,
+		"refs/tags/vendor/acme/v2": plumbing.ZeroHash,
+		"refs/tags/vendor/acme/vX": plumbing.ZeroHash,
+		// Not produced, because the source tag would be mapped
+		"refs/tags/v3": plumbing.ZeroHash,
+	}
+	updates := []refUpdate{
+		{source: "refs/heads/internal/feature-2", target: "refs/heads/feature-2"},
+		{source: "refs/tags/v2", target: "refs/tags/vendor/acme/v2"},
+	}
+
+	assert.Equal(
+		[]string{
+			"refs/heads/feature-1",
+			"refs/heads/upstream/main",
+			"refs/tags/vendor/acme/v1",
+		},
+		gs.getPrunableRefs(targetRefs, updates),
+	)
+}
+
+func TestSelectRef(t *testing.T) {
+	assert := assert.New(t)
+	gs := GitSync{
+		mapping: &config.SyncMapping{
+			SyncSpec: config.SyncSpec{
+				Branches: []matcher.M{matcher.FromStringOrPanic("main")},
+				Tags:     []matcher.M{matcher.FromStringOrPanic(`/v.*/`)},
+				Refs: []matcher.M{
+					matcher.FromStringOrPanic("refs/notes/commits"),
+					matcher.FromStringOrPanic(`/^refs/pull/[0-9]+/head$/`),
+				},
+			},
+		},
+	}
+	refNames := []string{
+		"refs/heads/main",
+		"refs/heads/develop",
+		"refs/tags/v1.0",
+		"refs/tags/other",
+		"refs/notes/commits",
+		"refs/pull/12/head",
+		"refs/pull/12/merge",
+		"refs/meta/config",
+	}
+
+	var refs sourceRefs
+	for _, refName := range refNames {
+		gs.selectRef(&refs, plumbing.NewHashReference(plumbing.ReferenceName(refName), plumbing.ZeroHash))
+	}
+	gs.selectRef(&refs, plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main"))
+
+	assert.Equal(sourceRefs{
+		branches: []string{"main"},
+		tags:     []string{"v1.0"},
+		others:   []string{"refs/notes/commits", "refs/pull/12/head"},
+	}, refs)
+}
+
+func TestMapRefs(t *testing.T) {
+	t.Run("renames refs", func(t *testing.T) {
+		assert := assert.New(t)
+		gs := GitSync{
+			mapping: &config.SyncMapping{
+				SyncSpec: config.SyncSpec{
+					RefMappings: []config.RefMapping{
+						{
+							Refs:      config.RefMappingBranches,
+							Match:     matcher.FromStringOrPanic("main"),
+							AddPrefix: "upstream/",
+						},
+						{
+							Refs:    config.RefMappingTags,
+							Match:   matcher.FromStringOrPanic(`/^v(.*)$/`),
+							Replace: "vendor/acme/v$1",
+						},
+						{
+							StripPrefix: "internal/",
+						},
+					},
+				},
+			},
+		}
+
+		updates, err := gs.mapRefs(&sourceRefs{
+			branches: []string{"main", "internal/feature", "develop"},
+			tags:     []string{"v1.0", "internal/v2.0"},
+			others:   []string{"refs/notes/commits"},
+		})
+
+		assert.NoError(err)
+		assert.Equal([]refUpdate{
+			{source: "refs/heads/main", target: "refs/heads/upstream/main"},
+			{source: "refs/heads/internal/feature", target: "refs/heads/feature"},
+			{source: "refs/heads/develop", target: "refs/heads/develop"},
+			{source: "refs/tags/v1.0", target: "refs/tags/vendor/acme/v1.0"},
+			{source: "refs/tags/internal/v2.0", target: "refs/tags/v2.0"},
+			{source: "refs/notes/commits", target: "refs/notes/commits"},
+		}, updates)
+	})
+
+	t.Run("leaves out colliding refs", func(t *testing.T) {
+		assert := assert.New(t)
+		gs := GitSync{
+			mapping: &config.SyncMapping{
+				SyncSpec: config.SyncSpec{
+					RefMappings: []config.RefMapping{
+						{StripPrefix: "internal/"},
+					},
+				},
+			},
+		}
+
+		updates, err := gs.mapRefs(&sourceRefs{
+			branches: []string{"main", "internal/main", "develop"},
+		})
+
+		assert.Error(err)
+		assert.Equal([]refUpdate{
+			{source: "refs/heads/develop", target: "refs/heads/develop"},
+		}, updates)
+	})
+}
+
+func TestPushToTargets(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	repo, err := git.Init(&lockedStorer{Storer: memory.NewStorage()}, nil)
+	require.NoError(err, "git init")
+	first := commitTo(t, repo, "first")
+	second := commitTo(t, repo, "second", first)
+	require.NoError(repo.Storer.SetReference(
+		plumbing.NewHashReference("refs/heads/main", second),
+	))
+
+	gs := GitSync{
+		repoConfigs: map[string]config.Repository{},
+		mapping: &config.SyncMapping{
+			Source: "source",
+			SyncSpec: config.SyncSpec{
+				Branches: []matcher.M{matcher.FromStringOrPanic("main")},
+			},
+		},
+		source: &sourceRepo{
+			id:     "source",
+			config: &config.Repository{},
+			repo:   repo,
+		},
+		sourceRepoConfig: &config.Repository{},
+		pushOptions:      map[string]git.PushOptions{},
+		options:          SyncOptions{Concurrency: 2},
+	}
+	targetIds := []string{"t1", "t2", "t3", "t4"}
+	for _, targetId := range targetIds {
+		targetConfig := config.Repository{URL: t.TempDir()}
+		_, err := git.PlainInit(targetConfig.URL, true)
+		require.NoError(err, "git init target")
+		require.NoError(gs.source.prepareTarget(ctx, targetId, &targetConfig, slog.Default()))
+		gs.repoConfigs[targetId] = targetConfig
+		gs.pushOptions[targetId] = git.PushOptions{
+			RemoteName: targetId,
+			RemoteURL:  targetConfig.URL,
+		}
+	}
+
+	updates := []refUpdate{{source: "refs/heads/main", target: "refs/heads/main"}}
+	changes, errs := gs.pushToTargets(ctx, gs.targetIds(), updates, gs.mapping.Prune)
+	require.Empty(errs)
+	assert.Len(t, changes, len(targetIds))
+
+	for _, targetId := range targetIds {
+		targetRepo, err := git.PlainOpen(gs.repoConfigs[targetId].URL)
+		require.NoError(err, "git open target")
+		ref, err := targetRepo.Reference("refs/heads/main", true)
+		require.NoError(err, "target ref")
+		assert.Equal(t, second, ref.Hash(), targetId)
+	}
+}
+
+func TestPushToTargetsSkipsUnchangedRefs(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	repo, err := git.Init(memory.NewStorage(), nil)
+	require.NoError(err, "git init")
+	first := commitTo(t, repo, "first")
+	require.NoError(repo.Storer.SetReference(
+		plumbing.NewHashReference("refs/heads/main", first),
+	))
+
+	targetConfig := config.Repository{URL: t.TempDir()}
+	targetRepo, err := git.PlainInit(targetConfig.URL, true)
+	require.NoError(err, "git init target")
+
+	gs := GitSync{
+		repoConfigs: map[string]config.Repository{"target": targetConfig},
+		mapping: &config.SyncMapping{
+			Id:     "source->target",
+			Source: "source",
+			SyncSpec: config.SyncSpec{
+				Branches: []matcher.M{matcher.FromStringOrPanic("main")},
+			},
+		},
+		source: &sourceRepo{
+			id:     "source",
+			config: &config.Repository{},
+			repo:   repo,
+		},
+		sourceRepoConfig: &config.Repository{},
+		pushOptions: map[string]git.PushOptions{
+			"target": {RemoteName: "target", RemoteURL: targetConfig.URL},
+		},
+		options: SyncOptions{Concurrency: 1},
+	}
+	require.NoError(gs.source.prepareTarget(ctx, "target", &targetConfig, slog.Default()))
+	updates := []refUpdate{{source: "refs/heads/main", target: "refs/heads/main"}}
+
+	// First push records the pushed ref
+	changes, errs := gs.pushToTargets(ctx, gs.targetIds(), updates, gs.mapping.Prune)
+	require.Empty(errs)
+	assert.Equal(t, map[string][]refChange{
+		"target": {{Ref: "refs/heads/main", NewHash: first.String()}},
+	}, changes)
+	assert.True(t, gs.source.state.isPushed(gs.mapping.Id, "target", "refs/heads/main", first))
+
+	// Unchanged ref is not pushed again
+	require.NoError(targetRepo.Storer.RemoveReference("refs/heads/main"))
+	changes, errs = gs.pushToTargets(ctx, gs.targetIds(), updates, gs.mapping.Prune)
+	require.Empty(errs)
+	assert.Empty(t, changes)
+	_, err = targetRepo.Reference("refs/heads/main", true)
+	assert.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+
+	// Another mapping with the same source and target doesn't share the state
+	mapping := gs.mapping
+	gs.mapping = &config.SyncMapping{Id: "other", Source: "source", SyncSpec: mapping.SyncSpec}
+	changes, errs = gs.pushToTargets(ctx, gs.targetIds(), updates, gs.mapping.Prune)
+	require.Empty(errs)
+	assert.Equal(t, map[string][]refChange{
+		"target": {{Ref: "refs/heads/main", NewHash: first.String()}},
+	}, changes)
+	gs.mapping = mapping
+	require.NoError(targetRepo.Storer.RemoveReference("refs/heads/main"))
+
+	// Full sync ignores the state
+	gs.options.ForceFullSync = true
+	_, errs = gs.pushToTargets(ctx, gs.targetIds(), updates, gs.mapping.Prune)
+	require.Empty(errs)
+	ref, err := targetRepo.Reference("refs/heads/main", true)
+	require.NoError(err, "target ref")
+	assert.Equal(t, first, ref.Hash())
+}
+
+func TestSyncSkipsPruningWhenMappingFails(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	repo, err := git.Init(memory.NewStorage(), nil)
+	require.NoError(err, "git init")
+	first := commitTo(t, repo, "first")
+	for _, branch := range []string{"main", "internal/main", "develop"} {
+		require.NoError(repo.Storer.SetReference(
+			plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), first),
+		))
+	}
+
+	targetConfig := config.Repository{URL: t.TempDir()}
+	targetRepo, err := git.PlainInit(targetConfig.URL, true)
+	require.NoError(err, "git init target")
+	gs := GitSync{
+		repoConfigs: map[string]config.Repository{"target": targetConfig},
+		mapping: &config.SyncMapping{
+			Source: "source",
+			SyncSpec: config.SyncSpec{
+				Branches:    []matcher.M{matcher.FromStringOrPanic(`/.*/`)},
+				RefMappings: []config.RefMapping{{StripPrefix: "internal/"}},
+				Prune:       true,
+			},
+		},
+		source: &sourceRepo{
+			id:     "source",
+			config: &config.Repository{},
+			repo:   repo,
+		},
+		sourceRepoConfig: &config.Repository{},
+		pushOptions: map[string]git.PushOptions{
+			"target": {RemoteName: "target", RemoteURL: targetConfig.URL},
+		},
+		options: SyncOptions{Concurrency: 1},
+	}
+	require.NoError(gs.source.prepareTarget(ctx, "target", &targetConfig, slog.Default()))
+	require.NoError(targetRepo.Storer.SetReference(
+		plumbing.NewHashReference("refs/heads/main", first),
+	))
+
+	res := gs.sync(ctx, time.Time{}, gs.targetIds())
+
+	require.ErrorContains(res.err(), "refs refs/heads/internal/main, refs/heads/main are all mapped to refs/heads/main")
+	require.Empty(res.targetErrs)
+	_, err = targetRepo.Reference("refs/heads/develop", true)
+	require.NoError(err, "valid refs are pushed")
+	_, err = targetRepo.Reference("refs/heads/main", true)
+	require.NoError(err, "colliding ref is not pruned")
+}
+
+func TestGetPrunableRefsWithRegexReplace(t *testing.T) {
+	gs := GitSync{
+		mapping: &config.SyncMapping{
+			Source: "source",
+			SyncSpec: config.SyncSpec{
+				Tags: []matcher.M{
+					matcher.FromStringOrPanic(`/^release-1\./`),
+					matcher.FromStringOrPanic(`/^(vendor|ext)-/`),
+				},
+				RefMappings: []config.RefMapping{
+					{
+						Refs:      config.RefMappingTags,
+						Match:     matcher.FromStringOrPanic(`/^release-([0-9]+)\.([0-9]+)$/`),
+						Replace:   "v$1.$2",
+						AddPrefix: "vendor/acme/",
+					},
+					{
+						Refs:    config.RefMappingTags,
+						Match:   matcher.FromStringOrPanic(`/^(?:vendor|ext)-(.*)$/`),
+						Replace: "ext/$1",
+					},
+				},
+				Prune: true,
+			},
+		},
+	}
+	hash := plumbing.NewHash("8bd9f3bd9fd1c2f5e1dc1c8b24a4e4e3a5bcab01")
+	targetRefs := map[string]plumbing.Hash{
+		"refs/tags/vendor/acme/v1.0": hash,
+		"refs/tags/vendor/acme/v1.1": hash,
+		"refs/tags/vendor/acme/v2.0": hash,
+		"refs/tags/vendor/acme/vx":   hash,
+		"refs/tags/ext/lib":          hash,
+	}
+	updates := []refUpdate{
+		{source: "refs/tags/release-1.1", target: "refs/tags/vendor/acme/v1.1"},
+	}
+
+	pruned := gs.getPrunableRefs(targetRefs, updates)
+
+	// v2.0 is produced from release-2.0, which is not selected by the tag list,
+	// and ext/lib can't be reversed to a source name.
+	assert.Equal(t, []string{"refs/tags/vendor/acme/v1.0"}, pruned)
+}
+
+func TestNextSyncJitter(t *testing.T) {
+	last := time.Date(2025, 5, 2, 12, 30, 0, 0, time.UTC)
+	gs := GitSync{
+		mapping: &config.SyncMapping{
+			SyncSpec: config.SyncSpec{
+				Interval: duration.New(time.Hour),
+				Jitter:   duration.New(time.Minute),
+			},
+		},
+	}
+	for range 10 {
+		next := gs.nextSync(last)
+		assert.False(t, next.Before(last.Add(time.Hour)), next)
+		assert.True(t, next.Before(last.Add(time.Hour+time.Minute)), next)
+	}
+}
+
+func TestDeferUntilAllowed(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	now := time.Date(2025, 5, 2, 12, 30, 0, 0, time.UTC)
+	gs := GitSync{
+		mapping: &config.SyncMapping{
+			SyncSpec: config.SyncSpec{
+				TimeZone: "UTC",
+				Windows:  []config.TimeWindow{{Start: "13:00", End: "14:00"}},
+			},
+		},
+	}
+
+	wait, deferred := gs.deferUntilAllowed(ctx, now)
+	assert.True(deferred)
+	assert.Equal(30*time.Minute, wait)
+	assert.Equal(now.Add(30*time.Minute), gs.status.deferredUntil.UTC())
+
+	_, deferred = gs.deferUntilAllowed(ctx, now.Add(30*time.Minute))
+	assert.False(deferred)
+
+	gs.options.IgnoreWindows = true
+	_, deferred = gs.deferUntilAllowed(ctx, now)
+	assert.False(deferred)
+}
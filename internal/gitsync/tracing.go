@@ -0,0 +1,89 @@
+package gitsync
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/tracing"
+)
+
+const (
+	spanInit     = "gitsync.init"
+	spanRun      = "gitsync.run"
+	spanListRefs = "gitsync.list_refs"
+	spanFetch    = "gitsync.fetch"
+	spanPush     = "gitsync.push"
+
+	attrSourceId       = "gitsync.source.id"
+	attrTargetId       = "gitsync.target.id"
+	attrTargetIds      = "gitsync.target.ids"
+	attrRunId          = "gitsync.run.id"
+	attrRefCount       = "gitsync.refs.count"
+	attrBranchCount    = "gitsync.refs.branches"
+	attrTagCount       = "gitsync.refs.tags"
+	attrOtherRefCount  = "gitsync.refs.others"
+	attrPushedRefCount = "gitsync.refs.pushed"
+	attrPrunedRefCount = "gitsync.refs.pruned"
+	attrErrorReasons   = "gitsync.error.reasons"
+)
+
+// newTracer creates a tracer from the config.
+// Nil is returned when tracing is disabled.
+func newTracer(cfg *config.Tracing, transport http.RoundTripper) *tracing.Tracer {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = config.AppName
+	}
+	return tracing.New(&tracing.Config{
+		Endpoint:    cfg.Endpoint,
+		Headers:     cfg.Headers,
+		ServiceName: serviceName,
+	}, transport)
+}
+
+// newRunId creates an ID used for correlating the logs of a single run.
+// The trace ID is used when the run is traced.
+func newRunId(span *tracing.Span) string {
+	if traceId := span.TraceId(); traceId != "" {
+		return traceId
+	}
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// endSpan ends the span, and marks it failed when there's an error.
+// Only the error reasons are recorded, so that the repository URLs
+// are left out of the traces.
+func endSpan(span *tracing.Span, err error) {
+	if err != nil {
+		reasons := errorReasons(err)
+		span.SetAttributes(tracing.Strings(attrErrorReasons, reasons))
+		span.SetError(strings.Join(reasons, "; "))
+	}
+	span.End()
+}
+
+// errorReasons lists the reasons of the repository errors
+// found from the possibly joined error.
+func errorReasons(err error) []string {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var reasons []string
+		for _, err := range joined.Unwrap() {
+			reasons = append(reasons, errorReasons(err)...)
+		}
+		return reasons
+	}
+	var repoErr *GitRepoError
+	if errors.As(err, &repoErr) {
+		return []string{repoErr.Reason}
+	}
+	return []string{err.Error()}
+}
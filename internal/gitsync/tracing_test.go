@@ -0,0 +1,131 @@
+package gitsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/tracing"
+)
+
+func TestErrorReasons(t *testing.T) {
+	err := errors.Join(
+		&GitRepoError{RepoId: "otk-gitlab", Reason: "failed to push to remote", Cause: errors.New("timeout")},
+		errors.New("unexpected"),
+	)
+	assert.Equal(t, []string{"failed to push to remote", "unexpected"}, errorReasons(err))
+}
+
+// otlpSpan contains the fields of the exported spans used in the tests.
+type otlpSpan struct {
+	Name       string `json:"name"`
+	Attributes []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+			IntValue    string `json:"intValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+	Status *struct {
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+func (s otlpSpan) attr(key string) string {
+	for _, attr := range s.Attributes {
+		if attr.Key == key {
+			return attr.Value.StringValue + attr.Value.IntValue
+		}
+	}
+	return ""
+}
+
+// otlpCollector is a stand-in for an OTLP/HTTP receiver.
+type otlpCollector struct {
+	mu    sync.Mutex
+	spans []otlpSpan
+}
+
+func (c *otlpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+}
+
+func TestPushToTargetsTraced(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	var collector otlpCollector
+	server := httptest.NewServer(&collector)
+	defer server.Close()
+
+	tracer := newTracer(&config.Tracing{Endpoint: server.URL}, http.DefaultTransport)
+	ctx := context.Background()
+	tracer.Start(ctx)
+	ctx = tracing.AddToContext(ctx, tracer)
+
+	repo, err := git.Init(memory.NewStorage(), nil)
+	require.NoError(err, "git init")
+	require.NoError(repo.Storer.SetReference(
+		plumbing.NewHashReference("refs/heads/main", commitTo(t, repo, "first")),
+	))
+	targetConfig := config.Repository{URL: t.TempDir()}
+	_, err = git.PlainInit(targetConfig.URL, true)
+	require.NoError(err, "git init target")
+
+	gs := GitSync{
+		repoConfigs:      map[string]config.Repository{"target": targetConfig},
+		mapping:          &config.SyncMapping{Source: "source"},
+		source:           &sourceRepo{id: "source", config: &config.Repository{}, repo: repo},
+		sourceRepoConfig: &config.Repository{},
+		pushOptions: map[string]git.PushOptions{
+			"target":  {RemoteName: "target", RemoteURL: targetConfig.URL},
+			"missing": {RemoteName: "missing", RemoteURL: targetConfig.URL},
+		},
+		options: SyncOptions{Concurrency: 1},
+	}
+	require.NoError(gs.source.prepareTarget(ctx, "target", &targetConfig, slog.Default()))
+	updates := []refUpdate{{source: "refs/heads/main", target: "refs/heads/main"}}
+	_, errs := gs.pushToTargets(ctx, gs.targetIds(), updates, gs.mapping.Prune)
+	require.Len(errs, 1)
+	tracer.Shutdown(ctx)
+
+	pushSpans := make(map[string]otlpSpan, 2)
+	for _, span := range collector.spans {
+		if span.Name == spanPush {
+			pushSpans[span.attr(attrTargetId)] = span
+		}
+	}
+	require.Len(pushSpans, 2)
+	assert.Equal("source", pushSpans["target"].attr(attrSourceId))
+	assert.Equal("1", pushSpans["target"].attr(attrPushedRefCount))
+	assert.Nil(pushSpans["target"].Status)
+	require.NotNil(pushSpans["missing"].Status)
+	assert.Equal("failed to push to remote", pushSpans["missing"].Status.Message)
+}
@@ -0,0 +1,275 @@
+package gitsync
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"go.lepovirta.org/otk/internal/gitsync/config"
+	"go.lepovirta.org/otk/internal/logging"
+)
+
+const (
+	defaultWebhookPath    = "/webhook"
+	webhookMaxPayloadSize = 10 * 1024 * 1024
+
+	headerGitHubEvent      = "X-GitHub-Event"
+	headerGitHubSignature  = "X-Hub-Signature-256"
+	headerGiteaEvent       = "X-Gitea-Event"
+	headerGiteaSignature   = "X-Gitea-Signature"
+	headerGitLabEvent      = "X-Gitlab-Event"
+	headerGitLabToken      = "X-Gitlab-Token"
+	headerGenericToken     = "X-Gitsync-Token"
+	headerGenericSignature = "X-Gitsync-Signature-256"
+	signaturePrefixSha256  = "sha256="
+)
+
+// webhookHandler receives push webhooks from Git hosting services
+// and wakes up the syncs for the pushed source repositories.
+type webhookHandler struct {
+	repos []webhookRepo
+}
+
+// webhookRepo is a source repository that the webhooks can be received for.
+type webhookRepo struct {
+	id     string
+	url    string
+	secret string
+	syncs  []*GitSync
+}
+
+// webhookRequest contains the details of a webhook
+// extracted from the request headers.
+type webhookRequest struct {
+	provider string
+	isPush   bool
+
+	// signature is the hex encoded HMAC-SHA256 signature of the payload
+	signature string
+
+	// token is the secret token sent as is
+	token string
+}
+
+// verify checks the signature or the token of the webhook
+// using the given secret.
+func (r *webhookRequest) verify(secret string, body []byte) bool {
+	if secret == "" {
+		return false
+	}
+	if r.token != "" {
+		return subtle.ConstantTimeCompare([]byte(r.token), []byte(secret)) == 1
+	}
+	if r.signature != "" {
+		signature, err := hex.DecodeString(r.signature)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = mac.Write(body)
+		return hmac.Equal(signature, mac.Sum(nil))
+	}
+	return false
+}
+
+// webhookPayload contains the repository URL fields found
+// in the push webhooks of the supported providers.
+type webhookPayload struct {
+	Repository struct {
+		// GitHub and Gitea
+		CloneURL string `json:"clone_url"`
+		SshURL   string `json:"ssh_url"`
+		HtmlURL  string `json:"html_url"`
+
+		// GitLab and generic
+		URL        string `json:"url"`
+		GitHttpURL string `json:"git_http_url"`
+		GitSshURL  string `json:"git_ssh_url"`
+		Homepage   string `json:"homepage"`
+	} `json:"repository"`
+	Project struct {
+		GitHttpURL string `json:"git_http_url"`
+		GitSshURL  string `json:"git_ssh_url"`
+		WebURL     string `json:"web_url"`
+	} `json:"project"`
+}
+
+func (p *webhookPayload) urls() []string {
+	urls := []string{
+		p.Repository.CloneURL,
+		p.Repository.SshURL,
+		p.Repository.HtmlURL,
+		p.Repository.URL,
+		p.Repository.GitHttpURL,
+		p.Repository.GitSshURL,
+		p.Repository.Homepage,
+		p.Project.GitHttpURL,
+		p.Project.GitSshURL,
+		p.Project.WebURL,
+	}
+	normalized := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if n := normalizeRepoURL(u); n != "" && !slices.Contains(normalized, n) {
+			normalized = append(normalized, n)
+		}
+	}
+	return normalized
+}
+
+func newWebhookHandler(
+	repoConfigs map[string]config.Repository,
+	gitSyncs []*GitSync,
+) *webhookHandler {
+	syncsBySource := make(map[string][]*GitSync, len(gitSyncs))
+	for _, gitSync := range gitSyncs {
+		source := gitSync.mapping.Source
+		syncsBySource[source] = append(syncsBySource[source], gitSync)
+	}
+
+	var h webhookHandler
+	for _, repoId := range slices.Sorted(maps.Keys(syncsBySource)) {
+		repoConfig := repoConfigs[repoId]
+		repoURL := normalizeRepoURL(repoConfig.URL)
+		if repoURL == "" {
+			continue
+		}
+		h.repos = append(h.repos, webhookRepo{
+			id:     repoId,
+			url:    repoURL,
+			secret: repoConfig.WebhookSecret,
+			syncs:  syncsBySource[repoId],
+		})
+	}
+	return &h
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logging.FromContext(ctx)
+
+	req := parseWebhookRequest(r)
+	log = log.With(slog.String("provider", req.provider))
+	if !req.isPush {
+		log.DebugContext(ctx, "ignoring webhook for other than push event")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxPayloadSize))
+	if err != nil {
+		log.WarnContext(ctx, "failed to read webhook payload", slog.Any("error", err))
+		http.Error(w, "failed to read payload", http.StatusBadRequest)
+		return
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		log.WarnContext(ctx, "failed to parse webhook payload", slog.Any("error", err))
+		http.Error(w, "failed to parse payload", http.StatusBadRequest)
+		return
+	}
+
+	repoURLs := payload.urls()
+	matched := false
+	woken := 0
+	for _, repo := range h.repos {
+		if !slices.Contains(repoURLs, repo.url) {
+			continue
+		}
+		matched = true
+		if !req.verify(repo.secret, body) {
+			log.WarnContext(ctx, "webhook verification failed", slog.String("repoId", repo.id))
+			continue
+		}
+		log.InfoContext(ctx, "webhook received, waking up syncs", slog.String("repoId", repo.id))
+		for _, gitSync := range repo.syncs {
+			gitSync.Wake()
+		}
+		woken++
+	}
+
+	switch {
+	case !matched:
+		log.DebugContext(ctx, "no repository found for webhook", slog.String("urls", strings.Join(repoURLs, ", ")))
+		http.Error(w, "no matching repository", http.StatusNotFound)
+	case woken == 0:
+		http.Error(w, "verification failed", http.StatusUnauthorized)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// parseWebhookRequest determines the webhook provider and event from the headers.
+// The request is treated as a generic webhook when it's not from a known provider.
+func parseWebhookRequest(r *http.Request) webhookRequest {
+	switch {
+	case r.Header.Get(headerGiteaEvent) != "":
+		// Gitea also sends the GitHub headers, so it's checked first
+		return webhookRequest{
+			provider:  "gitea",
+			isPush:    r.Header.Get(headerGiteaEvent) == "push",
+			signature: r.Header.Get(headerGiteaSignature),
+		}
+	case r.Header.Get(headerGitHubEvent) != "":
+		signature, _ := strings.CutPrefix(r.Header.Get(headerGitHubSignature), signaturePrefixSha256)
+		return webhookRequest{
+			provider:  "github",
+			isPush:    r.Header.Get(headerGitHubEvent) == "push",
+			signature: signature,
+		}
+	case r.Header.Get(headerGitLabEvent) != "":
+		event := r.Header.Get(headerGitLabEvent)
+		return webhookRequest{
+			provider: "gitlab",
+			isPush:   event == "Push Hook" || event == "Tag Push Hook",
+			token:    r.Header.Get(headerGitLabToken),
+		}
+	default:
+		signature, _ := strings.CutPrefix(r.Header.Get(headerGenericSignature), signaturePrefixSha256)
+		return webhookRequest{
+			provider:  "generic",
+			isPush:    true,
+			signature: signature,
+			token:     r.Header.Get(headerGenericToken),
+		}
+	}
+}
+
+// normalizeRepoURL reduces the repository URL to its host and path,
+// so that the HTTPS and SSH URLs of the same repository can be compared.
+// An empty string is returned when the URL is not recognised.
+func normalizeRepoURL(repoURL string) string {
+	repoURL = strings.TrimSpace(repoURL)
+	var host, path string
+	if strings.Contains(repoURL, "://") {
+		u, err := url.Parse(repoURL)
+		if err != nil {
+			return ""
+		}
+		host, path = u.Hostname(), u.Path
+	} else if before, after, ok := strings.Cut(repoURL, ":"); ok {
+		// SCP-like syntax e.g. git@github.com:jpallari/otk.git
+		_, host, _ = strings.Cut(before, "@")
+		if host == "" {
+			host = before
+		}
+		path = after
+	} else {
+		return ""
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if host == "" || path == "" {
+		return ""
+	}
+	return strings.ToLower(host + "/" + path)
+}
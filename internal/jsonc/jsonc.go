@@ -0,0 +1,76 @@
+// Package jsonc converts JSON with comments and trailing commas to standard JSON.
+package jsonc
+
+import (
+	"errors"
+)
+
+var ErrUnterminatedComment = errors.New("unterminated comment in JSON")
+
+// ToJSON replaces the line comments (`//`), the block comments (`/* */`),
+// and the trailing commas in arrays and objects with whitespace.
+// The line breaks are kept, so that the offsets and the line numbers
+// in the JSON parse errors match the original text.
+func ToJSON(b []byte) ([]byte, error) {
+	out := make([]byte, len(b))
+	copy(out, b)
+
+	// Index of the latest comma outside of strings, or -1 when
+	// a value has been seen since the comma.
+	lastComma := -1
+
+	for i := 0; i < len(out); i++ {
+		switch c := out[i]; {
+		case c == '"':
+			i = skipString(out, i)
+			lastComma = -1
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			end := i + 2
+			for ; end+1 < len(out) && (out[end] != '*' || out[end+1] != '/'); end++ {
+			}
+			if end+1 >= len(out) {
+				return nil, ErrUnterminatedComment
+			}
+			blank(out[i : end+2])
+			i = end + 1
+		case c == ',':
+			lastComma = i
+		case c == '}' || c == ']':
+			if lastComma >= 0 {
+				out[lastComma] = ' '
+			}
+			lastComma = -1
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		default:
+			lastComma = -1
+		}
+	}
+	return out, nil
+}
+
+// skipString returns the index of the closing quote of the string
+// starting at the given index.
+func skipString(b []byte, start int) int {
+	for i := start + 1; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return len(b)
+}
+
+// blank replaces the characters with spaces except for the line breaks.
+func blank(b []byte) {
+	for i := range b {
+		if b[i] != '\n' && b[i] != '\r' {
+			b[i] = ' '
+		}
+	}
+}
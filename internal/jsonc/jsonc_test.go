@@ -0,0 +1,40 @@
+package jsonc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToJSON(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	text := `{
+    // Line comment with "quotes"
+    "url": "https://github.com/jpallari/otk.git", /* block
+    comment */
+    "pattern": "/* not a comment */ // nor this",
+    "escaped": "quote \" and comma ,]",
+    "branches": [ "main", "develop", ],
+}`
+
+	b, err := ToJSON([]byte(text))
+	require.NoError(err)
+	assert.Len(b, len(text))
+
+	var v map[string]any
+	require.NoError(json.Unmarshal(b, &v), string(b))
+	assert.Equal(map[string]any{
+		"url":      "https://github.com/jpallari/otk.git",
+		"pattern":  "/* not a comment */ // nor this",
+		"escaped":  `quote " and comma ,]`,
+		"branches": []any{"main", "develop"},
+	}, v)
+}
+
+func TestToJSONUnterminatedComment(t *testing.T) {
+	_, err := ToJSON([]byte(`{ "url": "" /* comment`))
+	assert.ErrorIs(t, err, ErrUnterminatedComment)
+}
@@ -110,3 +110,17 @@ func (m *M) MatchString(s string) bool {
 	}
 	return m.spec == s
 }
+
+// Replace returns the replacement when the matcher matches the given string.
+// Otherwise, the string is returned as is. For regex matchers, every match
+// is replaced and the replacement may refer to the capture groups
+// using `$1`, `${name}`, etc.
+func (m *M) Replace(s string, replacement string) string {
+	if m.UsesRegex() {
+		return m.pattern.ReplaceAllString(s, replacement)
+	}
+	if m.MatchString(s) {
+		return replacement
+	}
+	return s
+}
@@ -97,3 +97,27 @@ func TestMatcherMatching(t *testing.T) {
 		assert.False(matcher.MatchString(""))
 	})
 }
+
+func TestMatcherReplace(t *testing.T) {
+	t.Run("replaces regex with capture groups", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		var matcher M
+		require.NoError(matcher.FromString(`/^v(.*)$/`))
+
+		assert.Equal("vendor/acme/v1.2.3", matcher.Replace("v1.2.3", "vendor/acme/v$1"))
+		assert.Equal("release-1", matcher.Replace("release-1", "vendor/acme/v$1"))
+	})
+
+	t.Run("replaces plain", func(t *testing.T) {
+		assert := assert.New(t)
+		require := require.New(t)
+
+		var matcher M
+		require.NoError(matcher.FromString("main"))
+
+		assert.Equal("upstream/main", matcher.Replace("main", "upstream/main"))
+		assert.Equal("develop", matcher.Replace("develop", "upstream/main"))
+	})
+}
@@ -0,0 +1,277 @@
+// Package metrics provides metrics that can be exposed
+// in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	labelSeparator = "\xff"
+)
+
+// DefaultBuckets are the histogram buckets suitable for
+// measuring durations of network operations in seconds.
+var DefaultBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// Registry contains the metrics to expose.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+type family interface {
+	write(w *bufio.Writer)
+}
+
+// metricFamily contains the series of a single metric.
+// The series are identified by their label values.
+type metricFamily[T any] struct {
+	mu         sync.Mutex
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	series     map[string]*T
+	newSeries  func() *T
+	writeFunc  func(w *bufio.Writer, name string, labels string, series *T)
+}
+
+func newFamily[T any](
+	r *Registry,
+	name string,
+	help string,
+	metricType string,
+	labelNames []string,
+) *metricFamily[T] {
+	f := &metricFamily[T]{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		series:     make(map[string]*T, 10),
+		newSeries:  func() *T { return new(T) },
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+	return f
+}
+
+// with calls fn with the series for the given label values while
+// holding the lock.
+func (f *metricFamily[T]) with(labelValues []string, fn func(*T)) {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf(
+			"metric %s expects %d label values, got %d",
+			f.name, len(f.labelNames), len(labelValues),
+		))
+	}
+	key := strings.Join(labelValues, labelSeparator)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = f.newSeries()
+		f.series[key] = s
+	}
+	fn(s)
+}
+
+func (f *metricFamily[T]) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.metricType)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		labels := formatLabels(f.labelNames, strings.Split(key, labelSeparator))
+		f.writeFunc(w, f.name, labels, f.series[key])
+	}
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	f *metricFamily[float64]
+}
+
+// NewCounterVec registers a new counter with the given label names.
+func (r *Registry) NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	f := newFamily[float64](r, name, help, "counter", labelNames)
+	f.writeFunc = writeValue
+	return &CounterVec{f: f}
+}
+
+// Inc increments the counter with the given label values by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments the counter with the given label values.
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	c.f.with(labelValues, func(v *float64) {
+		*v += value
+	})
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	f *metricFamily[float64]
+}
+
+// NewGaugeVec registers a new gauge with the given label names.
+func (r *Registry) NewGaugeVec(name string, help string, labelNames ...string) *GaugeVec {
+	f := newFamily[float64](r, name, help, "gauge", labelNames)
+	f.writeFunc = writeValue
+	return &GaugeVec{f: f}
+}
+
+// Set sets the gauge with the given label values.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.f.with(labelValues, func(v *float64) {
+		*v = value
+	})
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	f *metricFamily[histogram]
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// NewHistogramVec registers a new histogram with the given buckets and label names.
+func (r *Registry) NewHistogramVec(
+	name string,
+	help string,
+	buckets []float64,
+	labelNames ...string,
+) *HistogramVec {
+	buckets = slices.Sorted(slices.Values(buckets))
+	f := newFamily[histogram](r, name, help, "histogram", labelNames)
+	f.newSeries = func() *histogram {
+		return &histogram{
+			buckets: buckets,
+			counts:  make([]uint64, len(buckets)),
+		}
+	}
+	f.writeFunc = writeHistogram
+	return &HistogramVec{f: f}
+}
+
+// Observe adds the value to the histogram with the given label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.f.with(labelValues, func(s *histogram) {
+		for i, bucket := range s.buckets {
+			if value <= bucket {
+				s.counts[i]++
+			}
+		}
+		s.count++
+		s.sum += value
+	})
+}
+
+// Write writes all of the metrics in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		_ = r.Write(w)
+	})
+}
+
+func writeValue(w *bufio.Writer, name string, labels string, value *float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(*value))
+}
+
+func writeHistogram(w *bufio.Writer, name string, labels string, h *histogram) {
+	bucketLabels := func(le string) string {
+		if labels == "" {
+			return fmt.Sprintf(`{le="%s"}`, le)
+		}
+		return fmt.Sprintf(`%s,le="%s"}`, labels[:len(labels)-1], le)
+	}
+	for i, bucket := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, bucketLabels(formatFloat(bucket)), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, bucketLabels("+Inf"), h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(escapeLabelValue(values[i]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueReplacer.Replace(value)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return helpReplacer.Replace(help)
+}
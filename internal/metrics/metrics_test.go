@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	var r Registry
+	counter := r.NewCounterVec("test_attempts_total", "Number of attempts.", "source", "target")
+	gauge := r.NewGaugeVec("test_last_success_seconds", "Time of the last success.", "source")
+	histogram := r.NewHistogramVec("test_duration_seconds", "Duration of the operation.", []float64{1, 0.5}, "source")
+	unlabeled := r.NewCounterVec("test_runs_total", "Number of runs.\nPer process.")
+
+	counter.Inc("otk", "gitlab")
+	counter.Inc("otk", "gitlab")
+	counter.Add(3, "keruu", `quoted "target"`)
+	gauge.Set(1714564800, "otk")
+	histogram.Observe(0.25, "otk")
+	histogram.Observe(0.75, "otk")
+	histogram.Observe(2, "otk")
+	unlabeled.Inc()
+
+	var buf bytes.Buffer
+	require.NoError(t, r.Write(&buf))
+	assert.Equal(t, `# HELP test_attempts_total Number of attempts.
+# TYPE test_attempts_total counter
+test_attempts_total{source="keruu",target="quoted \"target\""} 3
+test_attempts_total{source="otk",target="gitlab"} 2
+# HELP test_last_success_seconds Time of the last success.
+# TYPE test_last_success_seconds gauge
+test_last_success_seconds{source="otk"} 1.7145648e+09
+# HELP test_duration_seconds Duration of the operation.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{source="otk",le="0.5"} 1
+test_duration_seconds_bucket{source="otk",le="1"} 2
+test_duration_seconds_bucket{source="otk",le="+Inf"} 3
+test_duration_seconds_sum{source="otk"} 3
+test_duration_seconds_count{source="otk"} 3
+# HELP test_runs_total Number of runs.\nPer process.
+# TYPE test_runs_total counter
+test_runs_total 1
+`, buf.String())
+}
+
+func TestLabelValueCount(t *testing.T) {
+	var r Registry
+	counter := r.NewCounterVec("test_total", "Test.", "source")
+	assert.Panics(t, func() { counter.Inc("otk", "gitlab") })
+}
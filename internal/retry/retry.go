@@ -39,7 +39,7 @@ func ExponentialBackoff(minDelay, maxDelay time.Duration) DelayFunc {
 }
 
 func (f DelayFunc) WithFullJitter(
-	rand rand.Rand,
+	rand *rand.Rand,
 ) DelayFunc {
 	return func(attemptNr int) time.Duration {
 		delay := f(attemptNr)
@@ -49,7 +49,7 @@ func (f DelayFunc) WithFullJitter(
 }
 
 func (f DelayFunc) WithJitter(
-	rand rand.Rand,
+	rand *rand.Rand,
 	maxJitter time.Duration,
 ) DelayFunc {
 	return func(attemptNr int) time.Duration {
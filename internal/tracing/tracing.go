@@ -0,0 +1,415 @@
+// Package tracing provides spans that are exported to an OpenTelemetry
+// collector using OTLP over HTTP with the JSON encoding.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.lepovirta.org/otk/internal/logging"
+)
+
+const (
+	tracesPath       = "/v1/traces"
+	exportInterval   = 5 * time.Second
+	exportTimeout    = 10 * time.Second
+	maxBatchSize     = 512
+	maxQueueSize     = 2048
+	spanKindInternal = 1
+	statusCodeError  = 2
+)
+
+// Config specifies where the spans are exported to.
+type Config struct {
+	// Endpoint is the base URL of the OTLP/HTTP receiver e.g. `http://localhost:4318`.
+	Endpoint string
+
+	// Headers contains the HTTP headers sent with each export.
+	Headers map[string]string
+
+	// ServiceName is used as the `service.name` resource attribute.
+	ServiceName string
+}
+
+// Tracer collects the ended spans and exports them in batches.
+// A nil tracer is valid and discards all of the spans.
+type Tracer struct {
+	client      *http.Client
+	url         string
+	headers     map[string]string
+	serviceName string
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// New creates a tracer that exports the spans using the given HTTP transport.
+// The export loop is started with Start.
+func New(cfg *Config, transport http.RoundTripper) *Tracer {
+	return &Tracer{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   exportTimeout,
+		},
+		url:         strings.TrimSuffix(cfg.Endpoint, "/") + tracesPath,
+		headers:     cfg.Headers,
+		serviceName: cfg.ServiceName,
+		flush:       make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start runs the export loop in the background until Shutdown is called.
+func (t *Tracer) Start(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(exportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-t.flush:
+			case <-t.stop:
+				return
+			}
+			t.export(ctx)
+		}
+	}()
+}
+
+// Shutdown stops the export loop started with Start,
+// and exports the remaining spans.
+func (t *Tracer) Shutdown(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+	t.export(ctx)
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.queue) >= maxQueueSize {
+		t.dropped++
+		return
+	}
+	t.queue = append(t.queue, span)
+	if len(t.queue) >= maxBatchSize {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// export sends all of the queued spans in batches.
+// Failed batches are logged and discarded.
+func (t *Tracer) export(ctx context.Context) {
+	log := logging.FromContext(ctx)
+
+	t.mu.Lock()
+	queue := t.queue
+	dropped := t.dropped
+	t.queue = nil
+	t.dropped = 0
+	t.mu.Unlock()
+
+	if dropped > 0 {
+		log.WarnContext(ctx, "trace queue full, spans dropped", slog.Int("count", dropped))
+	}
+	for start := 0; start < len(queue); start += maxBatchSize {
+		batch := queue[start:min(start+maxBatchSize, len(queue))]
+		if err := t.send(ctx, batch); err != nil {
+			log.WarnContext(ctx, "failed to export spans", slog.Int("count", len(batch)), slog.Any("error", err))
+		}
+	}
+}
+
+func (t *Tracer) send(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(t.newRequest(spans))
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send spans: %w", err)
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d from '%s'", res.StatusCode, t.url)
+	}
+	return nil
+}
+
+type tracerCtxKey struct{}
+type spanCtxKey struct{}
+
+// AddToContext adds the tracer to the context.
+// Spans are only recorded when there's a tracer in the context.
+func AddToContext(ctx context.Context, tracer *Tracer) context.Context {
+	return context.WithValue(ctx, tracerCtxKey{}, tracer)
+}
+
+// Start starts a new span as a child of the span in the context.
+// A nil span is returned when there's no tracer in the context.
+func Start(ctx context.Context, name string, attrs ...Attr) (context.Context, *Span) {
+	tracer, _ := ctx.Value(tracerCtxKey{}).(*Tracer)
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer: tracer,
+		name:   name,
+		start:  time.Now(),
+		attrs:  attrs,
+	}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.traceId = parent.traceId
+		span.parentId = parent.spanId
+	} else {
+		_, _ = rand.Read(span.traceId[:])
+	}
+	_, _ = rand.Read(span.spanId[:])
+	return context.WithValue(ctx, spanCtxKey{}, span), span
+}
+
+// SpanFromContext returns the current span from the context,
+// or nil when there's no span in the context.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanCtxKey{}).(*Span)
+	return span
+}
+
+// Span describes a single operation within a trace.
+// A nil span is valid and ignores all of the calls.
+type Span struct {
+	tracer   *Tracer
+	traceId  [16]byte
+	spanId   [8]byte
+	parentId [8]byte
+	name     string
+	start    time.Time
+
+	mu       sync.Mutex
+	end      time.Time
+	attrs    []Attr
+	failed   bool
+	errorMsg string
+}
+
+// TraceId returns the trace ID in hex format, or an empty string
+// when the span is nil.
+func (s *Span) TraceId() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceId[:])
+}
+
+// SetAttributes adds the attributes to the span.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attrs...)
+}
+
+// SetError marks the span failed with the given message.
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = true
+	s.errorMsg = message
+}
+
+// End ends the span and queues it for export.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	s.tracer.enqueue(s)
+}
+
+// Attr is a key-value pair attached to a span.
+type Attr struct {
+	Key   string
+	Value attrValue
+}
+
+// String creates a string attribute.
+func String(key string, value string) Attr {
+	return Attr{Key: key, Value: attrValue{StringValue: &value}}
+}
+
+// Int creates an integer attribute.
+func Int(key string, value int) Attr {
+	s := strconv.Itoa(value)
+	return Attr{Key: key, Value: attrValue{IntValue: &s}}
+}
+
+// Bool creates a boolean attribute.
+func Bool(key string, value bool) Attr {
+	return Attr{Key: key, Value: attrValue{BoolValue: &value}}
+}
+
+// Strings creates a string array attribute.
+func Strings(key string, values []string) Attr {
+	values = slices.Clone(values)
+	array := arrayValue{Values: make([]attrValue, len(values))}
+	for i := range values {
+		array.Values[i].StringValue = &values[i]
+	}
+	return Attr{Key: key, Value: attrValue{ArrayValue: &array}}
+}
+
+// The types below follow the OTLP JSON encoding of the trace export request.
+// See: https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type attrValue struct {
+	StringValue *string     `json:"stringValue,omitempty"`
+	IntValue    *string     `json:"intValue,omitempty"`
+	BoolValue   *bool       `json:"boolValue,omitempty"`
+	ArrayValue  *arrayValue `json:"arrayValue,omitempty"`
+}
+
+type arrayValue struct {
+	Values []attrValue `json:"values"`
+}
+
+type keyValue struct {
+	Key   string    `json:"key"`
+	Value attrValue `json:"value"`
+}
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanData `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type spanData struct {
+	TraceId           string     `json:"traceId"`
+	SpanId            string     `json:"spanId"`
+	ParentSpanId      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            *status    `json:"status,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func (t *Tracer) newRequest(spans []*Span) *exportRequest {
+	data := make([]spanData, len(spans))
+	for i, span := range spans {
+		data[i] = span.data()
+	}
+	return &exportRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{
+				Attributes: []keyValue{
+					keyValue(String("service.name", t.serviceName)),
+				},
+			},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: t.serviceName},
+				Spans: data,
+			}},
+		}},
+	}
+}
+
+func (s *Span) data() spanData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := spanData{
+		TraceId:           hex.EncodeToString(s.traceId[:]),
+		SpanId:            hex.EncodeToString(s.spanId[:]),
+		Name:              s.name,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		Attributes:        make([]keyValue, len(s.attrs)),
+	}
+	if s.parentId != [8]byte{} {
+		data.ParentSpanId = hex.EncodeToString(s.parentId[:])
+	}
+	for i, attr := range s.attrs {
+		data.Attributes[i] = keyValue(attr)
+	}
+	if s.failed {
+		data.Status = &status{Code: statusCodeError, Message: s.errorMsg}
+	}
+	return data
+}
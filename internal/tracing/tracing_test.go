@@ -0,0 +1,96 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collector is a stand-in for an OTLP/HTTP receiver.
+type collector struct {
+	mu       sync.Mutex
+	requests []exportRequest
+	headers  []http.Header
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req exportRequest
+	if r.URL.Path != tracesPath || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, req)
+	c.headers = append(c.headers, r.Header)
+}
+
+func TestTracer(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	var c collector
+	server := httptest.NewServer(&c)
+	defer server.Close()
+
+	tracer := New(&Config{
+		Endpoint:    server.URL + "/",
+		Headers:     map[string]string{"Authorization": "Bearer token"},
+		ServiceName: "gitsync",
+	}, http.DefaultTransport)
+	ctx := context.Background()
+	tracer.Start(ctx)
+
+	ctx = AddToContext(ctx, tracer)
+	ctx, root := Start(ctx, "run", String("source", "otk"))
+	_, child := Start(ctx, "push", Int("refs", 2), Bool("forced", true))
+	child.SetAttributes(Strings("errors", []string{"failed to push"}))
+	child.SetError("failed to push")
+	child.End()
+	root.End()
+	root.End()
+	tracer.Shutdown(context.Background())
+
+	require.Len(c.requests, 1)
+	assert.Equal("Bearer token", c.headers[0].Get("Authorization"))
+	assert.Equal("application/json", c.headers[0].Get("Content-Type"))
+	require.Len(c.requests[0].ResourceSpans, 1)
+	resourceSpans := c.requests[0].ResourceSpans[0]
+	assert.Equal("gitsync", *resourceSpans.Resource.Attributes[0].Value.StringValue)
+	require.Len(resourceSpans.ScopeSpans, 1)
+	spans := resourceSpans.ScopeSpans[0].Spans
+	require.Len(spans, 2)
+
+	pushSpan, runSpan := spans[0], spans[1]
+	assert.Equal("push", pushSpan.Name)
+	assert.Equal("run", runSpan.Name)
+	assert.Equal(root.TraceId(), runSpan.TraceId)
+	assert.Equal(runSpan.TraceId, pushSpan.TraceId)
+	assert.Equal(runSpan.SpanId, pushSpan.ParentSpanId)
+	assert.Empty(runSpan.ParentSpanId)
+	assert.Nil(runSpan.Status)
+	assert.Equal(&status{Code: statusCodeError, Message: "failed to push"}, pushSpan.Status)
+
+	assert.Equal("refs", pushSpan.Attributes[0].Key)
+	assert.Equal("2", *pushSpan.Attributes[0].Value.IntValue)
+	assert.True(*pushSpan.Attributes[1].Value.BoolValue)
+	assert.Equal("failed to push", *pushSpan.Attributes[2].Value.ArrayValue.Values[0].StringValue)
+}
+
+func TestStartWithoutTracer(t *testing.T) {
+	ctx := context.Background()
+	spanCtx, span := Start(ctx, "run")
+	assert.Nil(t, span)
+	assert.Equal(t, ctx, spanCtx)
+	assert.Empty(t, span.TraceId())
+
+	// Nil spans are safe to use
+	span.SetAttributes(String("source", "otk"))
+	span.SetError("failed")
+	span.End()
+}